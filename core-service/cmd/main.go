@@ -5,10 +5,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
 	"github.com/kenahrens/crm-demo/core-service/pkg/handlers"
+	"github.com/kenahrens/crm-demo/core-service/pkg/imports"
+	"github.com/kenahrens/crm-demo/core-service/pkg/mail"
 )
 
 func main() {
@@ -43,29 +47,123 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Load token signing configuration
+	authConfig, err := auth.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load auth configuration: %v", err)
+	}
+	tokenService := auth.NewTokenService(authConfig)
+
+	// Load the failed login policy
+	lockoutPolicy, err := auth.LoadLockoutPolicy()
+	if err != nil {
+		log.Fatalf("Failed to load login lockout policy: %v", err)
+	}
+
+	// Load the multi-factor authentication policy
+	mfaConfig, err := auth.LoadMFAConfig()
+	if err != nil {
+		log.Fatalf("Failed to load MFA configuration: %v", err)
+	}
+
+	// Load the single sign-on configuration
+	oidcConfig, err := auth.LoadOIDCConfig()
+	if err != nil {
+		log.Fatalf("Failed to load OIDC configuration: %v", err)
+	}
+
+	// Set up outgoing email
+	mailConfig := mail.LoadConfig()
+	mailer, err := mail.New(mailConfig)
+	if err != nil {
+		log.Fatalf("Failed to configure mail delivery: %v", err)
+	}
+	notifier := mail.NewNotifier(mailer, mailConfig.AppURL)
+
+	// Load how long deleted records stay in the trash
+	trashConfig, err := db.LoadTrashConfig()
+	if err != nil {
+		log.Fatalf("Failed to load trash configuration: %v", err)
+	}
+
+	// Load the CSV import limits
+	importConfig, err := imports.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load import configuration: %v", err)
+	}
+
+	// Load the rules new contacts and accounts are checked against for duplicates
+	duplicateConfig, err := db.LoadDuplicateConfig()
+	if err != nil {
+		log.Fatalf("Failed to load duplicate rule configuration: %v", err)
+	}
+
 	// Initialize repositories
-	accountRepo := db.NewAccountRepository(database)
-	contactRepo := db.NewContactRepository(database)
+	accountRepo := db.NewAccountRepository(database, duplicateConfig)
+	contactRepo := db.NewContactRepository(database, duplicateConfig)
 	opportunityRepo := db.NewOpportunityRepository(database)
 	noteRepo := db.NewNoteRepository(database)
 	userRepo := db.NewUserRepository(database)
+	searchRepo := db.NewSearchRepository(database)
+	sessionRepo := db.NewSessionRepository(database)
+	userTokenRepo := db.NewUserTokenRepository(database)
+	loginAttemptRepo := db.NewLoginAttemptRepository(database)
+	apiKeyRepo := db.NewAPIKeyRepository(database)
+	mfaRepo := db.NewMFARepository(database)
+	oidcRepo := db.NewOIDCRepository(database)
+	roleRepo := db.NewRoleRepository(database)
+	teamRepo := db.NewTeamRepository(database)
+	auditRepo := db.NewAuditRepository(database)
+	trashRepo := db.NewTrashRepository(database, trashConfig)
+	importRepo := db.NewImportRepository(database, duplicateConfig)
+	customFieldRepo := db.NewCustomFieldRepository(database)
+	importer := imports.New(importRepo, accountRepo, customFieldRepo)
 
 	// Initialize handlers
-	accountHandler := handlers.NewAccountHandler(accountRepo)
-	contactHandler := handlers.NewContactHandler(contactRepo)
-	opportunityHandler := handlers.NewOpportunityHandler(opportunityRepo)
+	accountHandler := handlers.NewAccountHandler(accountRepo, customFieldRepo)
+	contactHandler := handlers.NewContactHandler(contactRepo, customFieldRepo)
+	opportunityHandler := handlers.NewOpportunityHandler(opportunityRepo, customFieldRepo)
 	noteHandler := handlers.NewNoteHandler(noteRepo)
-	userHandler := handlers.NewUserHandler(userRepo)
+	userHandler := handlers.NewUserHandler(userRepo, sessionRepo, userTokenRepo, loginAttemptRepo, mfaRepo, tokenService, lockoutPolicy, mfaConfig, notifier)
+	searchHandler := handlers.NewSearchHandler(searchRepo)
+	jwksHandler := handlers.NewJWKSHandler(tokenService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepo)
+	oidcHandler := handlers.NewOIDCHandler(userHandler, oidcRepo, auth.NewOIDCProvider(oidcConfig))
+	roleHandler := handlers.NewRoleHandler(roleRepo, sessionRepo)
+	teamHandler := handlers.NewTeamHandler(teamRepo)
+	auditHandler := handlers.NewAuditHandler(auditRepo)
+	trashHandler := handlers.NewTrashHandler(trashRepo)
+	importHandler := handlers.NewImportHandler(importer, importRepo, importConfig)
+	customFieldHandler := handlers.NewCustomFieldHandler(customFieldRepo)
+
+	// Purge records that have been in the trash longer than the retention period
+	if trashConfig.Retention > 0 {
+		go trashRepo.PurgeEvery(trashConfig.PurgeInterval)
+	}
+
+	// Import uploaded CSV files in the background
+	go importer.RunEvery(importConfig.PollInterval)
 
 	// Set up Gin router
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from known proxies, so clients cannot spoof the IP used for login throttling
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		if err := router.SetTrustedProxies(strings.Split(trustedProxies, ",")); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
+	// Tag every request with an ID for the audit log
+	router.Use(handlers.RequestID())
+
 	// Set up CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Request-ID, If-Match, If-None-Match, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -75,6 +173,9 @@ func main() {
 		c.Next()
 	})
 
+	// Publish token verification keys for other services
+	jwksHandler.RegisterRoutes(&router.RouterGroup)
+
 	// Set up API endpoints
 	apiV1 := router.Group("/v1/api")
 	{
@@ -101,24 +202,42 @@ func main() {
 
 		// Public routes (no authentication required)
 		userHandler.RegisterAuthRoutes(apiV1) // Register only auth routes like login
+		if oidcConfig.Enabled() {
+			oidcHandler.RegisterRoutes(apiV1) // Single sign-on through the identity provider
+		}
+
+		// Authenticated routes that stay reachable before MFA enrollment
+		authenticatedApi := apiV1.Group("")
+		authenticatedApi.Use(handlers.AuthMiddleware(tokenService, sessionRepo, apiKeyRepo))
+		{
+			userHandler.RegisterMFARoutes(authenticatedApi) // Second factor enrollment
+		}
 
-		// Secure routes (authentication required)
-		secureApi := apiV1.Group("")
-		secureApi.Use(handlers.AuthMiddleware())
+		// Secure routes (authentication required, and MFA for roles that require it);
+		// each handler checks the permissions of its routes
+		secureApi := authenticatedApi.Group("")
+		secureApi.Use(handlers.RequireMFA(mfaConfig))
 		{
 			// Register all secure routes
 			userHandler.RegisterSecureRoutes(secureApi)  // User management requires auth
+			apiKeyHandler.RegisterRoutes(secureApi)      // Personal API keys
 			accountHandler.RegisterRoutes(secureApi)     // Protect account routes
 			contactHandler.RegisterRoutes(secureApi)     // Protect contact routes
 			opportunityHandler.RegisterRoutes(secureApi) // Protect opportunity routes
 			noteHandler.RegisterRoutes(secureApi)        // Protect note routes
+			searchHandler.RegisterRoutes(secureApi)      // Protect search routes
+			teamHandler.RegisterRoutes(secureApi)        // Teams that share records
+			auditHandler.RegisterRoutes(secureApi)       // Audit log and record histories
+			trashHandler.RegisterRoutes(secureApi)       // Deleted records
+			importHandler.RegisterRoutes(secureApi)      // CSV imports
+			customFieldHandler.RegisterRoutes(secureApi) // Custom fields of records
 		}
 
 		// Admin-only routes
 		adminApi := secureApi.Group("")
-		adminApi.Use(handlers.RequireRole("admin"))
+		adminApi.Use(handlers.RequirePermission(auth.PermUsersAdmin))
 		{
-			// Add admin-specific endpoints here if needed
+			roleHandler.RegisterRoutes(adminApi) // Roles and permissions
 		}
 	}
 
@@ -0,0 +1,11 @@
+-- Drop search indexes
+DROP INDEX IF EXISTS idx_notes_search_vector;
+DROP INDEX IF EXISTS idx_opportunities_search_vector;
+DROP INDEX IF EXISTS idx_contacts_search_vector;
+DROP INDEX IF EXISTS idx_accounts_search_vector;
+
+-- Drop search vector columns
+ALTER TABLE notes DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE contacts DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE accounts DROP COLUMN IF EXISTS search_vector;
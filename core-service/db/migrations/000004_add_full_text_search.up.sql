@@ -0,0 +1,30 @@
+-- Add generated full-text search vectors to the CRM tables.
+-- Weights: A = primary name/content, B = secondary identifying fields, C = location details.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(industry, '') || ' ' || coalesce(website, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(address, '') || ' ' || coalesce(city, '') || ' ' || coalesce(state, '') || ' ' || coalesce(country, '')), 'C')
+    ) STORED;
+
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(first_name, '') || ' ' || coalesce(last_name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(email, '') || ' ' || coalesce(title, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(city, '') || ' ' || coalesce(state, '') || ' ' || coalesce(country, '')), 'C')
+    ) STORED;
+
+ALTER TABLE opportunities ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(opportunity_name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(stage, '')), 'B')
+    ) STORED;
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED;
+
+-- Create GIN indexes so @@ queries do not scan the tables
+CREATE INDEX IF NOT EXISTS idx_accounts_search_vector ON accounts USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_contacts_search_vector ON contacts USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_opportunities_search_vector ON opportunities USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector);
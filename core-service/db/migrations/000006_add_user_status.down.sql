@@ -0,0 +1,3 @@
+-- Remove account status columns from users table
+ALTER TABLE users DROP COLUMN IF EXISTS deactivated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS is_active;
@@ -0,0 +1,8 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_user_tokens_user_id;
+
+-- Drop user_tokens table
+DROP TABLE IF EXISTS user_tokens;
+
+-- Remove email verification column from users table
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,8 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_login_attempts_created_at;
+DROP INDEX IF EXISTS idx_login_attempts_ip_address;
+DROP INDEX IF EXISTS idx_login_attempts_email;
+
+-- Drop tables
+DROP TABLE IF EXISTS login_throttles;
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,26 @@
+-- Create login_attempts table: audit trail of every login attempt, including unknown email addresses
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    email VARCHAR(255) NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    succeeded BOOLEAN NOT NULL,
+    failure_reason VARCHAR(50), -- 'invalid_credentials', 'locked', 'throttled', 'deactivated'
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create login_throttles table: failed-attempt counters per email address and per client IP
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope VARCHAR(10) NOT NULL, -- 'email', 'ip'
+    key VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
+
+-- Create indexes for performance
+CREATE INDEX idx_login_attempts_email ON login_attempts(LOWER(email), created_at);
+CREATE INDEX idx_login_attempts_ip_address ON login_attempts(ip_address, created_at);
+CREATE INDEX idx_login_attempts_created_at ON login_attempts(created_at);
@@ -0,0 +1,13 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_mfa_challenges_user_id;
+DROP INDEX IF EXISTS idx_mfa_recovery_codes_user_id;
+
+-- Drop tables
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+-- Remove MFA columns
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS mfa_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_secret;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_enabled;
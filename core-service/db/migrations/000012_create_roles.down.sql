@@ -0,0 +1,9 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_users_role;
+
+-- Remove the role constraint
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_role;
+
+-- Drop tables
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,55 @@
+-- Create roles table: named sets of permissions assigned to users; built-in roles cannot be changed
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT,
+    is_builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create role_permissions table: the permissions granted by each role, such as accounts:read
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_name VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role_name, permission)
+);
+
+-- Seed the built-in roles
+INSERT INTO roles (name, description, is_builtin) VALUES
+    ('admin', 'Full access, including user and role management', TRUE),
+    ('user', 'Reads and writes CRM records', TRUE),
+    ('read_only', 'Reads CRM records and users without changing anything', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission)
+SELECT 'admin', permission FROM unnest(ARRAY[
+    'accounts:read', 'accounts:write', 'contacts:read', 'contacts:write',
+    'opportunities:read', 'opportunities:write', 'notes:read', 'notes:write',
+    'users:read', 'users:admin'
+]) AS permission
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission)
+SELECT 'user', permission FROM unnest(ARRAY[
+    'accounts:read', 'accounts:write', 'contacts:read', 'contacts:write',
+    'opportunities:read', 'opportunities:write', 'notes:read', 'notes:write',
+    'users:read'
+]) AS permission
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission)
+SELECT 'read_only', permission FROM unnest(ARRAY[
+    'accounts:read', 'contacts:read', 'opportunities:read', 'notes:read', 'users:read'
+]) AS permission
+ON CONFLICT DO NOTHING;
+
+-- Keep any other roles already assigned to users, without permissions until an admin grants them
+INSERT INTO roles (name, description)
+SELECT DISTINCT role, 'Created from existing user roles' FROM users WHERE role IS NOT NULL
+ON CONFLICT (name) DO NOTHING;
+
+-- Users can only be given roles that exist
+ALTER TABLE users ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES roles(name);
+
+-- Create indexes for performance
+CREATE INDEX idx_users_role ON users(role);
@@ -0,0 +1,23 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_notes_owner_id;
+DROP INDEX IF EXISTS idx_opportunities_owner_id;
+DROP INDEX IF EXISTS idx_contacts_owner_id;
+DROP INDEX IF EXISTS idx_accounts_owner_id;
+DROP INDEX IF EXISTS idx_team_members_user_id;
+
+-- Remove the admin bypass
+DELETE FROM role_permissions WHERE permission = 'records:admin';
+
+-- Remove ownership columns
+ALTER TABLE notes DROP COLUMN IF EXISTS visibility;
+ALTER TABLE notes DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS visibility;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE contacts DROP COLUMN IF EXISTS visibility;
+ALTER TABLE contacts DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE accounts DROP COLUMN IF EXISTS visibility;
+ALTER TABLE accounts DROP COLUMN IF EXISTS owner_id;
+
+-- Drop tables
+DROP TABLE IF EXISTS team_members;
+DROP TABLE IF EXISTS teams;
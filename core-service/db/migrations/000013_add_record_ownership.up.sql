@@ -0,0 +1,47 @@
+-- Create teams table: groups of users who share records with team visibility
+CREATE TABLE IF NOT EXISTS teams (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create team_members table for the many-to-many relationship between teams and users
+CREATE TABLE IF NOT EXISTS team_members (
+    team_id UUID NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (team_id, user_id)
+);
+
+-- Give every record an owner and a visibility: private (owner only), team (owner's teams) or public (everyone).
+-- Existing records are owned by their creator and stay public, so nobody loses access.
+DO $$
+DECLARE
+    fallback_owner_id UUID;
+    record_table TEXT;
+BEGIN
+    -- Notes may have lost their creator; they go to the first admin
+    SELECT id INTO fallback_owner_id FROM users ORDER BY (role = 'admin') DESC, created_at LIMIT 1;
+
+    FOREACH record_table IN ARRAY ARRAY['accounts', 'contacts', 'opportunities', 'notes'] LOOP
+        EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id)', record_table);
+        EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS visibility VARCHAR(20) NOT NULL DEFAULT ''public''
+                        CHECK (visibility IN (''private'', ''team'', ''public''))', record_table);
+        EXECUTE format('UPDATE %I SET owner_id = COALESCE(created_by, $1) WHERE owner_id IS NULL', record_table)
+            USING fallback_owner_id;
+        EXECUTE format('ALTER TABLE %I ALTER COLUMN owner_id SET NOT NULL', record_table);
+    END LOOP;
+END $$;
+
+-- Admins see and change every record
+INSERT INTO role_permissions (role_name, permission) VALUES ('admin', 'records:admin')
+ON CONFLICT DO NOTHING;
+
+-- Create indexes for performance
+CREATE INDEX idx_team_members_user_id ON team_members(user_id);
+CREATE INDEX idx_accounts_owner_id ON accounts(owner_id);
+CREATE INDEX idx_contacts_owner_id ON contacts(owner_id);
+CREATE INDEX idx_opportunities_owner_id ON opportunities(owner_id);
+CREATE INDEX idx_notes_owner_id ON notes(owner_id);
@@ -0,0 +1,5 @@
+-- Remove updated_by columns
+ALTER TABLE notes DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE contacts DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE accounts DROP COLUMN IF EXISTS updated_by;
@@ -0,0 +1,10 @@
+-- Record who last changed each record. Existing records were last attributed to their creator.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS updated_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS updated_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE opportunities ADD COLUMN IF NOT EXISTS updated_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS updated_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+UPDATE accounts SET updated_by = created_by WHERE updated_by IS NULL;
+UPDATE contacts SET updated_by = created_by WHERE updated_by IS NULL;
+UPDATE opportunities SET updated_by = created_by WHERE updated_by IS NULL;
+UPDATE notes SET updated_by = created_by WHERE updated_by IS NULL;
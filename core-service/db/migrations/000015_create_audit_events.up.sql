@@ -0,0 +1,27 @@
+-- Create audit_events table: an append-only log of every change to records and users
+CREATE TABLE IF NOT EXISTS audit_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_id UUID, -- No foreign key, so events outlive the user who made them; NULL for changes made by the system
+    request_id VARCHAR(128),
+    entity_type VARCHAR(20) NOT NULL, -- 'account', 'contact', 'opportunity', 'note', 'user'
+    entity_id UUID NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    changes JSONB NOT NULL -- Field -> {"old": ..., "new": ...}
+);
+
+-- Reject any attempt to change or remove an event
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+-- Create indexes for performance
+CREATE INDEX idx_audit_events_entity ON audit_events(entity_type, entity_id, occurred_at);
+CREATE INDEX idx_audit_events_actor_id ON audit_events(actor_id, occurred_at);
+CREATE INDEX idx_audit_events_occurred_at ON audit_events(occurred_at);
@@ -0,0 +1,26 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_notes_deleted_at;
+DROP INDEX IF EXISTS idx_opportunities_deleted_at;
+DROP INDEX IF EXISTS idx_contacts_deleted_at;
+DROP INDEX IF EXISTS idx_accounts_deleted_at;
+
+-- Restore the original audit actions; existing restore and purge events are kept
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_action_check;
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_action_check
+    CHECK (action IN ('create', 'update', 'delete')) NOT VALID;
+
+-- Empty the trash, since without deleted_at its records would come back
+DELETE FROM notes WHERE deleted_at IS NOT NULL;
+DELETE FROM opportunities WHERE deleted_at IS NOT NULL;
+DELETE FROM contacts WHERE deleted_at IS NOT NULL;
+DELETE FROM accounts WHERE deleted_at IS NOT NULL;
+
+-- Remove soft deletion columns
+ALTER TABLE notes DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE notes DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE contacts DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE contacts DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE accounts DROP COLUMN IF EXISTS deleted_at;
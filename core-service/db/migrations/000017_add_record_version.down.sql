@@ -0,0 +1,12 @@
+-- Drop the version triggers
+DROP TRIGGER IF EXISTS notes_increment_version ON notes;
+DROP TRIGGER IF EXISTS opportunities_increment_version ON opportunities;
+DROP TRIGGER IF EXISTS contacts_increment_version ON contacts;
+DROP TRIGGER IF EXISTS accounts_increment_version ON accounts;
+DROP FUNCTION IF EXISTS increment_record_version();
+
+-- Drop the version columns
+ALTER TABLE notes DROP COLUMN IF EXISTS version;
+ALTER TABLE opportunities DROP COLUMN IF EXISTS version;
+ALTER TABLE contacts DROP COLUMN IF EXISTS version;
+ALTER TABLE accounts DROP COLUMN IF EXISTS version;
@@ -0,0 +1,30 @@
+-- Every change to a record increments its version, which the API returns as the record's ETag so that
+-- clients can make changes conditional on the version they read (If-Match)
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE opportunities ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+-- Increment the version on every update, including those made through foreign key actions
+CREATE OR REPLACE FUNCTION increment_record_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER accounts_increment_version
+    BEFORE UPDATE ON accounts
+    FOR EACH ROW EXECUTE FUNCTION increment_record_version();
+
+CREATE TRIGGER contacts_increment_version
+    BEFORE UPDATE ON contacts
+    FOR EACH ROW EXECUTE FUNCTION increment_record_version();
+
+CREATE TRIGGER opportunities_increment_version
+    BEFORE UPDATE ON opportunities
+    FOR EACH ROW EXECUTE FUNCTION increment_record_version();
+
+CREATE TRIGGER notes_increment_version
+    BEFORE UPDATE ON notes
+    FOR EACH ROW EXECUTE FUNCTION increment_record_version();
@@ -0,0 +1,9 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_accounts_lower_name;
+DROP INDEX IF EXISTS idx_import_errors_job_id;
+DROP INDEX IF EXISTS idx_import_jobs_unfinished;
+DROP INDEX IF EXISTS idx_import_jobs_created_by;
+
+-- Drop tables
+DROP TABLE IF EXISTS import_errors;
+DROP TABLE IF EXISTS import_jobs;
@@ -0,0 +1,38 @@
+-- Create import_jobs table: CSV files queued to be imported as accounts, contacts or opportunities in the background
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    record_type VARCHAR(20) NOT NULL CHECK (record_type IN ('account', 'contact', 'opportunity')),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    file_name VARCHAR(255) NOT NULL DEFAULT '',
+    content BYTEA NOT NULL, -- The uploaded file, kept for resuming the job and for the error report
+    mapping JSONB NOT NULL, -- CSV column -> record field
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    processed_rows INTEGER NOT NULL DEFAULT 0, -- Rows imported or rejected so far, in file order
+    imported_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    error TEXT, -- Why a failed job stopped
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    all_records BOOLEAN NOT NULL DEFAULT FALSE, -- Whether the creator could see every record, for linking by account name
+    request_id VARCHAR(128),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE, -- Last progress of a running job; jobs that stop making progress are resumed
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create import_errors table: why rows of an import were rejected
+CREATE TABLE IF NOT EXISTS import_errors (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL, -- Line of the file the row starts on; the header is line 1
+    column_name VARCHAR(255) NOT NULL DEFAULT '',
+    message TEXT NOT NULL
+);
+
+-- Create indexes for performance
+CREATE INDEX idx_import_jobs_created_by ON import_jobs(created_by, created_at);
+CREATE INDEX idx_import_jobs_unfinished ON import_jobs(created_at) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_import_errors_job_id ON import_errors(job_id, line);
+
+-- Imported contacts and opportunities are linked to accounts by name
+CREATE INDEX idx_accounts_lower_name ON accounts(LOWER(TRIM(name)));
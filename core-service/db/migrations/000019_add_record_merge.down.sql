@@ -0,0 +1,4 @@
+-- Restore the previous audit actions; existing merge events are kept
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_action_check;
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_action_check
+    CHECK (action IN ('create', 'update', 'delete', 'restore', 'purge')) NOT VALID;
@@ -0,0 +1,4 @@
+-- Record merges of duplicate records in the audit log
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_action_check;
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_action_check
+    CHECK (action IN ('create', 'update', 'delete', 'restore', 'purge', 'merge'));
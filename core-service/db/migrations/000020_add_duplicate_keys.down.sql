@@ -0,0 +1,6 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_accounts_phone_key;
+DROP INDEX IF EXISTS idx_accounts_name_key;
+DROP INDEX IF EXISTS idx_contacts_phone_key;
+DROP INDEX IF EXISTS idx_contacts_email_domain_key;
+DROP INDEX IF EXISTS idx_contacts_email_key;
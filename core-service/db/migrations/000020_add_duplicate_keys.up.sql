@@ -0,0 +1,7 @@
+-- Index the normalized values new contacts and accounts are checked against for duplicates.
+-- The expressions must stay the same as the keys in pkg/db/duplicate_check.go for the indexes to be used.
+CREATE INDEX idx_contacts_email_key ON contacts((regexp_replace(LOWER(TRIM(email)), '\+[^@]*@', '@')));
+CREATE INDEX idx_contacts_email_domain_key ON contacts((SUBSTRING(LOWER(TRIM(email)) FROM '@(.*)$')));
+CREATE INDEX idx_contacts_phone_key ON contacts((CASE WHEN LENGTH(regexp_replace(phone, '\D', '', 'g')) >= 7 THEN RIGHT(regexp_replace(phone, '\D', '', 'g'), 10) END));
+CREATE INDEX idx_accounts_name_key ON accounts((split_part(TRIM(regexp_replace(regexp_replace(LOWER(name), '^\s*the\s+', ''), '[^a-z0-9]+', ' ', 'g')), ' ', 1)));
+CREATE INDEX idx_accounts_phone_key ON accounts((CASE WHEN LENGTH(regexp_replace(phone, '\D', '', 'g')) >= 7 THEN RIGHT(regexp_replace(phone, '\D', '', 'g'), 10) END));
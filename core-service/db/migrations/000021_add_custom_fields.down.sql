@@ -0,0 +1,12 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_opportunities_custom_fields;
+DROP INDEX IF EXISTS idx_contacts_custom_fields;
+DROP INDEX IF EXISTS idx_accounts_custom_fields;
+
+-- Drop columns
+ALTER TABLE opportunities DROP COLUMN IF EXISTS custom_fields;
+ALTER TABLE contacts DROP COLUMN IF EXISTS custom_fields;
+ALTER TABLE accounts DROP COLUMN IF EXISTS custom_fields;
+
+-- Drop tables
+DROP TABLE IF EXISTS custom_field_definitions;
@@ -0,0 +1,26 @@
+-- Create custom_field_definitions table: admin-defined fields of accounts, contacts and opportunities
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    entity_type VARCHAR(20) NOT NULL CHECK (entity_type IN ('account', 'contact', 'opportunity')),
+    name VARCHAR(63) NOT NULL CHECK (name ~ '^[a-z][a-z0-9_]*$'), -- Key of the value in the records' custom_fields
+    label VARCHAR(255) NOT NULL,
+    field_type VARCHAR(20) NOT NULL CHECK (field_type IN ('text', 'number', 'boolean', 'date', 'enum')),
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    options JSONB, -- Values an enum field may take
+    default_value JSONB, -- Value given to new records that leave the field out
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (entity_type, name)
+);
+
+-- Store the values of custom fields with each record, keyed by field name
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE opportunities ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+
+-- List filters on custom fields use containment (@>); deleting a field finds the records with it using ?
+CREATE INDEX idx_accounts_custom_fields ON accounts USING GIN (custom_fields);
+CREATE INDEX idx_contacts_custom_fields ON contacts USING GIN (custom_fields);
+CREATE INDEX idx_opportunities_custom_fields ON opportunities USING GIN (custom_fields);
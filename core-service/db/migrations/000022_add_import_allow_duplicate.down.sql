@@ -0,0 +1,2 @@
+-- Drop columns
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS allow_duplicate;
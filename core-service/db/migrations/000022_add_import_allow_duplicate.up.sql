@@ -0,0 +1,2 @@
+-- Imports reject rows matching existing accounts or contacts under the duplicate rules unless the job allows duplicates
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS allow_duplicate BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs in the Authorization header
+const APIKeyPrefix = "crm_"
+
+// API key scopes. A key without scopes has all of its owner's access.
+const (
+	ScopeRead  = "read"  // GET and HEAD requests
+	ScopeWrite = "write" // Requests that create, change or delete data
+)
+
+// NewAPIKey generates a new API key. The key is shown to its owner once; only the
+// visible prefix and the hash are stored.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", fmt.Errorf("error generating API key: %w", err)
+	}
+
+	secret, _, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + secret
+	return key, prefix, HashToken(key), nil
+}
+
+// IsAPIKey reports whether a bearer credential is an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// ScopesAllow reports whether a key with the given scopes may make a request with the given HTTP method
+func ScopesAllow(scopes []string, method string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+
+	required := ScopeWrite
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		required = ScopeRead
+	}
+
+	for _, scope := range scopes {
+		// Write access includes read access
+		if scope == required || scope == ScopeWrite {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// devSecret is only used when no signing key is configured outside release mode
+const devSecret = "your-secret-key-here"
+
+// defaultKeyID identifies the key configured through JWT_SECRET or generated for development
+const defaultKeyID = "default"
+
+// Config holds the token signing and validation settings
+type Config struct {
+	Keys            map[string]*SigningKey // All keys accepted for verification, by key ID
+	SigningKeyID    string                 // Key ID used to sign new tokens
+	TokenTTL        time.Duration          // Lifetime of issued access tokens
+	RefreshTokenTTL time.Duration          // Lifetime of refresh tokens; each rotation extends the session by this much
+	Issuer          string                 // Value of the iss claim; checked when set
+	Audience        []string               // Values of the aud claim; at least one must match when set
+}
+
+// LoadConfig reads the token configuration from environment variables:
+//
+//	JWT_PRIVATE_KEY_FILES  comma-separated kid=path pairs of PEM RSA or Ed25519 private keys (RS256/EdDSA)
+//	JWT_PUBLIC_KEY_FILES   comma-separated kid=path pairs of PEM public keys kept only to verify older tokens
+//	JWT_SIGNING_KEYS       comma-separated kid=secret pairs of HMAC keys (HS256)
+//	JWT_SECRET             single HMAC secret, used when JWT_SIGNING_KEYS is not set
+//	JWT_SIGNING_KEY_ID     kid used to sign new tokens (defaults to the first private key, then the first HMAC key)
+//	JWT_SIGNING_ALG        algorithm of the ephemeral key generated when nothing is configured in development
+//	JWT_TOKEN_TTL          access token lifetime as a Go duration (default 24h)
+//	JWT_REFRESH_TOKEN_TTL  refresh token lifetime as a Go duration (default 720h)
+//	JWT_ISSUER             issuer to set and require on tokens
+//	JWT_AUDIENCE           comma-separated audiences to set and require on tokens
+//
+// Every configured key is accepted for verification. To rotate, add the new key, point
+// JWT_SIGNING_KEY_ID at it, and remove the old key once all tokens signed with it have expired.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{Keys: map[string]*SigningKey{}}
+
+	var order []string
+	addKey := func(key *SigningKey) error {
+		if _, exists := cfg.Keys[key.ID]; exists {
+			return fmt.Errorf("duplicate JWT key ID %q", key.ID)
+		}
+		cfg.Keys[key.ID] = key
+		if key.Private != nil {
+			order = append(order, key.ID)
+		}
+		return nil
+	}
+
+	privateFiles, err := parseKeyPairs("JWT_PRIVATE_KEY_FILES")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range privateFiles {
+		key, err := loadPrivateKeyFile(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := addKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	publicFiles, err := parseKeyPairs("JWT_PUBLIC_KEY_FILES")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range publicFiles {
+		key, err := loadPublicKeyFile(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := addKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	secrets, err := parseKeyPairs("JWT_SIGNING_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		if secret := os.Getenv("JWT_SECRET"); secret != "" {
+			secrets = append(secrets, [2]string{defaultKeyID, secret})
+		}
+	}
+	for _, pair := range secrets {
+		if err := addKey(newHMACKey(pair[0], []byte(pair[1]))); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(order) == 0 {
+		if os.Getenv("GIN_MODE") == "release" {
+			return nil, fmt.Errorf("a JWT signing key must be configured in release mode")
+		}
+
+		alg := getEnv("JWT_SIGNING_ALG", "HS256")
+		if alg == "HS256" {
+			log.Println("WARNING: No JWT signing key configured, using the insecure development secret")
+			if err := addKey(newHMACKey(defaultKeyID, []byte(devSecret))); err != nil {
+				return nil, err
+			}
+		} else {
+			log.Printf("WARNING: No JWT signing key configured, generating an ephemeral %s key", alg)
+			key, err := generateKey(defaultKeyID, alg)
+			if err != nil {
+				return nil, err
+			}
+			if err := addKey(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cfg.SigningKeyID = getEnv("JWT_SIGNING_KEY_ID", order[0])
+	if key, ok := cfg.Keys[cfg.SigningKeyID]; !ok || key.Private == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_ID %q is not a configured signing key", cfg.SigningKeyID)
+	}
+
+	ttl, err := time.ParseDuration(getEnv("JWT_TOKEN_TTL", "24h"))
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid JWT_TOKEN_TTL: must be a positive duration such as 1h")
+	}
+	cfg.TokenTTL = ttl
+
+	refreshTTL, err := time.ParseDuration(getEnv("JWT_REFRESH_TOKEN_TTL", "720h"))
+	if err != nil || refreshTTL <= 0 {
+		return nil, fmt.Errorf("invalid JWT_REFRESH_TOKEN_TTL: must be a positive duration such as 720h")
+	}
+	cfg.RefreshTokenTTL = refreshTTL
+
+	cfg.Issuer = os.Getenv("JWT_ISSUER")
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		for _, aud := range strings.Split(audience, ",") {
+			if aud = strings.TrimSpace(aud); aud != "" {
+				cfg.Audience = append(cfg.Audience, aud)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// parseKeyPairs splits an environment variable of comma-separated kid=value pairs
+func parseKeyPairs(name string) ([][2]string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	var pairs [][2]string
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid %s entry, expected kid=value", name)
+		}
+		pairs = append(pairs, [2]string{parts[0], parts[1]})
+	}
+	return pairs, nil
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
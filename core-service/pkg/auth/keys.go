@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key that can verify tokens, and sign them when its private part is available
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{} // Key used to sign; nil for keys that are only kept to verify older tokens
+	Public  interface{} // Key used to verify
+}
+
+// newHMACKey creates a shared-secret key
+func newHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// newAsymmetricKey wraps an RSA or Ed25519 private key, picking the matching signing method
+func newAsymmetricKey(id string, private crypto.PrivateKey) (*SigningKey, error) {
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, Private: key, Public: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("key %q: unsupported private key type %T", id, private)
+	}
+}
+
+// newPublicKey wraps an RSA or Ed25519 public key that is only used for verification
+func newPublicKey(id string, public crypto.PublicKey) (*SigningKey, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, Public: key}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, Public: key}, nil
+	default:
+		return nil, fmt.Errorf("key %q: unsupported public key type %T", id, public)
+	}
+}
+
+// loadPrivateKeyFile reads a PEM-encoded PKCS#8 or PKCS#1 private key
+func loadPrivateKeyFile(id, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("key %q: error parsing private key %s: %w", id, path, err)
+		}
+	}
+
+	return newAsymmetricKey(id, private)
+}
+
+// loadPublicKeyFile reads a PEM-encoded PKIX or PKCS#1 public key
+func loadPublicKeyFile(id, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if public, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("key %q: error parsing public key %s: %w", id, path, err)
+		}
+	}
+
+	return newPublicKey(id, public)
+}
+
+// generateKey creates an ephemeral key for the given algorithm, for local development only
+func generateKey(id, alg string) (*SigningKey, error) {
+	switch alg {
+	case "RS256":
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("error generating RSA key: %w", err)
+		}
+		return newAsymmetricKey(id, private)
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating Ed25519 key: %w", err)
+		}
+		return newAsymmetricKey(id, private)
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q (use HS256, RS256 or EdDSA)", alg)
+	}
+}
+
+// readPEM reads the first PEM block from a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key file %s is not PEM encoded", path)
+	}
+	return block, nil
+}
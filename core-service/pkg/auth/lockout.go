@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// LockoutPolicy controls how failed logins are throttled per email address and per client IP
+type LockoutPolicy struct {
+	MaxFailures     int           // Failures for one email address before it is locked
+	IPMaxFailures   int           // Failures from one IP address, across all emails, before it is locked
+	LockoutDuration time.Duration // How long a lock lasts
+	FailureWindow   time.Duration // Failures older than this no longer count
+	FreeAttempts    int           // Failures allowed before progressive delays start
+	MaxDelay        time.Duration // Upper bound of the progressive delay
+}
+
+// LoadLockoutPolicy reads the login throttling policy from environment variables:
+//
+//	LOGIN_MAX_FAILURES      failures per email before lockout (default 5)
+//	LOGIN_IP_MAX_FAILURES   failures per client IP before lockout (default 50)
+//	LOGIN_LOCKOUT_DURATION  lockout length as a Go duration (default 15m)
+//	LOGIN_FAILURE_WINDOW    time after which failures are forgotten (default 15m)
+func LoadLockoutPolicy() (*LockoutPolicy, error) {
+	policy := &LockoutPolicy{
+		FreeAttempts: 2,
+		MaxDelay:     30 * time.Second,
+	}
+
+	var err error
+	if policy.MaxFailures, err = positiveInt("LOGIN_MAX_FAILURES", "5"); err != nil {
+		return nil, err
+	}
+	if policy.IPMaxFailures, err = positiveInt("LOGIN_IP_MAX_FAILURES", "50"); err != nil {
+		return nil, err
+	}
+	if policy.LockoutDuration, err = positiveDuration("LOGIN_LOCKOUT_DURATION", "15m"); err != nil {
+		return nil, err
+	}
+	if policy.FailureWindow, err = positiveDuration("LOGIN_FAILURE_WINDOW", "15m"); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Delay returns how long a client has to wait after its last failure before trying again.
+// The first FreeAttempts failures carry no delay; after that it doubles from one second up to MaxDelay.
+func (p *LockoutPolicy) Delay(failures int) time.Duration {
+	extra := failures - p.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+	if extra > 16 {
+		return p.MaxDelay
+	}
+	delay := time.Second << (extra - 1)
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// RetryAfter returns how long the holder of the throttle state must wait before its next attempt, or zero.
+// A lock set by an earlier lockout takes precedence over the progressive delay.
+func (p *LockoutPolicy) RetryAfter(failures int, lastFailureAt time.Time, lockedUntil *time.Time, now time.Time) time.Duration {
+	if lockedUntil != nil && lockedUntil.After(now) {
+		return lockedUntil.Sub(now)
+	}
+	if now.Sub(lastFailureAt) > p.FailureWindow {
+		return 0
+	}
+	if wait := lastFailureAt.Add(p.Delay(failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// positiveInt reads a positive integer environment variable
+func positiveInt(name, defaultValue string) (int, error) {
+	value, err := strconv.Atoi(getEnv(name, defaultValue))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be a positive integer", name)
+	}
+	return value, nil
+}
+
+// positiveDuration reads a positive Go duration environment variable
+func positiveDuration(name, defaultValue string) (time.Duration, error) {
+	value, err := time.ParseDuration(getEnv(name, defaultValue))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be a positive duration such as 15m", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// recoveryCodeCount is the number of recovery codes issued when MFA is activated or the codes are regenerated
+const recoveryCodeCount = 10
+
+// MFAConfig holds the multi-factor authentication policy and the key protecting stored TOTP secrets
+type MFAConfig struct {
+	Issuer        string          // Name shown in authenticator apps
+	RequiredRoles map[string]bool // Roles that must use MFA
+	aead          cipher.AEAD
+}
+
+// LoadMFAConfig reads the MFA configuration from environment variables:
+//
+//	MFA_ISSUER          name shown in authenticator apps (default "CRM")
+//	MFA_REQUIRED_ROLES  comma-separated roles that must enroll in MFA (default "admin"; set to "none" to disable)
+//	MFA_ENCRYPTION_KEY  base64-encoded 32-byte key encrypting TOTP secrets at rest; required in release mode
+func LoadMFAConfig() (*MFAConfig, error) {
+	cfg := &MFAConfig{
+		Issuer:        getEnv("MFA_ISSUER", "CRM"),
+		RequiredRoles: map[string]bool{},
+	}
+
+	for _, role := range strings.Split(getEnv("MFA_REQUIRED_ROLES", "admin"), ",") {
+		if role = strings.TrimSpace(role); role != "" && role != "none" {
+			cfg.RequiredRoles[role] = true
+		}
+	}
+
+	var key []byte
+	if encoded := os.Getenv("MFA_ENCRYPTION_KEY"); encoded != "" {
+		var err error
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid MFA_ENCRYPTION_KEY: must be 32 bytes, base64 encoded")
+		}
+	} else {
+		if os.Getenv("GIN_MODE") == "release" {
+			return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be configured in release mode")
+		}
+		log.Println("WARNING: No MFA_ENCRYPTION_KEY configured, using the insecure development key")
+		sum := sha256.Sum256([]byte(devSecret))
+		key = sum[:]
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating MFA cipher: %w", err)
+	}
+	cfg.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating MFA cipher: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// RoleRequiresMFA reports whether users with the role must use MFA
+func (c *MFAConfig) RoleRequiresMFA(role string) bool {
+	return c.RequiredRoles[role]
+}
+
+// SealSecret encrypts a TOTP secret for storage
+func (c *MFAConfig) SealSecret(secret string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret decrypts a TOTP secret sealed with SealSecret
+func (c *MFAConfig) OpenSecret(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return "", fmt.Errorf("malformed MFA secret")
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	secret, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting MFA secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// GenerateRecoveryCodes returns a new set of one-time recovery codes and their hashes for storage
+func GenerateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %w", err)
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf)) // 8 characters
+		code := raw[:4] + "-" + raw[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes as users type them
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashToken(normalized)
+}
@@ -0,0 +1,482 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDCRoleMapping maps a value of the role claim to a CRM role
+type OIDCRoleMapping struct {
+	Value string
+	Role  string
+}
+
+// OIDCConfig holds the settings for single sign-on through an OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string            // Provider issuer; its discovery document is read from /.well-known/openid-configuration
+	ClientID     string            // Client registered with the provider
+	ClientSecret string            // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string            // Page the provider sends the browser back to with the authorization code
+	Scopes       []string          // Scopes requested from the provider
+	RoleClaim    string            // ID token claim holding groups or roles; dots select nested claims
+	RoleMapping  []OIDCRoleMapping // Claim values mapped to CRM roles; the first match wins
+	DefaultRole  string            // Role of users without a mapped claim value; empty refuses them
+}
+
+// LoadOIDCConfig reads the single sign-on configuration from environment variables:
+//
+//	OIDC_ISSUER_URL     provider issuer URL; single sign-on is disabled when empty
+//	OIDC_CLIENT_ID      client ID registered with the provider
+//	OIDC_CLIENT_SECRET  client secret; leave empty for a public client
+//	OIDC_REDIRECT_URL   frontend callback page registered as redirect URI with the provider
+//	OIDC_SCOPES         space-separated scopes (default "openid email profile")
+//	OIDC_ROLE_CLAIM     claim holding the user's groups or roles (default "groups"), e.g. "realm_access.roles"
+//	OIDC_ROLE_MAPPING   comma-separated value=role pairs, e.g. "crm-admins=admin,crm-users=user"
+//	OIDC_DEFAULT_ROLE   role of users matching no mapping (default "user"); set to "none" to refuse them
+func LoadOIDCConfig() (*OIDCConfig, error) {
+	cfg := &OIDCConfig{
+		IssuerURL:    strings.TrimRight(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+		RoleClaim:    getEnv("OIDC_ROLE_CLAIM", "groups"),
+		DefaultRole:  getEnv("OIDC_DEFAULT_ROLE", "user"),
+	}
+	if cfg.DefaultRole == "none" {
+		cfg.DefaultRole = ""
+	}
+
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be configured when OIDC_ISSUER_URL is set")
+	}
+
+	hasOpenID := false
+	for _, scope := range cfg.Scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+
+	pairs, err := parseKeyPairs("OIDC_ROLE_MAPPING")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		cfg.RoleMapping = append(cfg.RoleMapping, OIDCRoleMapping{Value: pair[0], Role: pair[1]})
+	}
+
+	return cfg, nil
+}
+
+// Enabled reports whether single sign-on is configured
+func (c *OIDCConfig) Enabled() bool {
+	return c.IssuerURL != ""
+}
+
+// MapRole returns the CRM role for the values of the role claim, falling back to the default role.
+// It returns false when the user may not sign in.
+func (c *OIDCConfig) MapRole(values []string) (string, bool) {
+	for _, mapping := range c.RoleMapping {
+		for _, value := range values {
+			if value == mapping.Value {
+				return mapping.Role, true
+			}
+		}
+	}
+	return c.DefaultRole, c.DefaultRole != ""
+}
+
+// OIDCIdentity is the user described by a verified ID token
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string   // preferred_username claim
+	RoleValues    []string // Values of the configured role claim
+	MFA           bool     // The provider reports a multi-factor login in the amr claim
+}
+
+// oidcDiscovery is the part of the provider's discovery document the login flow uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcKeyRefreshInterval limits how often unknown key IDs make the provider's keys be fetched again
+const oidcKeyRefreshInterval = time.Minute
+
+// oidcSigningMethods are the ID token algorithms accepted from providers
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDCProvider runs the authorization code flow against the configured provider.
+// The discovery document and signing keys are fetched on first use and cached.
+type OIDCProvider struct {
+	cfg    *OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]JWK
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider creates a new OIDC provider client
+func NewOIDCProvider(cfg *OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Config returns the provider's configuration
+func (p *OIDCProvider) Config() *OIDCConfig {
+	return p.cfg
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 code challenge (RFC 7636)
+func NewPKCE() (verifier string, challenge string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("error generating code verifier: %w", err)
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL builds the provider URL the browser is sent to for signing in
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and verifies the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("error decoding token response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	if tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("token response contains no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's keys, its issuer, audience, expiry and nonce,
+// and returns the identity it describes
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(oidcSigningMethods))
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.verificationKey(ctx, token)
+	}); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("ID token has no expiry or has expired")
+	}
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, fmt.Errorf("ID token has an unexpected issuer")
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("ID token was not issued for this client")
+	}
+	if audiences, ok := claims["aud"].([]interface{}); ok && len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.cfg.ClientID {
+			return nil, fmt.Errorf("ID token was not issued for this client")
+		}
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match the login request")
+	}
+
+	identity := &OIDCIdentity{Issuer: discovery.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	identity.RoleValues = claimStrings(lookupClaim(claims, p.cfg.RoleClaim))
+	for _, method := range claimStrings(claims["amr"]) {
+		identity.MFA = identity.MFA || method == "mfa"
+	}
+
+	return identity, nil
+}
+
+// lookupClaim returns the claim at a dotted path such as realm_access.roles
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// claimStrings converts a string or array claim into a list of strings
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// verificationKey selects the provider key named by the token's kid header, fetching the provider's keys again
+// when the key is unknown, since providers rotate their keys. The key type must match the token's algorithm.
+func (p *OIDCProvider) verificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	jwk, err := p.findKey(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if jwk.Algorithm != "" && jwk.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	alg := token.Method.Alg()
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS") {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if strings.HasPrefix(alg, "ES") {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+}
+
+// findKey returns the provider key with the given ID; without an ID the provider must publish a single signing key
+func (p *OIDCProvider) findKey(ctx context.Context, kid string) (JWK, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lookup := func() (JWK, bool) {
+		if kid != "" {
+			key, ok := p.keys[kid]
+			return key, ok
+		}
+		var found []JWK
+		for _, key := range p.keys {
+			if key.Use == "" || key.Use == "sig" {
+				found = append(found, key)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], true
+		}
+		return JWK{}, false
+	}
+
+	if key, ok := lookup(); ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < oidcKeyRefreshInterval {
+		return JWK{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return JWK{}, err
+	}
+
+	if key, ok := lookup(); ok {
+		return key, nil
+	}
+	return JWK{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetchKeys reloads the provider's signing keys; the caller holds p.mu
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	discovery, err := p.discoverLocked(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set JWKSet
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return fmt.Errorf("error fetching OIDC signing keys: %w", err)
+	}
+
+	p.keys = map[string]JWK{}
+	for _, key := range set.Keys {
+		p.keys[key.KeyID] = key
+	}
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+// getDiscovery returns the provider's discovery document, fetching it on first use
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discoverLocked(ctx)
+}
+
+// discoverLocked implements getDiscovery; the caller holds p.mu
+func (p *OIDCProvider) discoverLocked(ctx context.Context) (*oidcDiscovery, error) {
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.cfg.IssuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("error fetching OIDC discovery document: %w", err)
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, expected %q", discovery.Issuer, p.cfg.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// getJSON fetches and decodes a JSON document from the provider
+func (p *OIDCProvider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import "strings"
+
+// Permissions granted by roles, in the form <resource>:<action>
+const (
+	PermAccountsRead       = "accounts:read"
+	PermAccountsWrite      = "accounts:write"
+	PermContactsRead       = "contacts:read"
+	PermContactsWrite      = "contacts:write"
+	PermOpportunitiesRead  = "opportunities:read"
+	PermOpportunitiesWrite = "opportunities:write"
+	PermNotesRead          = "notes:read"
+	PermNotesWrite         = "notes:write"
+	PermUsersRead          = "users:read"
+	PermUsersAdmin         = "users:admin"   // Managing users, roles, teams, custom fields, the login audit trail and the audit log
+	PermRecordsAdmin       = "records:admin" // Seeing and changing every record regardless of owner and visibility
+)
+
+// Permissions is the catalog of every permission a role can grant
+var Permissions = []string{
+	PermAccountsRead,
+	PermAccountsWrite,
+	PermContactsRead,
+	PermContactsWrite,
+	PermOpportunitiesRead,
+	PermOpportunitiesWrite,
+	PermNotesRead,
+	PermNotesWrite,
+	PermUsersRead,
+	PermUsersAdmin,
+	PermRecordsAdmin,
+}
+
+// ValidPermission reports whether the permission is in the catalog
+func ValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the granted permissions include the required one.
+// Any permission on a resource includes read access to it, so accounts:write allows accounts:read.
+func HasPermission(granted []string, required string) bool {
+	resource, action, _ := strings.Cut(required, ":")
+	for _, permission := range granted {
+		if permission == required {
+			return true
+		}
+		if action == "read" && strings.HasPrefix(permission, resource+":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// JWTClaims represents the claims in the JWT token
+type JWTClaims struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	// Permissions are the permissions granted by the role when the token was issued
+	Permissions []string `json:"permissions,omitempty"`
+	// SessionID links the token to a server-side session so it can be revoked before it expires
+	SessionID string `json:"sid,omitempty"`
+	// MFA is set when the session completed a second factor
+	MFA bool `json:"mfa,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenService issues and validates access tokens
+type TokenService struct {
+	cfg *Config
+}
+
+// NewTokenService creates a new token service
+func NewTokenService(cfg *Config) *TokenService {
+	return &TokenService{cfg: cfg}
+}
+
+// IssueAccessToken creates a signed access token for the user within the given session
+func (s *TokenService) IssueAccessToken(user *models.User, session *models.Session) (string, time.Time, error) {
+	now := time.Now()
+	expirationTime := now.Add(s.cfg.TokenTTL)
+	claims := &JWTClaims{
+		UserID:      user.ID.String(),
+		Username:    user.Username,
+		Email:       user.Email,
+		Role:        user.Role,
+		Permissions: user.Permissions,
+		SessionID:   session.ID.String(),
+		MFA:         session.MFAVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.String(),
+			Issuer:    s.cfg.Issuer,
+			Audience:  s.cfg.Audience,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	key := s.cfg.Keys[s.cfg.SigningKeyID]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error signing token: %w", err)
+	}
+
+	return tokenString, expirationTime, nil
+}
+
+// RefreshTokenTTL returns the lifetime of refresh tokens and of the sessions they keep alive
+func (s *TokenService) RefreshTokenTTL() time.Duration {
+	return s.cfg.RefreshTokenTTL
+}
+
+// ParseAccessToken validates the token signature, expiry, issuer and audience and returns its claims
+func (s *TokenService) ParseAccessToken(tokenString string) (*JWTClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(s.validMethods()))
+	token, err := parser.ParseWithClaims(tokenString, &JWTClaims{}, s.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	if s.cfg.Issuer != "" && !claims.VerifyIssuer(s.cfg.Issuer, true) {
+		return nil, fmt.Errorf("token has an unexpected issuer")
+	}
+
+	if len(s.cfg.Audience) > 0 {
+		matched := false
+		for _, aud := range s.cfg.Audience {
+			if claims.VerifyAudience(aud, true) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("token has an unexpected audience")
+		}
+	}
+
+	return claims, nil
+}
+
+// keyFunc selects the verification key from the token's kid header.
+// Tokens issued before key IDs were introduced carry no kid and are checked against the signing key.
+// The token's algorithm must match the key's, so a public key can never be used as an HMAC secret.
+func (s *TokenService) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = s.cfg.SigningKeyID
+	}
+
+	key, ok := s.cfg.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// validMethods lists the algorithms of all configured keys
+func (s *TokenService) validMethods() []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, key := range s.cfg.Keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
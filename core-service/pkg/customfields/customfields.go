@@ -0,0 +1,188 @@
+package customfields
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// Types of custom fields
+const (
+	TypeText    = "text"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeDate    = "date" // Stored as YYYY-MM-DD
+	TypeEnum    = "enum"
+)
+
+// maxOptions is the number of values an enum field may have
+const maxOptions = 100
+
+// namePattern matches the names fields may have: lowercase letters, digits and underscores, starting with a letter,
+// so that they can be used in query parameters and SQL without quoting
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// ValidateDefinition checks that a field's name can be used as a key, that enum fields have options and other
+// fields do not, and that the default is a valid value of the field. It returns the default as it is stored.
+func ValidateDefinition(name, fieldType string, options []string, defaultValue interface{}) (interface{}, error) {
+	if !namePattern.MatchString(name) {
+		return nil, errors.New("name must start with a lowercase letter and contain only lowercase letters, digits and underscores")
+	}
+
+	if fieldType == TypeEnum {
+		if len(options) == 0 || len(options) > maxOptions {
+			return nil, fmt.Errorf("enum fields must have from 1 to %d options", maxOptions)
+		}
+		for i, option := range options {
+			if strings.TrimSpace(option) == "" {
+				return nil, errors.New("options cannot be empty")
+			}
+			if slices.Contains(options[:i], option) {
+				return nil, fmt.Errorf("option %q is listed more than once", option)
+			}
+		}
+	} else if len(options) > 0 {
+		return nil, errors.New("only enum fields have options")
+	}
+
+	if defaultValue == nil {
+		return nil, nil
+	}
+	field := models.CustomFieldDefinition{Name: name, Type: fieldType, Options: options}
+	value, err := normalize(field, defaultValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default: %w", err)
+	}
+	return value, nil
+}
+
+// Validate checks the custom field values of a record to create against the definitions of its type: every value
+// must belong to a field and suit its type, fields left out take their default, and required fields must have a
+// value. It returns the values as they are stored.
+func Validate(definitions []models.CustomFieldDefinition, values map[string]interface{}) (map[string]interface{}, error) {
+	if err := checkNames(definitions, values); err != nil {
+		return nil, err
+	}
+
+	valid := map[string]interface{}{}
+	for _, field := range definitions {
+		value := values[field.Name]
+		if value == nil {
+			value = field.Default
+		}
+		if value == nil {
+			if field.Required {
+				return nil, fmt.Errorf("custom field %q is required", field.Name)
+			}
+			continue
+		}
+
+		normalized, err := normalize(field, value)
+		if err != nil {
+			return nil, err
+		}
+		if field.Required && normalized == "" {
+			return nil, fmt.Errorf("custom field %q is required", field.Name)
+		}
+		valid[field.Name] = normalized
+	}
+
+	return valid, nil
+}
+
+// ValidateChanges checks changes to the custom field values of an existing record against the definitions of its
+// type. Fields left out are unchanged and fields set to null lose their value, unless they are required. It returns
+// the changes as they are stored, with null values kept.
+func ValidateChanges(definitions []models.CustomFieldDefinition, values map[string]interface{}) (map[string]interface{}, error) {
+	if err := checkNames(definitions, values); err != nil {
+		return nil, err
+	}
+
+	valid := map[string]interface{}{}
+	for _, field := range definitions {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		if value == nil {
+			if field.Required {
+				return nil, fmt.Errorf("custom field %q is required", field.Name)
+			}
+			valid[field.Name] = nil
+			continue
+		}
+
+		normalized, err := normalize(field, value)
+		if err != nil {
+			return nil, err
+		}
+		if field.Required && normalized == "" {
+			return nil, fmt.Errorf("custom field %q is required", field.Name)
+		}
+		valid[field.Name] = normalized
+	}
+
+	return valid, nil
+}
+
+// checkNames returns an error naming the values that do not belong to a defined field
+func checkNames(definitions []models.CustomFieldDefinition, values map[string]interface{}) error {
+	var unknown []string
+	for name := range values {
+		if !slices.ContainsFunc(definitions, func(field models.CustomFieldDefinition) bool { return field.Name == name }) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	if len(unknown) == 1 {
+		return fmt.Errorf("unknown custom field %q", unknown[0])
+	}
+	return fmt.Errorf("unknown custom fields %q", unknown)
+}
+
+// normalize converts a value decoded from JSON to the form values of the field's type are stored in
+func normalize(field models.CustomFieldDefinition, value interface{}) (interface{}, error) {
+	switch field.Type {
+	case TypeNumber:
+		switch number := value.(type) {
+		case float64:
+			return number, nil
+		case json.Number:
+			return number.Float64()
+		}
+		return nil, fmt.Errorf("custom field %q must be a number", field.Name)
+	case TypeBoolean:
+		if flag, ok := value.(bool); ok {
+			return flag, nil
+		}
+		return nil, fmt.Errorf("custom field %q must be true or false", field.Name)
+	case TypeDate:
+		if text, ok := value.(string); ok {
+			if date, err := time.Parse("2006-01-02", text); err == nil {
+				return date.Format("2006-01-02"), nil
+			}
+		}
+		return nil, fmt.Errorf("custom field %q must be a date (YYYY-MM-DD)", field.Name)
+	case TypeEnum:
+		if text, ok := value.(string); ok && slices.Contains(field.Options, text) {
+			return text, nil
+		}
+		return nil, fmt.Errorf("custom field %q must be one of %s", field.Name, strings.Join(field.Options, ", "))
+	default:
+		if text, ok := value.(string); ok {
+			return text, nil
+		}
+		return nil, fmt.Errorf("custom field %q must be a string", field.Name)
+	}
+}
@@ -3,79 +3,241 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
 )
 
 // AccountRepository handles database operations for accounts
 type AccountRepository struct {
-	db *DB
+	db             *DB
+	duplicateRules []string
 }
 
-// NewAccountRepository creates a new account repository
-func NewAccountRepository(db *DB) *AccountRepository {
-	return &AccountRepository{db: db}
+// NewAccountRepository creates a new account repository that checks new accounts against the duplicate rules
+func NewAccountRepository(db *DB, duplicates *DuplicateConfig) *AccountRepository {
+	return &AccountRepository{db: db, duplicateRules: duplicates.Rules}
 }
 
-// GetAllAccounts retrieves all accounts from the database
-func (r *AccountRepository) GetAllAccounts() ([]models.Account, error) {
-	query := `SELECT id, name, industry, website, phone, address, city, state, zip, country, created_at, updated_at, created_by FROM accounts ORDER BY name`
-	rows, err := r.db.Query(query)
+// accountColumns is the column list selected for every account query
+const accountColumns = `id, name, industry, website, phone, address, city, state, zip, country, created_at, updated_at, created_by, updated_by, owner_id, visibility, version, custom_fields`
+
+// accountListSpec defines the sortable and filterable account fields
+var accountListSpec = listSpec{
+	sortColumns: map[string]string{
+		"name":       "name",
+		"industry":   "COALESCE(industry, '')",
+		"city":       "COALESCE(city, '')",
+		"state":      "COALESCE(state, '')",
+		"country":    "COALESCE(country, '')",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "name",
+	filters: map[string]listFilter{
+		"q":              {condition: "search_vector @@ websearch_to_tsquery('english', %s)", kind: filterString},
+		"name":           {condition: "name ILIKE '%%' || %s || '%%'", kind: filterString},
+		"industry":       {condition: "industry = %s", kind: filterString},
+		"city":           {condition: "city = %s", kind: filterString},
+		"state":          {condition: "state = %s", kind: filterString},
+		"country":        {condition: "country = %s", kind: filterString},
+		"created_by":     {condition: "created_by = %s", kind: filterUUID},
+		"updated_by":     {condition: "updated_by = %s", kind: filterUUID},
+		"owner_id":       {condition: "owner_id = %s", kind: filterUUID},
+		"visibility":     {condition: "visibility = %s", kind: filterString},
+		"created_after":  {condition: "created_at >= %s", kind: filterTime},
+		"created_before": {condition: "created_at < %s", kind: filterTime},
+		"updated_after":  {condition: "updated_at >= %s", kind: filterTime},
+	},
+}
+
+// accountPatchSpec defines the account fields a merge patch may change
+var accountPatchSpec = patchSpec{
+	"name":          {column: "name", kind: patchString, required: true},
+	"industry":      {column: "industry", kind: patchString},
+	"website":       {column: "website", kind: patchString},
+	"phone":         {column: "phone", kind: patchString},
+	"address":       {column: "address", kind: patchString},
+	"city":          {column: "city", kind: patchString},
+	"state":         {column: "state", kind: patchString},
+	"zip":           {column: "zip", kind: patchString},
+	"country":       {column: "country", kind: patchString},
+	"visibility":    visibilityPatchField,
+	"custom_fields": customFieldsPatchField,
+}
+
+// accountBulk applies bulk account operations
+var accountBulk = bulkEntity[models.AccountCreate, models.Account]{
+	entity:  auditAccounts,
+	insert:  insertAccount,
+	id:      func(a *models.Account) uuid.UUID { return a.ID },
+	patch:   accountPatchSpec,
+	columns: accountColumns,
+	scan:    scanAccount,
+	duplicates: func(tx *sql.Tx, data models.AccountCreate, rules []string, access Access) (interface{}, error) {
+		matches, err := findAccountMatches(tx, data, rules, access)
+		if len(matches) == 0 {
+			return nil, err
+		}
+		return matches, nil
+	},
+}
+
+// accountMerge finds and merges duplicate accounts: accounts are compared when they share the first word of
+// their name, a website domain or the last 10 digits of a phone number
+var accountMerge = mergeEntity[models.Account]{
+	entity:  auditAccounts,
+	spec:    accountPatchSpec,
+	columns: accountColumns,
+	scan:    scanAccount,
+	id:      func(a *models.Account) uuid.UUID { return a.ID },
+	keys:    []string{accountNameKey, websiteDomainKey, phoneKey},
+	references: []trashLink{
+		{parent: auditAccounts, child: auditContacts, column: "account_id"},
+		{parent: auditAccounts, child: auditOpportunities, column: "account_id"},
+	},
+}
+
+// accountDuplicates checks new accounts against the duplicate rules. Accounts match under domain_name when their
+// names are the same without legal forms and, if both have a website, their website domains are the same.
+var accountDuplicates = duplicateCheck[models.AccountCreate, models.Account]{
+	entity:  auditAccounts,
+	columns: accountColumns,
+	scan:    scanAccount,
+	id:      func(a *models.Account) uuid.UUID { return a.ID },
+	rules: []matchRule[models.AccountCreate, models.Account]{
+		{
+			name: DuplicateRuleDomainName,
+			condition: func(a models.AccountCreate, args *[]interface{}) (string, string) {
+				word := firstNameWord(a.Name)
+				if word == "" {
+					return "", ""
+				}
+				condition := accountNameKey + " = " + bindMatch(args, word)
+				if domain := dedupe.WebsiteDomain(a.Website); domain != "" {
+					condition += fmt.Sprintf(" AND (COALESCE(website, '') = '' OR %s = %s)", websiteDomainKey, bindMatch(args, domain))
+				}
+				return condition, dedupe.NormalizeCompanyName(a.Name)
+			},
+			keep: func(a models.AccountCreate, account *models.Account) bool {
+				return dedupe.NormalizeCompanyName(a.Name) == dedupe.NormalizeCompanyName(account.Name)
+			},
+		},
+		phoneMatchRule[models.AccountCreate, models.Account](func(a models.AccountCreate) string { return a.Phone }),
+	},
+}
+
+// scanAccount scans a row selected with accountColumns into an account
+func scanAccount(row rowScanner) (*models.Account, error) {
+	var account models.Account
+	var industry, website, phone, address, city, state, zip, country sql.NullString
+	var customFields []byte
+	if err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&industry,
+		&website,
+		&phone,
+		&address,
+		&city,
+		&state,
+		&zip,
+		&country,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+		&account.CreatedBy,
+		&account.UpdatedBy, // NULL scans as uuid.Nil
+		&account.OwnerID,
+		&account.Visibility,
+		&account.Version,
+		&customFields,
+	); err != nil {
+		return nil, err
+	}
+
+	account.Industry = industry.String
+	account.Website = website.String
+	account.Phone = phone.String
+	account.Address = address.String
+	account.City = city.String
+	account.State = state.String
+	account.Zip = zip.String
+	account.Country = country.String
+
+	if err := decodeCustomFields(customFields, &account.CustomFields); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// GetAllAccounts retrieves a page of the accounts visible to the caller matching the list options, along with its paging metadata
+func (r *AccountRepository) GetAllAccounts(opts models.ListOptions, access Access) ([]models.Account, *models.PageInfo, error) {
+	spec, err := accountListSpec.withCustomFields(r.db, "account", opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := spec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.restrictTo(access)
+
+	limit, args := q.limit()
+	query := `SELECT ` + accountColumns + ` FROM accounts` + q.where + q.orderBy + limit
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying accounts: %w", err)
+		return nil, nil, fmt.Errorf("error querying accounts: %w", err)
 	}
 	defer rows.Close()
 
 	var accounts []models.Account
 	for rows.Next() {
-		var account models.Account
-		if err := rows.Scan(
-			&account.ID,
-			&account.Name,
-			&account.Industry,
-			&account.Website,
-			&account.Phone,
-			&account.Address,
-			&account.City,
-			&account.State,
-			&account.Zip,
-			&account.Country,
-			&account.CreatedAt,
-			&account.UpdatedAt,
-			&account.CreatedBy,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning account row: %w", err)
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning account row: %w", err)
 		}
-		accounts = append(accounts, account)
+		accounts = append(accounts, *account)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating account rows: %w", err)
+		return nil, nil, fmt.Errorf("error iterating account rows: %w", err)
 	}
 
-	return accounts, nil
+	return finishPage(r.db, q, "accounts", accounts, func(a models.Account) uuid.UUID { return a.ID })
 }
 
-// GetAccountByID retrieves a single account by ID
-func (r *AccountRepository) GetAccountByID(id uuid.UUID) (*models.Account, error) {
-	query := `SELECT id, name, industry, website, phone, address, city, state, zip, country, created_at, updated_at, created_by FROM accounts WHERE id = $1`
-	var account models.Account
-	err := r.db.QueryRow(query, id).Scan(
-		&account.ID,
-		&account.Name,
-		&account.Industry,
-		&account.Website,
-		&account.Phone,
-		&account.Address,
-		&account.City,
-		&account.State,
-		&account.Zip,
-		&account.Country,
-		&account.CreatedAt,
-		&account.UpdatedAt,
-		&account.CreatedBy,
-	)
+// ExportAccounts passes every account visible to the caller matching the list filters to each, in list order,
+// streaming them from the database
+func (r *AccountRepository) ExportAccounts(opts models.ListOptions, access Access, each func(*models.Account) error) error {
+	spec, err := accountListSpec.withCustomFields(r.db, "account", opts)
+	if err != nil {
+		return err
+	}
+
+	return streamList(r.db, spec, "accounts", accountColumns, scanAccount, opts, access, nil, each)
+}
+
+// GetDuplicateCandidates returns pairs of accounts visible to the caller that may be duplicates, to be scored
+func (r *AccountRepository) GetDuplicateCandidates(access Access) ([][2]models.Account, error) {
+	return accountMerge.duplicateCandidates(r.db, access)
+}
+
+// MergeAccounts merges duplicate accounts into the survivor, moving their contacts, opportunities and notes to it,
+// and returns the survivor. It returns nil when the survivor does not exist or is not visible to the caller.
+func (r *AccountRepository) MergeAccounts(request models.MergeRequest, ifMatch Versions, access Access) (*models.Account, error) {
+	return accountMerge.merge(r.db, request, ifMatch, access)
+}
+
+// GetAccountByID retrieves a single account by ID, with the contacts of it visible to the caller
+func (r *AccountRepository) GetAccountByID(id uuid.UUID, access Access) (*models.Account, error) {
+	args := []interface{}{id}
+	query := `SELECT ` + accountColumns + ` FROM accounts WHERE id = $1 AND ` + access.visibleCondition(&args)
+	account, err := scanAccount(r.db.QueryRow(query, args...))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No account found
@@ -84,9 +246,10 @@ func (r *AccountRepository) GetAccountByID(id uuid.UUID) (*models.Account, error
 	}
 
 	// Get associated contacts
-	contactsQuery := `SELECT id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at 
-                      FROM contacts WHERE account_id = $1 ORDER BY last_name, first_name`
-	contactRows, err := r.db.Query(contactsQuery, id)
+	contactArgs := []interface{}{id}
+	contactsQuery := `SELECT ` + contactColumns + ` FROM contacts WHERE account_id = $1 AND ` + access.visibleCondition(&contactArgs) +
+		` ORDER BY last_name, first_name, id`
+	contactRows, err := r.db.Query(contactsQuery, contactArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying contacts for account: %w", err)
 	}
@@ -94,40 +257,118 @@ func (r *AccountRepository) GetAccountByID(id uuid.UUID) (*models.Account, error
 
 	var contacts []models.Contact
 	for contactRows.Next() {
-		var contact models.Contact
-		if err := contactRows.Scan(
-			&contact.ID,
-			&contact.FirstName,
-			&contact.LastName,
-			&contact.Email,
-			&contact.Phone,
-			&contact.Title,
-			&contact.AccountID,
-			&contact.Address,
-			&contact.City,
-			&contact.State,
-			&contact.Zip,
-			&contact.Country,
-			&contact.CreatedAt,
-			&contact.UpdatedAt,
-		); err != nil {
+		contact, err := scanContact(contactRows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning contact row: %w", err)
 		}
-		contacts = append(contacts, contact)
+		contacts = append(contacts, *contact)
+	}
+
+	if err := contactRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating contact rows: %w", err)
 	}
 
 	account.Contacts = contacts
-	return &account, nil
+	return account, nil
 }
 
-// CreateAccount creates a new account in the database
-func (r *AccountRepository) CreateAccount(accountData models.AccountCreate) (*models.Account, error) {
-	query := `INSERT INTO accounts (name, industry, website, phone, address, city, state, zip, country, created_by) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) 
-              RETURNING id, name, industry, website, phone, address, city, state, zip, country, created_at, updated_at, created_by`
+// GetAccountIDsByName finds the accounts visible to the caller with any of the given names, ignoring case and
+// surrounding spaces. The result maps each name, lowercased and trimmed, to the IDs of the accounts with it.
+func (r *AccountRepository) GetAccountIDsByName(names []string, access Access) (map[string][]uuid.UUID, error) {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = strings.ToLower(strings.TrimSpace(name))
+	}
 
-	var account models.Account
-	err := r.db.QueryRow(
+	args := []interface{}{pq.Array(keys)}
+	query := `SELECT LOWER(TRIM(name)), id FROM accounts WHERE LOWER(TRIM(name)) = ANY($1) AND ` + access.visibleCondition(&args) +
+		` ORDER BY created_at, id`
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying accounts by name: %w", err)
+	}
+	defer rows.Close()
+
+	ids := map[string][]uuid.UUID{}
+	for rows.Next() {
+		var name string
+		var id uuid.UUID
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, fmt.Errorf("error scanning account row: %w", err)
+		}
+		ids[name] = append(ids[name], id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account rows: %w", err)
+	}
+
+	return ids, nil
+}
+
+// CreateAccount creates a new account created and owned by the acting user; it is public unless another visibility is given.
+// Unless allowDuplicate is set, it returns ErrDuplicateRecord with the accounts visible to the caller that the new
+// one matches under the duplicate rules, if there are any.
+func (r *AccountRepository) CreateAccount(accountData models.AccountCreate, allowDuplicate bool, access Access) (*models.Account, []models.AccountMatch, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	if !allowDuplicate {
+		matches, err := findAccountMatches(tx, accountData, r.duplicateRules, access)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(matches) > 0 {
+			return nil, matches, ErrDuplicateRecord
+		}
+	}
+
+	account, err := insertAccount(tx, accountData, access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return account, nil, nil
+}
+
+// findAccountMatches returns the accounts visible to the caller that an account to create matches under the
+// duplicate rules
+func findAccountMatches(tx *sql.Tx, accountData models.AccountCreate, rules []string, access Access) ([]models.AccountMatch, error) {
+	found, err := accountDuplicates.find(tx, accountData, rules, access)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.AccountMatch, len(found))
+	for i, match := range found {
+		matches[i] = models.AccountMatch{Rules: match.rules, Record: match.record}
+	}
+	return matches, nil
+}
+
+// insertAccount creates an account within a transaction and records it in the audit log
+func insertAccount(tx *sql.Tx, accountData models.AccountCreate, access Access) (*models.Account, error) {
+	query := `INSERT INTO accounts (name, industry, website, phone, address, city, state, zip, country, created_by, updated_by, owner_id, visibility, custom_fields) 
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10, $10, COALESCE(NULLIF($11, ''), 'public'), COALESCE($12::jsonb, '{}')) 
+              RETURNING ` + accountColumns
+
+	customFields, err := encodeCustomFields(accountData.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := scanAccount(tx.QueryRow(
 		query,
 		accountData.Name,
 		accountData.Industry,
@@ -138,32 +379,54 @@ func (r *AccountRepository) CreateAccount(accountData models.AccountCreate) (*mo
 		accountData.State,
 		accountData.Zip,
 		accountData.Country,
-		accountData.CreatedBy,
-	).Scan(
-		&account.ID,
-		&account.Name,
-		&account.Industry,
-		&account.Website,
-		&account.Phone,
-		&account.Address,
-		&account.City,
-		&account.State,
-		&account.Zip,
-		&account.Country,
-		&account.CreatedAt,
-		&account.UpdatedAt,
-		&account.CreatedBy,
-	)
+		access.UserID,
+		accountData.Visibility,
+		customFields,
+	))
 
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, fmt.Errorf("%w: a referenced record does not exist", ErrInvalidRecord)
+		}
 		return nil, fmt.Errorf("error creating account: %w", err)
 	}
 
-	return &account, nil
+	if err := recordAudit(tx, access.Actor, auditAccounts, account.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return account, nil
 }
 
-// UpdateAccount updates an existing account in the database
-func (r *AccountRepository) UpdateAccount(id uuid.UUID, accountData models.AccountUpdate) (*models.Account, error) {
+// UpdateAccount updates an existing account in the database; only its owner can change it
+func (r *AccountRepository) UpdateAccount(id uuid.UUID, accountData models.AccountUpdate, ifMatch Versions, access Access) (*models.Account, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, "accounts", id, ifMatch, access)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // No account found with this ID
+	}
+
+	before, err := auditAccounts.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	customFields, err := encodeCustomFields(accountData.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE accounts SET 
               name = COALESCE(NULLIF($1, ''), name),
               industry = COALESCE(NULLIF($2, ''), industry),
@@ -174,12 +437,14 @@ func (r *AccountRepository) UpdateAccount(id uuid.UUID, accountData models.Accou
               state = COALESCE(NULLIF($7, ''), state),
               zip = COALESCE(NULLIF($8, ''), zip),
               country = COALESCE(NULLIF($9, ''), country),
+              visibility = COALESCE(NULLIF($11, ''), visibility),
+              custom_fields = jsonb_strip_nulls(custom_fields || COALESCE($13::jsonb, '{}')),
+              updated_by = $12,
               updated_at = NOW()
               WHERE id = $10
-              RETURNING id, name, industry, website, phone, address, city, state, zip, country, created_at, updated_at, created_by`
+              RETURNING ` + accountColumns
 
-	var account models.Account
-	err := r.db.QueryRow(
+	account, err := scanAccount(tx.QueryRow(
 		query,
 		accountData.Name,
 		accountData.Industry,
@@ -191,21 +456,10 @@ func (r *AccountRepository) UpdateAccount(id uuid.UUID, accountData models.Accou
 		accountData.Zip,
 		accountData.Country,
 		id,
-	).Scan(
-		&account.ID,
-		&account.Name,
-		&account.Industry,
-		&account.Website,
-		&account.Phone,
-		&account.Address,
-		&account.City,
-		&account.State,
-		&account.Zip,
-		&account.Country,
-		&account.CreatedAt,
-		&account.UpdatedAt,
-		&account.CreatedBy,
-	)
+		accountData.Visibility,
+		access.UserID,
+		customFields,
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -214,36 +468,65 @@ func (r *AccountRepository) UpdateAccount(id uuid.UUID, accountData models.Accou
 		return nil, fmt.Errorf("error updating account: %w", err)
 	}
 
-	return &account, nil
+	if err := recordAudit(tx, access.Actor, auditAccounts, id, before); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return account, nil
+}
+
+// PatchAccount applies a merge patch to an account; only its owner can change it
+func (r *AccountRepository) PatchAccount(id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*models.Account, error) {
+	return patchRecord(r.db, auditAccounts, accountPatchSpec, accountColumns, scanAccount, id, patch, ifMatch, access)
 }
 
-// DeleteAccount deletes an account from the database
-func (r *AccountRepository) DeleteAccount(id uuid.UUID) error {
-	query := `DELETE FROM accounts WHERE id = $1`
-	result, err := r.db.Exec(query, id)
+// BulkAccounts creates, updates and deletes accounts in one request; see bulkEntity.run. Creates are checked against
+// the duplicate rules unless they allow duplicates.
+func (r *AccountRepository) BulkAccounts(ops []BulkOperation[models.AccountCreate], atomic bool, access Access) []BulkResult[models.Account] {
+	return accountBulk.withDuplicateRules(r.duplicateRules).run(r.db, ops, atomic, access)
+}
+
+// DeleteAccount moves an account and its opportunities to the trash; only its owner can delete it. Its contacts
+// stay, still linked to it until it is purged.
+func (r *AccountRepository) DeleteAccount(id uuid.UUID, ifMatch Versions, access Access) error {
+	found, err := moveToTrash(r.db, auditAccounts, id, ifMatch, access)
 	if err != nil {
-		return fmt.Errorf("error deleting account: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no account found with ID %s", id)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return nil
+}
+
+// RestoreAccount takes an account out of the trash along with the opportunities deleted with it; only its owner can restore it
+func (r *AccountRepository) RestoreAccount(id uuid.UUID, access Access) error {
+	found, err := restoreFromTrash(r.db, auditAccounts, id, access)
 	if err != nil {
-		return fmt.Errorf("error getting rows affected: %w", err)
+		return err
 	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("no account found with ID %s", id)
+	if !found {
+		return fmt.Errorf("%w: no account found in the trash with ID %s", ErrRecordNotFound, id)
 	}
 
 	return nil
 }
 
-// UserExists checks if a user with the given ID exists in the database
-func (r *AccountRepository) UserExists(id uuid.UUID) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`
-	var exists bool
-	err := r.db.QueryRow(query, id).Scan(&exists)
+// TransferAccount gives an account to another active user; only its owner can transfer it
+func (r *AccountRepository) TransferAccount(id, ownerID uuid.UUID, access Access) error {
+	found, err := transferOwnership(r.db, auditAccounts, id, ownerID, access)
 	if err != nil {
-		return false, fmt.Errorf("error checking if user exists: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no account found with ID %s", ErrRecordNotFound, id)
 	}
-	return exists, nil
+
+	return nil
 }
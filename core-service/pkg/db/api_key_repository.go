@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+// APIKeyRepository handles database operations for API keys
+type APIKeyRepository struct {
+	db *DB
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// apiKeyColumns is the column list selected for every API key query
+const apiKeyColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, created_at, revoked_at`
+
+// scanAPIKey scans a row selected with apiKeyColumns, followed by any extra destinations, into an API key
+func scanAPIKey(row rowScanner, extra ...interface{}) (*models.APIKey, error) {
+	var key models.APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	var lastUsedIP sql.NullString
+	dest := append([]interface{}{
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&expiresAt,
+		&lastUsedAt,
+		&lastUsedIP,
+		&key.CreatedAt,
+		&revokedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	// Handle nullable fields
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	key.LastUsedIP = lastUsedIP.String
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+
+	return &key, nil
+}
+
+// GetAPIKeysByUserID retrieves all keys of a user that have not been revoked, newest first
+func (r *APIKeyRepository) GetAPIKeysByUserID(userID uuid.UUID) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC, id`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning API key row: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API key rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// CreateAPIKey stores a new key for the user under its prefix and hash
+func (r *APIKeyRepository) CreateAPIKey(userID uuid.UUID, data models.APIKeyCreate, prefix, keyHash string) (*models.APIKey, error) {
+	scopes := data.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              RETURNING ` + apiKeyColumns
+
+	key, err := scanAPIKey(r.db.QueryRow(query, userID, data.Name, prefix, keyHash, pq.Array(scopes), data.ExpiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("error creating API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys; it returns ErrRecordNotFound when the user has no such unrevoked key
+func (r *APIKeyRepository) RevokeAPIKey(id, userID uuid.UUID) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, id, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: no API key found with ID %s", ErrRecordNotFound, id)
+	}
+
+	return nil
+}
+
+// Authenticate looks up an unrevoked, unexpired key by its hash together with its active owner.
+// It returns nil when no such key exists.
+func (r *APIKeyRepository) Authenticate(keyHash string) (*models.APIKey, *models.User, error) {
+	query := `SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.expires_at, k.last_used_at, k.last_used_ip, k.created_at, k.revoked_at,
+                     u.username, u.email, u.role,
+                     ARRAY(SELECT permission FROM role_permissions WHERE role_name = u.role ORDER BY permission)
+              FROM api_keys k
+              JOIN users u ON u.id = k.user_id
+              WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+                AND (k.expires_at IS NULL OR k.expires_at > NOW())
+                AND u.is_active`
+
+	var user models.User
+	key, err := scanAPIKey(r.db.QueryRow(query, keyHash), &user.Username, &user.Email, &user.Role, pq.Array(&user.Permissions))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, nil // No usable key
+		}
+		return nil, nil, fmt.Errorf("error querying API key: %w", err)
+	}
+
+	user.ID = key.UserID
+	user.IsActive = true
+	return key, &user, nil
+}
+
+// TouchAPIKey records that the key was used. To limit writes, the time is only updated once a minute.
+func (r *APIKeyRepository) TouchAPIKey(id uuid.UUID, ipAddress string) error {
+	query := `UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+              WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM $2)`
+	if _, err := r.db.Exec(query, id, ipAddress); err != nil {
+		return fmt.Errorf("error updating API key usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// Actor identifies who makes a change and the request it is made in, for the audit log
+type Actor struct {
+	UserID    uuid.UUID // uuid.Nil for changes made by the system
+	RequestID string
+}
+
+// auditEntity describes how to snapshot one type of audited entity
+type auditEntity struct {
+	entityType string
+	table      string
+	snapshot   string // Query returning the row with ID $1 as a JSON object, locking it
+}
+
+// Audited entities; a note's snapshot includes the records it is associated with
+var (
+	auditAccounts      = auditEntity{"account", "accounts", `SELECT to_jsonb(t) FROM accounts t WHERE id = $1 FOR UPDATE`}
+	auditContacts      = auditEntity{"contact", "contacts", `SELECT to_jsonb(t) FROM contacts t WHERE id = $1 FOR UPDATE`}
+	auditOpportunities = auditEntity{"opportunity", "opportunities", `SELECT to_jsonb(t) FROM opportunities t WHERE id = $1 FOR UPDATE`}
+	auditNotes         = auditEntity{"note", "notes", `SELECT to_jsonb(t) || jsonb_build_object('records', COALESCE((
+	                         SELECT jsonb_agg(jsonb_build_object('record_id', a.record_id, 'record_type', a.record_type)
+	                                          ORDER BY a.record_type, a.record_id)
+	                         FROM note_associations a WHERE a.note_id = t.id), '[]'::jsonb))
+	                     FROM notes t WHERE id = $1 FOR UPDATE`}
+	auditUsers = auditEntity{"user", "users", `SELECT to_jsonb(t) FROM users t WHERE id = $1 FOR UPDATE`}
+)
+
+// auditEntities maps an entity type to its description
+var auditEntities = map[string]auditEntity{
+	auditAccounts.entityType:      auditAccounts,
+	auditContacts.entityType:      auditContacts,
+	auditOpportunities.entityType: auditOpportunities,
+	auditNotes.entityType:         auditNotes,
+	auditUsers.entityType:         auditUsers,
+}
+
+// auditIgnoredFields are derived or bookkeeping columns left out of diffs
+var auditIgnoredFields = map[string]bool{
+	"search_vector": true,
+	"updated_at":    true,
+	"mfa_last_step": true,
+	"version":       true,
+}
+
+// auditRedactedFields are secrets whose changes are recorded without their values
+var auditRedactedFields = map[string]bool{
+	"password_hash": true,
+	"mfa_secret":    true,
+}
+
+// takeSnapshot returns the entity's row as a JSON object, or nil when it does not exist
+func (e auditEntity) takeSnapshot(tx *sql.Tx, id uuid.UUID) (map[string]interface{}, error) {
+	var raw []byte
+	if err := tx.QueryRow(e.snapshot, id).Scan(&raw); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No row found with this ID
+		}
+		return nil, fmt.Errorf("error reading %s for audit: %w", e.entityType, err)
+	}
+
+	// Keep numbers as written so amounts are compared and logged exactly
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("error decoding %s for audit: %w", e.entityType, err)
+	}
+
+	return row, nil
+}
+
+// takeSnapshots snapshots every entity whose ID the query returns, so that changes the database makes through
+// foreign key actions can be recorded alongside the change that causes them
+func (e auditEntity) takeSnapshots(tx *sql.Tx, idsQuery string, args ...interface{}) (map[uuid.UUID]map[string]interface{}, error) {
+	rows, err := tx.Query(idsQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying related %s for audit: %w", e.table, err)
+	}
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning related %s for audit: %w", e.table, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating related %s for audit: %w", e.table, err)
+	}
+
+	snapshots := make(map[uuid.UUID]map[string]interface{}, len(ids))
+	for _, id := range ids {
+		before, err := e.takeSnapshot(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil { // Skip rows removed since they were listed
+			snapshots[id] = before
+		}
+	}
+
+	return snapshots, nil
+}
+
+// recordAudits appends an audit event for each entity snapshotted with takeSnapshots that has since changed
+func recordAudits(tx *sql.Tx, actor Actor, entity auditEntity, snapshots map[uuid.UUID]map[string]interface{}) error {
+	for id, before := range snapshots {
+		if err := recordAudit(tx, actor, entity, id, before); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAudit appends an audit event for a change made within tx, comparing the snapshot taken before the change
+// (nil for creates) with the entity's row now (nil after deletes). Moving a record to the trash is recorded as
+// a delete and taking it out as a restore. Updates that change nothing are not recorded.
+func recordAudit(tx *sql.Tx, actor Actor, entity auditEntity, id uuid.UUID, before map[string]interface{}) error {
+	after, err := entity.takeSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+
+	action := "update"
+	switch {
+	case before == nil && after == nil:
+		return nil
+	case before == nil:
+		action = "create"
+	case after == nil:
+		action = "delete"
+	case before["deleted_at"] == nil && after["deleted_at"] != nil:
+		action = "delete" // Moved to the trash
+	case before["deleted_at"] != nil && after["deleted_at"] == nil:
+		action = "restore"
+	}
+
+	changes := auditDiff(before, after)
+	if action == "update" && len(changes) == 0 {
+		return nil
+	}
+
+	return insertAuditEvent(tx, actor, entity.entityType, id, action, changes)
+}
+
+// insertAuditEvent writes a single audit event
+func insertAuditEvent(tx *sql.Tx, actor Actor, entityType string, id uuid.UUID, action string, changes map[string]models.AuditChange) error {
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("error encoding audit changes: %w", err)
+	}
+
+	var actorID interface{}
+	if actor.UserID != uuid.Nil {
+		actorID = actor.UserID
+	}
+
+	query := `INSERT INTO audit_events (actor_id, request_id, entity_type, entity_id, action, changes)
+              VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)`
+	if _, err := tx.Exec(query, actorID, actor.RequestID, entityType, id, action, changesJSON); err != nil {
+		return fmt.Errorf("error recording audit event: %w", err)
+	}
+
+	return nil
+}
+
+// auditDiff returns the fields whose values differ between two snapshots
+func auditDiff(before, after map[string]interface{}) map[string]models.AuditChange {
+	changes := map[string]models.AuditChange{}
+	for _, row := range []map[string]interface{}{before, after} {
+		for field := range row {
+			if _, seen := changes[field]; seen || auditIgnoredFields[field] {
+				continue
+			}
+
+			oldValue, newValue := before[field], after[field]
+			if reflect.DeepEqual(oldValue, newValue) {
+				continue
+			}
+
+			if auditRedactedFields[field] {
+				oldValue, newValue = redact(oldValue), redact(newValue)
+			}
+			changes[field] = models.AuditChange{Old: oldValue, New: newValue}
+		}
+	}
+	return changes
+}
+
+// redact hides a secret value while still showing whether it was set
+func redact(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return "[redacted]"
+}
+
+// AuditRepository reads the audit log
+type AuditRepository struct {
+	db *DB
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(db *DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// auditColumns is the column list selected for every audit event query
+const auditColumns = `id, occurred_at, actor_id, COALESCE((SELECT username FROM users WHERE users.id = audit_events.actor_id), ''),
+                      COALESCE(request_id, ''), entity_type, entity_id, action, changes`
+
+// auditListSpec defines the sortable and filterable audit event fields
+var auditListSpec = listSpec{
+	sortColumns: map[string]string{
+		"occurred_at": "occurred_at",
+	},
+	defaultSort: "-occurred_at",
+	filters: map[string]listFilter{
+		"entity_type":     {condition: "entity_type = %s", kind: filterString},
+		"entity_id":       {condition: "entity_id = %s", kind: filterUUID},
+		"actor_id":        {condition: "actor_id = %s", kind: filterUUID},
+		"action":          {condition: "action = %s", kind: filterString},
+		"request_id":      {condition: "request_id = %s", kind: filterString},
+		"field":           {condition: "changes ? %s", kind: filterString},
+		"occurred_after":  {condition: "occurred_at >= %s", kind: filterTime},
+		"occurred_before": {condition: "occurred_at < %s", kind: filterTime},
+	},
+}
+
+// scanAuditEvent scans a row selected with auditColumns into an audit event
+func scanAuditEvent(row rowScanner) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	var actorID uuid.NullUUID
+	var changes []byte
+	if err := row.Scan(
+		&event.ID,
+		&event.OccurredAt,
+		&actorID,
+		&event.ActorUsername,
+		&event.RequestID,
+		&event.EntityType,
+		&event.EntityID,
+		&event.Action,
+		&changes,
+	); err != nil {
+		return nil, err
+	}
+
+	if actorID.Valid {
+		event.ActorID = &actorID.UUID
+	}
+
+	if err := json.Unmarshal(changes, &event.Changes); err != nil {
+		return nil, fmt.Errorf("error decoding audit changes: %w", err)
+	}
+
+	return &event, nil
+}
+
+// GetAuditEvents retrieves a page of audit events matching the list options, newest first by default
+func (r *AuditRepository) GetAuditEvents(opts models.ListOptions) ([]models.AuditEvent, *models.PageInfo, error) {
+	q, err := auditListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit, args := q.limit()
+	query := `SELECT ` + auditColumns + ` FROM audit_events` + q.where + q.orderBy + limit
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning audit event row: %w", err)
+		}
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating audit event rows: %w", err)
+	}
+
+	return finishPage(r.db, q, "audit_events", events, func(e models.AuditEvent) uuid.UUID { return e.ID })
+}
+
+// GetEntityHistory retrieves a page of the audit events of one record or user.
+// Records must exist, in or out of the trash, and be visible to the caller; otherwise the returned page is nil.
+func (r *AuditRepository) GetEntityHistory(entityType string, id uuid.UUID, opts models.ListOptions, access Access) ([]models.AuditEvent, *models.PageInfo, error) {
+	entity, ok := auditEntities[entityType]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown entity type %q", ErrInvalidListOptions, entityType)
+	}
+
+	if entity != auditUsers {
+		args := []interface{}{id}
+		var visible bool
+		query := fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`, access.sharedCondition(&args), entity.table)
+		if err := r.db.QueryRow(query, args...).Scan(&visible); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, nil, nil // No record found with this ID
+			}
+			return nil, nil, fmt.Errorf("error checking %s visibility: %w", entity.entityType, err)
+		}
+		if !visible {
+			return nil, nil, nil
+		}
+	}
+
+	filters := map[string]string{}
+	for name, value := range opts.Filters {
+		filters[name] = value
+	}
+	filters["entity_type"] = entity.entityType
+	filters["entity_id"] = id.String()
+	opts.Filters = filters
+
+	return r.GetAuditEvents(opts)
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+var (
+	// ErrInvalidRecord is returned when a record cannot be created as given, such as when it refers to a record
+	// that does not exist
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrRecordNotFound is returned when a record to change, such as in a bulk update or delete, a merge, a
+	// transfer or a restore, does not exist or is not visible to the caller
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrBulkAborted is returned for the operations of an all-or-nothing bulk request that were not applied
+	// because another operation failed
+	ErrBulkAborted = errors.New("not applied because another operation failed")
+)
+
+// bulkBatchSize is the number of operations of a partial bulk request applied in each transaction
+const bulkBatchSize = 100
+
+// BulkOperation is one create, update or delete of a bulk request
+type BulkOperation[C any] struct {
+	Index          int    // Position of the operation in the request
+	Op             string // "create", "update" or "delete"
+	ID             uuid.UUID
+	IfMatch        Versions
+	Create         C                 // Data of creates
+	AllowDuplicate bool              // Whether a create may match existing records under the duplicate rules
+	Patch          models.MergePatch // Changes made by updates
+}
+
+// BulkResult is the outcome of one bulk operation: the record it created or updated, or why it failed
+type BulkResult[T any] struct {
+	Index   int
+	ID      uuid.UUID
+	Record  *T
+	Err     error
+	Matches interface{} // The existing records a create that failed with ErrDuplicateRecord matches
+}
+
+// bulkEntity describes how to create and patch one type of record in bulk
+type bulkEntity[C, T any] struct {
+	entity  auditEntity
+	insert  func(*sql.Tx, C, Access) (*T, error)
+	id      func(*T) uuid.UUID
+	patch   patchSpec
+	columns string
+	scan    func(rowScanner) (*T, error)
+	// duplicates, if set, returns the existing records a create matches under the rules, or nil when it matches none
+	duplicates     func(tx *sql.Tx, data C, rules []string, access Access) (interface{}, error)
+	duplicateRules []string
+}
+
+// withDuplicateRules returns a copy of b that checks creates against the enabled duplicate rules, like the create
+// endpoints do
+func (b bulkEntity[C, T]) withDuplicateRules(rules []string) bulkEntity[C, T] {
+	b.duplicateRules = rules
+	return b
+}
+
+// run applies bulk operations with the same rules and audit events as the single-record endpoints. In atomic mode
+// they all run in one transaction that is rolled back when any of them fails. Otherwise they run in transactions of
+// bulkBatchSize operations, and an operation that fails is rolled back on its own while the others are kept.
+func (b bulkEntity[C, T]) run(db *DB, ops []BulkOperation[C], atomic bool, access Access) []BulkResult[T] {
+	results := make([]BulkResult[T], len(ops))
+	for i, op := range ops {
+		results[i] = BulkResult[T]{Index: op.Index, ID: op.ID}
+	}
+
+	if atomic {
+		b.runBatch(db, ops, results, true, access)
+		return results
+	}
+
+	for start := 0; start < len(ops); start += bulkBatchSize {
+		end := min(start+bulkBatchSize, len(ops))
+		b.runBatch(db, ops[start:end], results[start:end], false, access)
+	}
+
+	return results
+}
+
+// runBatch applies operations in one transaction, recording their outcomes in results
+func (b bulkEntity[C, T]) runBatch(db *DB, ops []BulkOperation[C], results []BulkResult[T], atomic bool, access Access) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		failBulk(ops, results, fmt.Errorf("error starting transaction: %w", err))
+		return
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	if !b.applyBatch(tx, ops, results, atomic, access) {
+		return
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		failBulk(ops, results, fmt.Errorf("error committing transaction: %w", err))
+	}
+}
+
+// applyBatch applies operations within a transaction, recording their outcomes in results, and reports whether the
+// transaction should be committed. In atomic mode the first failure fails the whole batch; otherwise each operation
+// runs in a savepoint that is rolled back when it fails.
+func (b bulkEntity[C, T]) applyBatch(tx *sql.Tx, ops []BulkOperation[C], results []BulkResult[T], atomic bool, access Access) bool {
+	for i, op := range ops {
+		if atomic {
+			if err := b.apply(tx, op, &results[i], access); err != nil {
+				results[i].Err = err
+				failBulk(ops, results, ErrBulkAborted)
+				return false
+			}
+			continue
+		}
+
+		if _, err := tx.Exec(`SAVEPOINT bulk_operation`); err != nil {
+			failBulk(ops[i:], results[i:], fmt.Errorf("error starting operation: %w", err))
+			break
+		}
+
+		if err := b.apply(tx, op, &results[i], access); err != nil {
+			results[i].Err = err
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT bulk_operation`); err != nil {
+				failBulk(ops[i+1:], results[i+1:], fmt.Errorf("error rolling back operation: %w", err))
+				break
+			}
+			continue
+		}
+
+		if _, err := tx.Exec(`RELEASE SAVEPOINT bulk_operation`); err != nil {
+			failBulk(ops[i:], results[i:], fmt.Errorf("error finishing operation: %w", err))
+			break
+		}
+	}
+
+	return true
+}
+
+// apply applies one bulk operation within a transaction, recording the record it creates or updates in result
+func (b bulkEntity[C, T]) apply(tx *sql.Tx, op BulkOperation[C], result *BulkResult[T], access Access) error {
+	switch op.Op {
+	case "create":
+		if b.duplicates != nil && !op.AllowDuplicate {
+			matches, err := b.duplicates(tx, op.Create, b.duplicateRules, access)
+			if err != nil {
+				return err
+			}
+			if matches != nil {
+				result.Matches = matches
+				return ErrDuplicateRecord
+			}
+		}
+
+		record, err := b.insert(tx, op.Create, access)
+		if err != nil {
+			return err
+		}
+		result.ID, result.Record = b.id(record), record
+	case "update":
+		record, err := applyPatch(tx, b.entity, b.patch, b.columns, b.scan, op.ID, op.Patch, op.IfMatch, access)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return ErrRecordNotFound
+		}
+		result.Record = record
+	case "delete":
+		found, err := trashRecord(tx, b.entity, op.ID, op.IfMatch, access)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrRecordNotFound
+		}
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidRecord, op.Op)
+	}
+
+	return nil
+}
+
+// failBulk marks the operations that have not already failed as failed with err, discarding the records they
+// created or updated
+func failBulk[C, T any](ops []BulkOperation[C], results []BulkResult[T], err error) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BulkResult[T]{Index: ops[i].Index, ID: ops[i].ID, Err: err}
+		}
+	}
+}
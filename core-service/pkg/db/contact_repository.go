@@ -5,24 +5,191 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
 
 // ContactRepository handles database operations for contacts
 type ContactRepository struct {
-	db *DB
+	db             *DB
+	duplicateRules []string
 }
 
-// NewContactRepository creates a new contact repository
-func NewContactRepository(db *DB) *ContactRepository {
-	return &ContactRepository{db: db}
+// NewContactRepository creates a new contact repository that checks new contacts against the duplicate rules
+func NewContactRepository(db *DB, duplicates *DuplicateConfig) *ContactRepository {
+	return &ContactRepository{db: db, duplicateRules: duplicates.Rules}
 }
 
-// GetAllContacts retrieves all contacts from the database
-func (r *ContactRepository) GetAllContacts() ([]models.Contact, error) {
-	query := `SELECT id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at 
-              FROM contacts ORDER BY last_name, first_name`
-	rows, err := r.db.Query(query)
+// contactColumns is the column list selected for every contact query
+const contactColumns = `id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at, created_by, updated_by, owner_id, visibility, version, custom_fields`
+
+// contactListSpec defines the sortable and filterable contact fields
+var contactListSpec = listSpec{
+	sortColumns: map[string]string{
+		"last_name":  "last_name",
+		"first_name": "first_name",
+		"email":      "COALESCE(email, '')",
+		"title":      "COALESCE(title, '')",
+		"city":       "COALESCE(city, '')",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "last_name,first_name",
+	filters: map[string]listFilter{
+		"q":              {condition: "search_vector @@ websearch_to_tsquery('english', %s)", kind: filterString},
+		"first_name":     {condition: "first_name ILIKE '%%' || %s || '%%'", kind: filterString},
+		"last_name":      {condition: "last_name ILIKE '%%' || %s || '%%'", kind: filterString},
+		"email":          {condition: "LOWER(email) = LOWER(%s)", kind: filterString},
+		"title":          {condition: "title = %s", kind: filterString},
+		"account_id":     {condition: "account_id = %s", kind: filterUUID},
+		"city":           {condition: "city = %s", kind: filterString},
+		"state":          {condition: "state = %s", kind: filterString},
+		"country":        {condition: "country = %s", kind: filterString},
+		"created_by":     {condition: "created_by = %s", kind: filterUUID},
+		"updated_by":     {condition: "updated_by = %s", kind: filterUUID},
+		"owner_id":       {condition: "owner_id = %s", kind: filterUUID},
+		"visibility":     {condition: "visibility = %s", kind: filterString},
+		"created_after":  {condition: "created_at >= %s", kind: filterTime},
+		"created_before": {condition: "created_at < %s", kind: filterTime},
+		"updated_after":  {condition: "updated_at >= %s", kind: filterTime},
+	},
+}
+
+// contactPatchSpec defines the contact fields a merge patch may change
+var contactPatchSpec = patchSpec{
+	"first_name":    {column: "first_name", kind: patchString, required: true},
+	"last_name":     {column: "last_name", kind: patchString, required: true},
+	"email":         {column: "email", kind: patchString},
+	"phone":         {column: "phone", kind: patchString},
+	"title":         {column: "title", kind: patchString},
+	"account_id":    {column: "account_id", kind: patchUUID},
+	"address":       {column: "address", kind: patchString},
+	"city":          {column: "city", kind: patchString},
+	"state":         {column: "state", kind: patchString},
+	"zip":           {column: "zip", kind: patchString},
+	"country":       {column: "country", kind: patchString},
+	"visibility":    visibilityPatchField,
+	"custom_fields": customFieldsPatchField,
+}
+
+// contactBulk applies bulk contact operations
+var contactBulk = bulkEntity[models.ContactCreate, models.Contact]{
+	entity:  auditContacts,
+	insert:  insertContact,
+	id:      func(c *models.Contact) uuid.UUID { return c.ID },
+	patch:   contactPatchSpec,
+	columns: contactColumns,
+	scan:    scanContact,
+	duplicates: func(tx *sql.Tx, data models.ContactCreate, rules []string, access Access) (interface{}, error) {
+		matches, err := findContactMatches(tx, data, rules, access)
+		if len(matches) == 0 {
+			return nil, err
+		}
+		return matches, nil
+	},
+}
+
+// contactMerge finds and merges duplicate contacts: contacts are compared when they share an email address
+// (ignoring +tags), the last 10 digits of a phone number or a last name and first initial
+var contactMerge = mergeEntity[models.Contact]{
+	entity:  auditContacts,
+	spec:    contactPatchSpec,
+	columns: contactColumns,
+	scan:    scanContact,
+	id:      func(c *models.Contact) uuid.UUID { return c.ID },
+	keys: []string{
+		emailKey,
+		phoneKey,
+		`LOWER(TRIM(last_name)) || ' ' || LEFT(LOWER(TRIM(first_name)), 1)`,
+	},
+	references: []trashLink{
+		{parent: auditContacts, child: auditOpportunities, column: "primary_contact_id"},
+	},
+}
+
+// contactDuplicates checks new contacts against the duplicate rules
+var contactDuplicates = duplicateCheck[models.ContactCreate, models.Contact]{
+	entity:  auditContacts,
+	columns: contactColumns,
+	scan:    scanContact,
+	id:      func(c *models.Contact) uuid.UUID { return c.ID },
+	rules: []matchRule[models.ContactCreate, models.Contact]{
+		{
+			name: DuplicateRuleEmail,
+			condition: func(c models.ContactCreate, args *[]interface{}) (string, string) {
+				email := dedupe.NormalizeEmail(c.Email)
+				if email == "" {
+					return "", ""
+				}
+				return emailKey + " = " + bindMatch(args, email), email
+			},
+		},
+		{
+			name: DuplicateRuleDomainName,
+			condition: func(c models.ContactCreate, args *[]interface{}) (string, string) {
+				domain := dedupe.EmailDomain(c.Email)
+				if domain == "" {
+					return "", ""
+				}
+				first, last := normalizedText(c.FirstName), normalizedText(c.LastName)
+				condition := fmt.Sprintf("%s = %s AND LOWER(TRIM(first_name)) = %s AND LOWER(TRIM(last_name)) = %s",
+					emailDomainKey, bindMatch(args, domain), bindMatch(args, first), bindMatch(args, last))
+				return condition, domain + ":" + first + " " + last
+			},
+		},
+		phoneMatchRule[models.ContactCreate, models.Contact](func(c models.ContactCreate) string { return c.Phone }),
+	},
+}
+
+// scanContact scans a row selected with contactColumns into a contact
+func scanContact(row rowScanner) (*models.Contact, error) {
+	var contact models.Contact
+	var email, phone, title, address, city, state, zip, country sql.NullString
+	var customFields []byte
+	if err := row.Scan(
+		&contact.ID,
+		&contact.FirstName,
+		&contact.LastName,
+		&email,
+		&phone,
+		&title,
+		&contact.AccountID, // NULL account_id scans as uuid.Nil
+		&address,
+		&city,
+		&state,
+		&zip,
+		&country,
+		&contact.CreatedAt,
+		&contact.UpdatedAt,
+		&contact.CreatedBy,
+		&contact.UpdatedBy, // NULL scans as uuid.Nil
+		&contact.OwnerID,
+		&contact.Visibility,
+		&contact.Version,
+		&customFields,
+	); err != nil {
+		return nil, err
+	}
+
+	contact.Email = email.String
+	contact.Phone = phone.String
+	contact.Title = title.String
+	contact.Address = address.String
+	contact.City = city.String
+	contact.State = state.String
+	contact.Zip = zip.String
+	contact.Country = country.String
+
+	if err := decodeCustomFields(customFields, &contact.CustomFields); err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// queryContacts runs a query selecting contactColumns and scans every row
+func (r *ContactRepository) queryContacts(query string, args ...interface{}) ([]models.Contact, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying contacts: %w", err)
 	}
@@ -30,37 +197,11 @@ func (r *ContactRepository) GetAllContacts() ([]models.Contact, error) {
 
 	var contacts []models.Contact
 	for rows.Next() {
-		var contact models.Contact
-		var accountID sql.NullString // Handle NULL account_id
-		if err := rows.Scan(
-			&contact.ID,
-			&contact.FirstName,
-			&contact.LastName,
-			&contact.Email,
-			&contact.Phone,
-			&contact.Title,
-			&accountID,
-			&contact.Address,
-			&contact.City,
-			&contact.State,
-			&contact.Zip,
-			&contact.Country,
-			&contact.CreatedAt,
-			&contact.UpdatedAt,
-		); err != nil {
+		contact, err := scanContact(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning contact row: %w", err)
 		}
-
-		// Convert NullString to UUID if valid
-		if accountID.Valid {
-			parsedID, err := uuid.Parse(accountID.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing account ID: %w", err)
-			}
-			contact.AccountID = parsedID
-		}
-
-		contacts = append(contacts, contact)
+		contacts = append(contacts, *contact)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -70,28 +211,55 @@ func (r *ContactRepository) GetAllContacts() ([]models.Contact, error) {
 	return contacts, nil
 }
 
-// GetContactByID retrieves a single contact by ID
-func (r *ContactRepository) GetContactByID(id uuid.UUID) (*models.Contact, error) {
-	query := `SELECT id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at 
-              FROM contacts WHERE id = $1`
-	var contact models.Contact
-	var accountID sql.NullString // Handle NULL account_id
-	err := r.db.QueryRow(query, id).Scan(
-		&contact.ID,
-		&contact.FirstName,
-		&contact.LastName,
-		&contact.Email,
-		&contact.Phone,
-		&contact.Title,
-		&accountID,
-		&contact.Address,
-		&contact.City,
-		&contact.State,
-		&contact.Zip,
-		&contact.Country,
-		&contact.CreatedAt,
-		&contact.UpdatedAt,
-	)
+// GetAllContacts retrieves a page of the contacts visible to the caller matching the list options, along with its paging metadata
+func (r *ContactRepository) GetAllContacts(opts models.ListOptions, access Access) ([]models.Contact, *models.PageInfo, error) {
+	spec, err := contactListSpec.withCustomFields(r.db, "contact", opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := spec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.restrictTo(access)
+
+	limit, args := q.limit()
+	contacts, err := r.queryContacts(`SELECT `+contactColumns+` FROM contacts`+q.where+q.orderBy+limit, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return finishPage(r.db, q, "contacts", contacts, func(c models.Contact) uuid.UUID { return c.ID })
+}
+
+// ExportContacts passes every contact visible to the caller matching the list filters to each, in list order,
+// streaming them from the database
+func (r *ContactRepository) ExportContacts(opts models.ListOptions, access Access, each func(*models.Contact) error) error {
+	spec, err := contactListSpec.withCustomFields(r.db, "contact", opts)
+	if err != nil {
+		return err
+	}
+
+	return streamList(r.db, spec, "contacts", contactColumns, scanContact, opts, access, nil, each)
+}
+
+// GetDuplicateCandidates returns pairs of contacts visible to the caller that may be duplicates, to be scored
+func (r *ContactRepository) GetDuplicateCandidates(access Access) ([][2]models.Contact, error) {
+	return contactMerge.duplicateCandidates(r.db, access)
+}
+
+// MergeContacts merges duplicate contacts into the survivor, moving their opportunities and notes to it, and
+// returns the survivor. It returns nil when the survivor does not exist or is not visible to the caller.
+func (r *ContactRepository) MergeContacts(request models.MergeRequest, ifMatch Versions, access Access) (*models.Contact, error) {
+	return contactMerge.merge(r.db, request, ifMatch, access)
+}
+
+// GetContactByID retrieves a single contact by ID if it is visible to the caller
+func (r *ContactRepository) GetContactByID(id uuid.UUID, access Access) (*models.Contact, error) {
+	args := []interface{}{id}
+	query := `SELECT ` + contactColumns + ` FROM contacts WHERE id = $1 AND ` + access.visibleCondition(&args)
+	contact, err := scanContact(r.db.QueryRow(query, args...))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No contact found
@@ -99,72 +267,89 @@ func (r *ContactRepository) GetContactByID(id uuid.UUID) (*models.Contact, error
 		return nil, fmt.Errorf("error querying contact by ID: %w", err)
 	}
 
-	// Convert NullString to UUID if valid
-	if accountID.Valid {
-		parsedID, err := uuid.Parse(accountID.String)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing account ID: %w", err)
-		}
-		contact.AccountID = parsedID
-	}
+	return contact, nil
+}
 
-	return &contact, nil
+// GetContactsByAccountID retrieves the contacts of a specific account that are visible to the caller
+func (r *ContactRepository) GetContactsByAccountID(accountID uuid.UUID, access Access) ([]models.Contact, error) {
+	args := []interface{}{accountID}
+	query := `SELECT ` + contactColumns + ` FROM contacts WHERE account_id = $1 AND ` + access.visibleCondition(&args) +
+		` ORDER BY last_name, first_name, id`
+	return r.queryContacts(query, args...)
 }
 
-// GetContactsByAccountID retrieves all contacts for a specific account
-func (r *ContactRepository) GetContactsByAccountID(accountID uuid.UUID) ([]models.Contact, error) {
-	query := `SELECT id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at 
-              FROM contacts WHERE account_id = $1 ORDER BY last_name, first_name`
-	rows, err := r.db.Query(query, accountID)
+// CreateContact creates a new contact created and owned by the acting user; it is public unless another visibility is given.
+// Unless allowDuplicate is set, it returns ErrDuplicateRecord with the contacts visible to the caller that the new
+// one matches under the duplicate rules, if there are any.
+func (r *ContactRepository) CreateContact(contactData models.ContactCreate, allowDuplicate bool, access Access) (*models.Contact, []models.ContactMatch, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("error querying contacts by account ID: %w", err)
+		return nil, nil, fmt.Errorf("error starting transaction: %w", err)
 	}
-	defer rows.Close()
 
-	var contacts []models.Contact
-	for rows.Next() {
-		var contact models.Contact
-		if err := rows.Scan(
-			&contact.ID,
-			&contact.FirstName,
-			&contact.LastName,
-			&contact.Email,
-			&contact.Phone,
-			&contact.Title,
-			&contact.AccountID,
-			&contact.Address,
-			&contact.City,
-			&contact.State,
-			&contact.Zip,
-			&contact.Country,
-			&contact.CreatedAt,
-			&contact.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning contact row: %w", err)
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	if !allowDuplicate {
+		matches, err := findContactMatches(tx, contactData, r.duplicateRules, access)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(matches) > 0 {
+			return nil, matches, ErrDuplicateRecord
 		}
-		contacts = append(contacts, contact)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating contact rows: %w", err)
+	contact, err := insertContact(tx, contactData, access)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return contacts, nil
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return contact, nil, nil
 }
 
-// CreateContact creates a new contact in the database
-func (r *ContactRepository) CreateContact(contactData models.ContactCreate) (*models.Contact, error) {
-	query := `INSERT INTO contacts (first_name, last_name, email, phone, title, account_id, address, city, state, zip, country) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) 
-              RETURNING id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at`
+// findContactMatches returns the contacts visible to the caller that a contact to create matches under the
+// duplicate rules
+func findContactMatches(tx *sql.Tx, contactData models.ContactCreate, rules []string, access Access) ([]models.ContactMatch, error) {
+	found, err := contactDuplicates.find(tx, contactData, rules, access)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.ContactMatch, len(found))
+	for i, match := range found {
+		matches[i] = models.ContactMatch{Rules: match.rules, Record: match.record}
+	}
+	return matches, nil
+}
+
+// insertContact creates a contact within a transaction and records it in the audit log
+func insertContact(tx *sql.Tx, contactData models.ContactCreate, access Access) (*models.Contact, error) {
+	query := `INSERT INTO contacts (first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_by, updated_by, owner_id, visibility, custom_fields) 
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12, $12, COALESCE(NULLIF($13, ''), 'public'), COALESCE($14::jsonb, '{}')) 
+              RETURNING ` + contactColumns
+
+	if err := checkParents(tx, auditContacts, uuid.Nil, map[string]uuid.UUID{"account_id": contactData.AccountID}, access); err != nil {
+		return nil, err
+	}
+
+	customFields, err := encodeCustomFields(contactData.CustomFields)
+	if err != nil {
+		return nil, err
+	}
 
-	var contact models.Contact
 	var accountID interface{} = nil
 	if contactData.AccountID != uuid.Nil {
 		accountID = contactData.AccountID
 	}
 
-	err := r.db.QueryRow(
+	contact, err := scanContact(tx.QueryRow(
 		query,
 		contactData.FirstName,
 		contactData.LastName,
@@ -177,32 +362,58 @@ func (r *ContactRepository) CreateContact(contactData models.ContactCreate) (*mo
 		contactData.State,
 		contactData.Zip,
 		contactData.Country,
-	).Scan(
-		&contact.ID,
-		&contact.FirstName,
-		&contact.LastName,
-		&contact.Email,
-		&contact.Phone,
-		&contact.Title,
-		&contact.AccountID,
-		&contact.Address,
-		&contact.City,
-		&contact.State,
-		&contact.Zip,
-		&contact.Country,
-		&contact.CreatedAt,
-		&contact.UpdatedAt,
-	)
+		access.UserID,
+		contactData.Visibility,
+		customFields,
+	))
 
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, fmt.Errorf("%w: a referenced record does not exist", ErrInvalidRecord)
+		}
 		return nil, fmt.Errorf("error creating contact: %w", err)
 	}
 
-	return &contact, nil
+	if err := recordAudit(tx, access.Actor, auditContacts, contact.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return contact, nil
 }
 
-// UpdateContact updates an existing contact in the database
-func (r *ContactRepository) UpdateContact(id uuid.UUID, contactData models.ContactUpdate) (*models.Contact, error) {
+// UpdateContact updates an existing contact in the database; only its owner can change it
+func (r *ContactRepository) UpdateContact(id uuid.UUID, contactData models.ContactUpdate, ifMatch Versions, access Access) (*models.Contact, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, "contacts", id, ifMatch, access)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // No contact found with this ID
+	}
+
+	if err := checkParents(tx, auditContacts, id, map[string]uuid.UUID{"account_id": contactData.AccountID}, access); err != nil {
+		return nil, err
+	}
+
+	before, err := auditContacts.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	customFields, err := encodeCustomFields(contactData.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE contacts SET 
               first_name = COALESCE(NULLIF($1, ''), first_name),
               last_name = COALESCE(NULLIF($2, ''), last_name),
@@ -215,17 +426,19 @@ func (r *ContactRepository) UpdateContact(id uuid.UUID, contactData models.Conta
               state = COALESCE(NULLIF($9, ''), state),
               zip = COALESCE(NULLIF($10, ''), zip),
               country = COALESCE(NULLIF($11, ''), country),
+              visibility = COALESCE(NULLIF($13, ''), visibility),
+              custom_fields = jsonb_strip_nulls(custom_fields || COALESCE($15::jsonb, '{}')),
+              updated_by = $14,
               updated_at = NOW()
               WHERE id = $12
-              RETURNING id, first_name, last_name, email, phone, title, account_id, address, city, state, zip, country, created_at, updated_at`
+              RETURNING ` + contactColumns
 
-	var contact models.Contact
 	var accountID interface{} = nil
 	if contactData.AccountID != uuid.Nil {
 		accountID = contactData.AccountID
 	}
 
-	err := r.db.QueryRow(
+	contact, err := scanContact(tx.QueryRow(
 		query,
 		contactData.FirstName,
 		contactData.LastName,
@@ -239,22 +452,10 @@ func (r *ContactRepository) UpdateContact(id uuid.UUID, contactData models.Conta
 		contactData.Zip,
 		contactData.Country,
 		id,
-	).Scan(
-		&contact.ID,
-		&contact.FirstName,
-		&contact.LastName,
-		&contact.Email,
-		&contact.Phone,
-		&contact.Title,
-		&contact.AccountID,
-		&contact.Address,
-		&contact.City,
-		&contact.State,
-		&contact.Zip,
-		&contact.Country,
-		&contact.CreatedAt,
-		&contact.UpdatedAt,
-	)
+		contactData.Visibility,
+		access.UserID,
+		customFields,
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -263,24 +464,63 @@ func (r *ContactRepository) UpdateContact(id uuid.UUID, contactData models.Conta
 		return nil, fmt.Errorf("error updating contact: %w", err)
 	}
 
-	return &contact, nil
+	if err := recordAudit(tx, access.Actor, auditContacts, id, before); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return contact, nil
+}
+
+// PatchContact applies a merge patch to a contact; only its owner can change it
+func (r *ContactRepository) PatchContact(id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*models.Contact, error) {
+	return patchRecord(r.db, auditContacts, contactPatchSpec, contactColumns, scanContact, id, patch, ifMatch, access)
+}
+
+// BulkContacts creates, updates and deletes contacts in one request; see bulkEntity.run. Creates are checked against
+// the duplicate rules unless they allow duplicates.
+func (r *ContactRepository) BulkContacts(ops []BulkOperation[models.ContactCreate], atomic bool, access Access) []BulkResult[models.Contact] {
+	return contactBulk.withDuplicateRules(r.duplicateRules).run(r.db, ops, atomic, access)
 }
 
-// DeleteContact deletes a contact from the database
-func (r *ContactRepository) DeleteContact(id uuid.UUID) error {
-	query := `DELETE FROM contacts WHERE id = $1`
-	result, err := r.db.Exec(query, id)
+// DeleteContact moves a contact to the trash; only its owner can delete it
+func (r *ContactRepository) DeleteContact(id uuid.UUID, ifMatch Versions, access Access) error {
+	found, err := moveToTrash(r.db, auditContacts, id, ifMatch, access)
 	if err != nil {
-		return fmt.Errorf("error deleting contact: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no contact found with ID %s", id)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return nil
+}
+
+// RestoreContact takes a contact out of the trash; only its owner can restore it
+func (r *ContactRepository) RestoreContact(id uuid.UUID, access Access) error {
+	found, err := restoreFromTrash(r.db, auditContacts, id, access)
 	if err != nil {
-		return fmt.Errorf("error getting rows affected: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no contact found in the trash with ID %s", ErrRecordNotFound, id)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no contact found with ID %s", id)
+	return nil
+}
+
+// TransferContact gives a contact to another active user; only its owner can transfer it
+func (r *ContactRepository) TransferContact(id, ownerID uuid.UUID, access Access) error {
+	found, err := transferOwnership(r.db, auditContacts, id, ownerID, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no contact found with ID %s", ErrRecordNotFound, id)
 	}
 
 	return nil
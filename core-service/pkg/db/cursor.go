@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// listCursor is the decoded form of the opaque cursor handed to API clients.
+// It records the sort in effect and the sort key of the last row on the previous page.
+type listCursor struct {
+	Sort   string   `json:"s,omitempty"`
+	Values []string `json:"v"`
+}
+
+// encodeCursor serializes a cursor into a URL-safe opaque string
+func encodeCursor(c listCursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("error encoding cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor parses an opaque cursor string produced by encodeCursor
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return c, nil
+}
+
+// keysetCondition builds the WHERE condition that selects the rows after the cursor position.
+// For sort fields (a, b, id) it produces (a > $1) OR (a = $1 AND b > $2) OR (a = $1 AND b = $2 AND id > $3),
+// flipping the comparison for descending fields.
+func (q *listQuery) keysetCondition(c listCursor) (string, error) {
+	if c.Sort != q.opts.Sort {
+		return "", fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidListOptions)
+	}
+	if len(c.Values) != len(q.fields) {
+		return "", fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+
+	placeholders := make([]string, len(c.Values))
+	for i, value := range c.Values {
+		q.args = append(q.args, value)
+		placeholders[i] = fmt.Sprintf("$%d", len(q.args))
+	}
+
+	var alternatives []string
+	for i, field := range q.fields {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", q.fields[j].expr, placeholders[j]))
+		}
+
+		op := ">"
+		if field.desc {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", field.expr, op, placeholders[i]))
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", nil
+}
+
+// nextCursor reads the sort key of the given row and encodes it as the cursor for the following page.
+// Values are carried as text and cast back by Postgres when compared against the sort expressions.
+func (q *listQuery) nextCursor(db *DB, table string, lastID uuid.UUID) (string, error) {
+	columns := make([]string, len(q.fields))
+	values := make([]string, len(q.fields))
+	dest := make([]interface{}, len(q.fields))
+	for i, field := range q.fields {
+		columns[i] = field.expr + "::text"
+		dest[i] = &values[i]
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", strings.Join(columns, ", "), table)
+	if err := db.QueryRow(query, lastID).Scan(dest...); err != nil {
+		return "", fmt.Errorf("error reading cursor position: %w", err)
+	}
+
+	return encodeCursor(listCursor{Sort: q.opts.Sort, Values: values})
+}
@@ -0,0 +1,302 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/customfields"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateCustomField is returned when a record type already has a custom field with the name
+var ErrDuplicateCustomField = errors.New("a custom field with this name already exists")
+
+// CustomFieldRepository handles database operations for custom field definitions
+type CustomFieldRepository struct {
+	db *DB
+}
+
+// NewCustomFieldRepository creates a new custom field repository
+func NewCustomFieldRepository(db *DB) *CustomFieldRepository {
+	return &CustomFieldRepository{db: db}
+}
+
+// customFieldEntities maps the record types that have custom fields to their audited entities
+var customFieldEntities = map[string]auditEntity{
+	auditAccounts.entityType:      auditAccounts,
+	auditContacts.entityType:      auditContacts,
+	auditOpportunities.entityType: auditOpportunities,
+}
+
+// customFieldColumns is the column list selected for every custom field definition query
+const customFieldColumns = `id, entity_type, name, label, field_type, required, options, default_value, created_at, updated_at, created_by, updated_by`
+
+// customFieldPrefix starts the names of the list filters and sort fields on custom fields, such as custom_fields.region
+const customFieldPrefix = "custom_fields."
+
+// scanCustomField scans a row selected with customFieldColumns into a custom field definition
+func scanCustomField(row rowScanner) (*models.CustomFieldDefinition, error) {
+	var field models.CustomFieldDefinition
+	var options, defaultValue []byte
+	if err := row.Scan(
+		&field.ID,
+		&field.EntityType,
+		&field.Name,
+		&field.Label,
+		&field.Type,
+		&field.Required,
+		&options,
+		&defaultValue,
+		&field.CreatedAt,
+		&field.UpdatedAt,
+		&field.CreatedBy, // NULL scans as uuid.Nil
+		&field.UpdatedBy, // NULL scans as uuid.Nil
+	); err != nil {
+		return nil, err
+	}
+
+	if options != nil {
+		if err := json.Unmarshal(options, &field.Options); err != nil {
+			return nil, fmt.Errorf("error decoding custom field options: %w", err)
+		}
+	}
+	if defaultValue != nil {
+		if err := json.Unmarshal(defaultValue, &field.Default); err != nil {
+			return nil, fmt.Errorf("error decoding custom field default: %w", err)
+		}
+	}
+
+	return &field, nil
+}
+
+// GetCustomFieldDefinitions retrieves the custom fields of a record type, or of every type when it is empty,
+// in the order they were defined
+func (r *CustomFieldRepository) GetCustomFieldDefinitions(entityType string) ([]models.CustomFieldDefinition, error) {
+	return customFieldDefinitions(r.db, entityType)
+}
+
+// customFieldDefinitions retrieves the custom fields of a record type, or of every type when it is empty
+func customFieldDefinitions(db *DB, entityType string) ([]models.CustomFieldDefinition, error) {
+	query := `SELECT ` + customFieldColumns + ` FROM custom_field_definitions
+              WHERE $1 = '' OR entity_type = $1
+              ORDER BY entity_type, created_at, id`
+	rows, err := db.Query(query, entityType)
+	if err != nil {
+		return nil, fmt.Errorf("error querying custom fields: %w", err)
+	}
+	defer rows.Close()
+
+	var fields []models.CustomFieldDefinition
+	for rows.Next() {
+		field, err := scanCustomField(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning custom field row: %w", err)
+		}
+		fields = append(fields, *field)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating custom field rows: %w", err)
+	}
+
+	return fields, nil
+}
+
+// GetCustomFieldDefinitionByID retrieves a single custom field definition by ID
+func (r *CustomFieldRepository) GetCustomFieldDefinitionByID(id uuid.UUID) (*models.CustomFieldDefinition, error) {
+	field, err := scanCustomField(r.db.QueryRow(`SELECT `+customFieldColumns+` FROM custom_field_definitions WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No custom field found
+		}
+		return nil, fmt.Errorf("error querying custom field by ID: %w", err)
+	}
+
+	return field, nil
+}
+
+// CreateCustomFieldDefinition defines a new custom field; its options and default must already be validated
+func (r *CustomFieldRepository) CreateCustomFieldDefinition(data models.CustomFieldDefinitionCreate, actor Actor) (*models.CustomFieldDefinition, error) {
+	options, defaultValue, err := encodeCustomFieldSettings(data.Options, data.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO custom_field_definitions (entity_type, name, label, field_type, required, options, default_value, created_by, updated_by)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+              RETURNING ` + customFieldColumns
+	field, err := scanCustomField(r.db.QueryRow(query, data.EntityType, data.Name, data.Label, data.Type, data.Required,
+		options, defaultValue, actor.UserID))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateCustomField
+		}
+		return nil, fmt.Errorf("error creating custom field: %w", err)
+	}
+
+	return field, nil
+}
+
+// UpdateCustomFieldDefinition replaces the label, requirement, options and default of a custom field; its options
+// and default must already be validated. Values records already have are kept even if they are no longer valid.
+func (r *CustomFieldRepository) UpdateCustomFieldDefinition(id uuid.UUID, data models.CustomFieldDefinitionUpdate, actor Actor) (*models.CustomFieldDefinition, error) {
+	options, defaultValue, err := encodeCustomFieldSettings(data.Options, data.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE custom_field_definitions SET label = $1, required = $2, options = $3, default_value = $4,
+              updated_by = $5, updated_at = NOW()
+              WHERE id = $6
+              RETURNING ` + customFieldColumns
+	field, err := scanCustomField(r.db.QueryRow(query, data.Label, data.Required, options, defaultValue, actor.UserID, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No custom field found with this ID
+		}
+		return nil, fmt.Errorf("error updating custom field: %w", err)
+	}
+
+	return field, nil
+}
+
+// DeleteCustomFieldDefinition deletes a custom field and removes its value from every record, in the trash or not,
+// recording each changed record in the audit log. It returns false when the custom field does not exist.
+func (r *CustomFieldRepository) DeleteCustomFieldDefinition(id uuid.UUID, actor Actor) (bool, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	var entityType, name string
+	err = tx.QueryRow(`SELECT entity_type, name FROM custom_field_definitions WHERE id = $1 FOR UPDATE`, id).Scan(&entityType, &name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil // No custom field found with this ID
+		}
+		return false, fmt.Errorf("error querying custom field: %w", err)
+	}
+
+	entity := customFieldEntities[entityType]
+	snapshots, err := entity.takeSnapshots(tx, fmt.Sprintf(`SELECT id FROM %s WHERE custom_fields ? $1`, entity.table), name)
+	if err != nil {
+		return false, err
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET custom_fields = custom_fields - $1, updated_by = $2, updated_at = NOW()
+              WHERE custom_fields ? $1`, entity.table)
+	if _, err := tx.Exec(query, name, actor.UserID); err != nil {
+		return false, fmt.Errorf("error removing custom field values: %w", err)
+	}
+
+	if err := recordAudits(tx, actor, entity, snapshots); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM custom_field_definitions WHERE id = $1`, id); err != nil {
+		return false, fmt.Errorf("error deleting custom field: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// encodeCustomFieldSettings encodes the options and default of a custom field for their JSONB columns;
+// nil leaves a column NULL
+func encodeCustomFieldSettings(options []string, defaultValue interface{}) (interface{}, interface{}, error) {
+	var encodedOptions, encodedDefault interface{}
+	if len(options) > 0 {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding custom field options: %w", err)
+		}
+		encodedOptions = string(data)
+	}
+	if defaultValue != nil {
+		data, err := json.Marshal(defaultValue)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding custom field default: %w", err)
+		}
+		encodedDefault = string(data)
+	}
+	return encodedOptions, encodedDefault, nil
+}
+
+// encodeCustomFields encodes custom field values for a JSONB parameter; nil values are encoded as null and nil
+// maps as a NULL parameter
+func encodeCustomFields(values map[string]interface{}) (interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding custom fields: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeCustomFields decodes a custom_fields column into values
+func decodeCustomFields(raw []byte, values *map[string]interface{}) error {
+	if err := json.Unmarshal(raw, values); err != nil {
+		return fmt.Errorf("error decoding custom fields: %w", err)
+	}
+	return nil
+}
+
+// withCustomFields returns the list spec extended with a filter and a sort field named custom_fields.<name> for
+// each custom field of the record type. The definitions are only read when the list options use a custom field.
+// Filters match values exactly; records without a value sort as the empty string, -Infinity or false, so first in
+// ascending order.
+func (s listSpec) withCustomFields(db *DB, entityType string, opts models.ListOptions) (listSpec, error) {
+	used := strings.Contains(opts.Sort, customFieldPrefix)
+	for name := range opts.Filters {
+		used = used || strings.HasPrefix(name, customFieldPrefix)
+	}
+	if !used {
+		return s, nil
+	}
+
+	definitions, err := customFieldDefinitions(db, entityType)
+	if err != nil {
+		return s, err
+	}
+
+	extended := listSpec{sortColumns: maps.Clone(s.sortColumns), defaultSort: s.defaultSort, filters: maps.Clone(s.filters)}
+	for _, field := range definitions {
+		name := customFieldPrefix + field.Name
+		key := pq.QuoteLiteral(field.Name)
+		contains := "custom_fields @> jsonb_build_object(" + key + ", %s::"
+		switch field.Type {
+		case customfields.TypeNumber:
+			extended.filters[name] = listFilter{condition: contains + "numeric)", kind: filterNumber}
+			extended.sortColumns[name] = fmt.Sprintf(
+				"COALESCE(CASE WHEN jsonb_typeof(custom_fields -> %[1]s) = 'number' THEN (custom_fields ->> %[1]s)::float8 END, '-Infinity')", key)
+		case customfields.TypeBoolean:
+			extended.filters[name] = listFilter{condition: contains + "boolean)", kind: filterBool}
+			extended.sortColumns[name] = fmt.Sprintf(
+				"COALESCE(CASE WHEN jsonb_typeof(custom_fields -> %[1]s) = 'boolean' THEN (custom_fields ->> %[1]s)::boolean END, false)", key)
+		case customfields.TypeDate:
+			extended.filters[name] = listFilter{condition: contains + "text)", kind: filterDate}
+			extended.sortColumns[name] = fmt.Sprintf("COALESCE(custom_fields ->> %s, '')", key) // YYYY-MM-DD sorts as text
+		default:
+			extended.filters[name] = listFilter{condition: contains + "text)", kind: filterString}
+			extended.sortColumns[name] = fmt.Sprintf("COALESCE(custom_fields ->> %s, '')", key)
+		}
+	}
+
+	return extended, nil
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
+)
+
+// ErrDuplicateRecord is returned when a record to create matches existing records under the duplicate rules
+var ErrDuplicateRecord = errors.New("a matching record already exists")
+
+// Duplicate rules new contacts and accounts are checked against
+const (
+	DuplicateRuleEmail      = "email"       // Contacts with the same email address, ignoring case and +tags
+	DuplicateRuleDomainName = "domain_name" // Contacts with the same name and email domain; accounts with the same name and website domain
+	DuplicateRulePhone      = "phone"       // Records with the same phone number, by its last 10 digits
+)
+
+// maxDuplicateMatches is the number of matching records each rule reports
+const maxDuplicateMatches = 10
+
+// SQL expressions that normalize columns the way pkg/dedupe normalizes values, shared by the duplicate rules,
+// the duplicate reports and the indexes of migration 000020_add_duplicate_keys
+const (
+	emailKey         = `regexp_replace(LOWER(TRIM(email)), '\+[^@]*@', '@')`
+	emailDomainKey   = `SUBSTRING(LOWER(TRIM(email)) FROM '@(.*)$')`
+	phoneKey         = `CASE WHEN LENGTH(regexp_replace(phone, '\D', '', 'g')) >= 7 THEN RIGHT(regexp_replace(phone, '\D', '', 'g'), 10) END`
+	websiteDomainKey = `regexp_replace(LOWER(TRIM(website)), '^([a-z][a-z0-9+.-]*://)?(www\.)?([^/:?#]*).*$', '\3')`
+	accountNameKey   = `split_part(TRIM(regexp_replace(regexp_replace(LOWER(name), '^\s*the\s+', ''), '[^a-z0-9]+', ' ', 'g')), ' ', 1)`
+)
+
+// accountNameKeyPattern and accountNameSeparators compute accountNameKey for a name in Go
+var (
+	accountNameKeyPattern = regexp.MustCompile(`^\s*the\s+`)
+	accountNameSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// firstNameWord returns the value accountNameKey has for an account name
+func firstNameWord(name string) string {
+	name = accountNameSeparators.ReplaceAllString(accountNameKeyPattern.ReplaceAllString(strings.ToLower(name), ""), " ")
+	word, _, _ := strings.Cut(strings.TrimSpace(name), " ")
+	return word
+}
+
+// DuplicateConfig lists the rules new contacts and accounts are checked against
+type DuplicateConfig struct {
+	Rules []string
+}
+
+// LoadDuplicateConfig reads the duplicate rules from an environment variable:
+//
+//	DUPLICATE_RULES  comma-separated rules new contacts and accounts are checked against, from email, domain_name
+//	                 and phone (default all three; none turns the check off)
+func LoadDuplicateConfig() (*DuplicateConfig, error) {
+	value := strings.TrimSpace(getEnv("DUPLICATE_RULES", "email,domain_name,phone"))
+	config := &DuplicateConfig{}
+	if value == "none" || value == "" {
+		return config, nil
+	}
+
+	known := []string{DuplicateRuleEmail, DuplicateRuleDomainName, DuplicateRulePhone}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if !slices.Contains(known, rule) {
+			return nil, fmt.Errorf("invalid DUPLICATE_RULES: unknown rule %q; rules are %s or none", rule, strings.Join(known, ", "))
+		}
+		if !slices.Contains(config.Rules, rule) {
+			config.Rules = append(config.Rules, rule)
+		}
+	}
+
+	return config, nil
+}
+
+// matchRule finds the existing records that a record to create with C would duplicate
+type matchRule[C, T any] struct {
+	name string
+	// condition returns the SQL condition matching records like the new one, binding its values to args, and
+	// the key that identifies what is matched on; it returns "" when the new record has nothing to match on
+	condition func(data C, args *[]interface{}) (string, string)
+	// keep, if set, filters the records the condition matches
+	keep func(data C, record *T) bool
+}
+
+// duplicateMatch is an existing record and the rules a record to create matches it under
+type duplicateMatch[T any] struct {
+	rules  []string
+	record T
+}
+
+// duplicateCheck describes how records of one type to create are checked for duplicates
+type duplicateCheck[C, T any] struct {
+	entity  auditEntity
+	columns string
+	scan    func(rowScanner) (*T, error)
+	id      func(*T) uuid.UUID
+	rules   []matchRule[C, T]
+}
+
+// find returns the records visible to the caller that a record to create matches under the enabled rules.
+// It holds a lock on each value matched on until the transaction ends, so that records with the same values
+// created at the same time see each other.
+func (d duplicateCheck[C, T]) find(tx *sql.Tx, data C, enabled []string, access Access) ([]duplicateMatch[T], error) {
+	var matches []duplicateMatch[T]
+	index := map[uuid.UUID]int{}
+	for _, rule := range d.rules {
+		if !slices.Contains(enabled, rule.name) {
+			continue
+		}
+
+		var args []interface{}
+		condition, key := rule.condition(data, &args)
+		if condition == "" {
+			continue
+		}
+
+		lock := d.entity.table + ":" + rule.name + ":" + key
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, lock); err != nil {
+			return nil, fmt.Errorf("error locking %s duplicate check: %w", d.entity.entityType, err)
+		}
+
+		query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s AND %s ORDER BY created_at, id LIMIT %d`,
+			d.columns, d.entity.table, condition, access.visibleCondition(&args), maxDuplicateMatches)
+		records, err := d.query(tx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range records {
+			if rule.keep != nil && !rule.keep(data, &records[i]) {
+				continue
+			}
+
+			id := d.id(&records[i])
+			if n, ok := index[id]; ok {
+				matches[n].rules = append(matches[n].rules, rule.name)
+				continue
+			}
+			index[id] = len(matches)
+			matches = append(matches, duplicateMatch[T]{rules: []string{rule.name}, record: records[i]})
+		}
+	}
+
+	return matches, nil
+}
+
+// query runs a query selecting records with the check's columns within a transaction
+func (d duplicateCheck[C, T]) query(tx *sql.Tx, query string, args ...interface{}) ([]T, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying matching %s: %w", d.entity.table, err)
+	}
+	defer rows.Close()
+
+	var records []T
+	for rows.Next() {
+		record, err := d.scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning matching %s row: %w", d.entity.entityType, err)
+		}
+		records = append(records, *record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating matching %s rows: %w", d.entity.entityType, err)
+	}
+
+	return records, nil
+}
+
+// bindMatch appends a value to args and returns its placeholder
+func bindMatch(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return fmt.Sprintf("$%d", len(*args))
+}
+
+// normalizedText lowercases and trims text the way LOWER(TRIM(...)) does
+func normalizedText(text string) string {
+	return strings.ToLower(strings.TrimSpace(text))
+}
+
+// phoneMatchRule matches records with the same phone number
+func phoneMatchRule[C, T any](phone func(C) string) matchRule[C, T] {
+	return matchRule[C, T]{
+		name: DuplicateRulePhone,
+		condition: func(data C, args *[]interface{}) (string, string) {
+			number := dedupe.NormalizePhone(phone(data))
+			if number == "" {
+				return "", ""
+			}
+			return phoneKey + " = " + bindMatch(args, number), number
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// exportChunkSize is the number of exported rows loaded before they are handed on, so related data can be
+// loaded for many rows at once
+const exportChunkSize = 500
+
+// streamList runs a list query without paging and passes each row to each as it is read from the database,
+// so the result never has to fit in memory. Rows are read in chunks; prepare, if set, runs on each chunk
+// before its rows are passed on. Paging options are ignored. Errors returned by each stop the export and are
+// returned as they are.
+func streamList[T any](db *DB, spec listSpec, table, columns string, scan func(rowScanner) (*T, error),
+	opts models.ListOptions, access Access, prepare func([]T) error, each func(*T) error) error {
+	opts.Cursor = ""
+	q, err := spec.build(opts)
+	if err != nil {
+		return err
+	}
+	q.restrictTo(access)
+
+	rows, err := db.Query(`SELECT `+columns+` FROM `+table+q.where+q.orderBy, q.args...)
+	if err != nil {
+		return fmt.Errorf("error querying %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	chunk := make([]T, 0, exportChunkSize)
+	flush := func() error {
+		if prepare != nil {
+			if err := prepare(chunk); err != nil {
+				return err
+			}
+		}
+		for i := range chunk {
+			if err := each(&chunk[i]); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return fmt.Errorf("error scanning %s row: %w", table, err)
+		}
+
+		chunk = append(chunk, *item)
+		if len(chunk) == exportChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating %s rows: %w", table, err)
+	}
+
+	return flush()
+}
@@ -0,0 +1,367 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrImportTaken is returned when a batch of an import job has already been imported, because another worker
+// resumed the job while this one seemed to have stopped
+var ErrImportTaken = errors.New("import job was taken over by another worker")
+
+// ImportRepository handles database operations for CSV import jobs
+type ImportRepository struct {
+	db             *DB
+	duplicateRules []string
+}
+
+// NewImportRepository creates a new import repository that checks imported contacts and accounts against the
+// duplicate rules
+func NewImportRepository(db *DB, duplicates *DuplicateConfig) *ImportRepository {
+	return &ImportRepository{db: db, duplicateRules: duplicates.Rules}
+}
+
+// ImportJobCreate is used for queueing a new import job
+type ImportJobCreate struct {
+	RecordType     string
+	FileName       string
+	Content        []byte
+	Mapping        map[string]string
+	TotalRows      int
+	AllowDuplicate bool // Whether rows may match existing records under the duplicate rules
+}
+
+// ImportClaim is an import job claimed by a worker, with its file and the access of the user who started it
+type ImportClaim struct {
+	Job     models.ImportJob
+	Content []byte
+	Access  Access
+}
+
+// ImportBatch is the next rows of an import job: Rows are the valid ones, created with their line as Index, and
+// Errors are why the others cannot be imported. Progress moves from Start to End rows processed.
+type ImportBatch[C any] struct {
+	Start, End int
+	Rows       []BulkOperation[C]
+	Errors     []models.ImportRowError
+}
+
+// importColumns is the column list selected for every import job query
+const importColumns = `id, record_type, status, file_name, mapping, allow_duplicate, total_rows, processed_rows, imported_rows,
+                       failed_rows, COALESCE(error, ''), created_by, created_at, started_at, finished_at`
+
+// importListSpec defines the sortable and filterable import job fields
+var importListSpec = listSpec{
+	sortColumns: map[string]string{
+		"created_at": "created_at",
+	},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"record_type": {condition: "record_type = %s", kind: filterString},
+		"status":      {condition: "status = %s", kind: filterString},
+		"created_by":  {condition: "created_by = %s", kind: filterUUID},
+	},
+}
+
+// scanImportJob scans a row selected with importColumns into an import job
+func scanImportJob(row rowScanner) (*models.ImportJob, error) {
+	var job models.ImportJob
+	var mapping []byte
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(
+		&job.ID,
+		&job.RecordType,
+		&job.Status,
+		&job.FileName,
+		&mapping,
+		&job.AllowDuplicate,
+		&job.TotalRows,
+		&job.ProcessedRows,
+		&job.ImportedRows,
+		&job.FailedRows,
+		&job.Error,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&startedAt,
+		&finishedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(mapping, &job.Mapping); err != nil {
+		return nil, fmt.Errorf("error decoding import mapping: %w", err)
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	job.Progress = 100
+	if job.TotalRows > 0 {
+		job.Progress = job.ProcessedRows * 100 / job.TotalRows
+	}
+
+	return &job, nil
+}
+
+// ownedImportCondition returns the SQL condition matching the import jobs the caller may see: their own,
+// or every job for admins
+func ownedImportCondition(access Access, args *[]interface{}) string {
+	if access.AllRecords {
+		return "TRUE"
+	}
+
+	*args = append(*args, access.UserID)
+	return fmt.Sprintf("created_by = $%d", len(*args))
+}
+
+// CreateImportJob queues a CSV file to be imported by the caller
+func (r *ImportRepository) CreateImportJob(data ImportJobCreate, access Access) (*models.ImportJob, error) {
+	mapping, err := json.Marshal(data.Mapping)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding import mapping: %w", err)
+	}
+
+	query := `INSERT INTO import_jobs (record_type, file_name, content, mapping, allow_duplicate, total_rows, created_by, all_records, request_id)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+              RETURNING ` + importColumns
+
+	job, err := scanImportJob(r.db.QueryRow(
+		query,
+		data.RecordType,
+		data.FileName,
+		data.Content,
+		mapping,
+		data.AllowDuplicate,
+		data.TotalRows,
+		access.UserID,
+		access.AllRecords,
+		access.RequestID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating import job: %w", err)
+	}
+
+	return job, nil
+}
+
+// GetImportJobs retrieves a page of the import jobs visible to the caller, newest first by default
+func (r *ImportRepository) GetImportJobs(opts models.ListOptions, access Access) ([]models.ImportJob, *models.PageInfo, error) {
+	q, err := importListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.addCondition(ownedImportCondition(access, &q.args))
+
+	limit, args := q.limit()
+	query := `SELECT ` + importColumns + ` FROM import_jobs` + q.where + q.orderBy + limit
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying import jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.ImportJob
+	for rows.Next() {
+		job, err := scanImportJob(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning import job row: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating import job rows: %w", err)
+	}
+
+	return finishPage(r.db, q, "import_jobs", jobs, func(j models.ImportJob) uuid.UUID { return j.ID })
+}
+
+// GetImportJobByID retrieves a single import job visible to the caller
+func (r *ImportRepository) GetImportJobByID(id uuid.UUID, access Access) (*models.ImportJob, error) {
+	args := []interface{}{id}
+	query := `SELECT ` + importColumns + ` FROM import_jobs WHERE id = $1 AND ` + ownedImportCondition(access, &args)
+	job, err := scanImportJob(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No import job found
+		}
+		return nil, fmt.Errorf("error querying import job by ID: %w", err)
+	}
+
+	return job, nil
+}
+
+// GetImportErrors retrieves the file and the row errors of an import job visible to the caller, in file order.
+// The file is nil when the job does not exist.
+func (r *ImportRepository) GetImportErrors(id uuid.UUID, access Access) ([]byte, []models.ImportRowError, error) {
+	args := []interface{}{id}
+	var content []byte
+	query := `SELECT content FROM import_jobs WHERE id = $1 AND ` + ownedImportCondition(access, &args)
+	if err := r.db.QueryRow(query, args...).Scan(&content); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, nil // No import job found
+		}
+		return nil, nil, fmt.Errorf("error querying import job by ID: %w", err)
+	}
+
+	rows, err := r.db.Query(`SELECT line, column_name, message FROM import_errors WHERE job_id = $1 ORDER BY line, id`, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying import errors: %w", err)
+	}
+	defer rows.Close()
+
+	var rowErrors []models.ImportRowError
+	for rows.Next() {
+		var rowError models.ImportRowError
+		if err := rows.Scan(&rowError.Line, &rowError.Column, &rowError.Error); err != nil {
+			return nil, nil, fmt.Errorf("error scanning import error row: %w", err)
+		}
+		rowErrors = append(rowErrors, rowError)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating import error rows: %w", err)
+	}
+
+	return content, rowErrors, nil
+}
+
+// ClaimImportJob marks the oldest pending import job as running and returns it, or nil when there is none.
+// Running jobs that have made no progress for staleAfter are claimed again, to be resumed where they stopped.
+func (r *ImportRepository) ClaimImportJob(staleAfter time.Duration) (*ImportClaim, error) {
+	// Skip jobs another instance is claiming
+	query := `UPDATE import_jobs SET status = 'running', started_at = COALESCE(started_at, NOW()), heartbeat_at = NOW()
+              WHERE id = (SELECT id FROM import_jobs
+                          WHERE status = 'pending' OR (status = 'running' AND heartbeat_at < $1)
+                          ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED)
+              RETURNING id, created_by, all_records, COALESCE(request_id, ''), content`
+
+	var id uuid.UUID
+	var claim ImportClaim
+	if err := r.db.QueryRow(query, time.Now().Add(-staleAfter)).Scan(
+		&id,
+		&claim.Access.UserID,
+		&claim.Access.AllRecords,
+		&claim.Access.RequestID,
+		&claim.Content,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No job waiting
+		}
+		return nil, fmt.Errorf("error claiming import job: %w", err)
+	}
+
+	job, err := scanImportJob(r.db.QueryRow(`SELECT `+importColumns+` FROM import_jobs WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("error querying import job by ID: %w", err)
+	}
+
+	claim.Job = *job
+	return &claim, nil
+}
+
+// FinishImportJob marks a running import job as completed, or as failed with the reason when failure is not empty
+func (r *ImportRepository) FinishImportJob(id uuid.UUID, failure string) error {
+	status := "completed"
+	if failure != "" {
+		status = "failed"
+	}
+
+	query := `UPDATE import_jobs SET status = $2, error = NULLIF($3, ''), finished_at = NOW() WHERE id = $1 AND status = 'running'`
+	if _, err := r.db.Exec(query, id, status, failure); err != nil {
+		return fmt.Errorf("error finishing import job: %w", err)
+	}
+
+	return nil
+}
+
+// ImportAccounts imports the next batch of accounts of a job; see importBatch
+func (r *ImportRepository) ImportAccounts(claim *ImportClaim, batch ImportBatch[models.AccountCreate]) error {
+	return importBatch(r.db, accountBulk.withDuplicateRules(r.duplicateRules), claim, batch)
+}
+
+// ImportContacts imports the next batch of contacts of a job; see importBatch
+func (r *ImportRepository) ImportContacts(claim *ImportClaim, batch ImportBatch[models.ContactCreate]) error {
+	return importBatch(r.db, contactBulk.withDuplicateRules(r.duplicateRules), claim, batch)
+}
+
+// ImportOpportunities imports the next batch of opportunities of a job; see importBatch
+func (r *ImportRepository) ImportOpportunities(claim *ImportClaim, batch ImportBatch[models.OpportunityCreate]) error {
+	return importBatch(r.db, opportunityBulk, claim, batch)
+}
+
+// importBatch creates the valid rows of a batch like a partial bulk request, as the user who started the job,
+// rejecting rows that match existing records under the duplicate rules unless the job allows duplicates, and records the rows that failed and the job's progress in the same transaction, so that a job resumed after
+// a crash carries on after the last batch imported. It returns ErrImportTaken when the job is no longer at the
+// batch's start.
+func importBatch[C, T any](db *DB, b bulkEntity[C, T], claim *ImportClaim, batch ImportBatch[C]) error {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	var status string
+	var processed int
+	query := `SELECT status, processed_rows FROM import_jobs WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, claim.Job.ID).Scan(&status, &processed); err != nil {
+		return fmt.Errorf("error locking import job: %w", err)
+	}
+	if status != "running" || processed != batch.Start {
+		return ErrImportTaken
+	}
+
+	results := make([]BulkResult[T], len(batch.Rows))
+	for i, op := range batch.Rows {
+		results[i] = BulkResult[T]{Index: op.Index}
+	}
+	b.applyBatch(tx, batch.Rows, results, false, claim.Access)
+
+	rowErrors := batch.Errors
+	for _, result := range results {
+		if result.Err != nil {
+			rowErrors = append(rowErrors, models.ImportRowError{Line: result.Index, Error: result.Err.Error()})
+		}
+	}
+
+	failedLines := map[int]bool{}
+	for _, rowError := range rowErrors {
+		failedLines[rowError.Line] = true
+		query := `INSERT INTO import_errors (job_id, line, column_name, message) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(query, claim.Job.ID, rowError.Line, rowError.Column, rowError.Error); err != nil {
+			return fmt.Errorf("error recording import error: %w", err)
+		}
+	}
+
+	imported := len(batch.Rows)
+	for _, result := range results {
+		if result.Err != nil {
+			imported--
+		}
+	}
+
+	query = `UPDATE import_jobs SET processed_rows = $2, imported_rows = imported_rows + $3, failed_rows = failed_rows + $4,
+             heartbeat_at = NOW() WHERE id = $1`
+	if _, err := tx.Exec(query, claim.Job.ID, batch.End, imported, len(failedLines)); err != nil {
+		return fmt.Errorf("error recording import progress: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
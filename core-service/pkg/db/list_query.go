@@ -0,0 +1,232 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrInvalidListOptions is returned when a list request uses an unknown sort field or filter,
+// or a filter value that cannot be parsed
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// filterKind describes how a filter value is parsed before being bound to the query
+type filterKind int
+
+const (
+	filterString filterKind = iota
+	filterUUID
+	filterTime
+	filterNumber
+	filterBool
+	filterDate // A date (YYYY-MM-DD), bound as text
+)
+
+// listFilter maps a query-string filter onto a SQL condition.
+// The condition must contain a single %s, which is replaced with the bound parameter.
+type listFilter struct {
+	condition string
+	kind      filterKind
+}
+
+// listSpec describes which fields of an entity may be used to sort and filter list queries
+type listSpec struct {
+	sortColumns map[string]string     // Sort field -> SQL expression (must be non-NULL)
+	defaultSort string                // Sort used when the caller does not provide one
+	filters     map[string]listFilter // Filter name -> SQL condition
+}
+
+// listQuery is the SQL fragments built from ListOptions for a list query
+type listQuery struct {
+	opts    models.ListOptions
+	fields  []sortField
+	where   string
+	orderBy string
+	args    []interface{}
+}
+
+// sortField is a single parsed entry of a sort expression
+type sortField struct {
+	expr string
+	desc bool
+}
+
+// parseSort converts a sort expression such as "-created_at,name" into SQL sort fields.
+// The id column is always appended as a tie-breaker so the order is stable.
+func (s listSpec) parseSort(expr string) ([]sortField, error) {
+	if expr == "" {
+		expr = s.defaultSort
+	}
+
+	var fields []sortField
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimPrefix(part, "-")
+		column, ok := s.sortColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidListOptions, name)
+		}
+		fields = append(fields, sortField{expr: column, desc: desc})
+	}
+
+	return append(fields, sortField{expr: "id"}), nil
+}
+
+// build creates the WHERE and ORDER BY clauses for the given list options
+func (s listSpec) build(opts models.ListOptions) (*listQuery, error) {
+	q := &listQuery{opts: opts}
+
+	// Apply filters in a stable order so identical requests produce identical SQL
+	names := make([]string, 0, len(opts.Filters))
+	for name := range opts.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conditions []string
+	for _, name := range names {
+		value := opts.Filters[name]
+		filter, ok := s.filters[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown filter %q", ErrInvalidListOptions, name)
+		}
+
+		arg, err := parseFilterValue(filter.kind, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value for filter %q: %v", ErrInvalidListOptions, name, err)
+		}
+
+		q.args = append(q.args, arg)
+		conditions = append(conditions, fmt.Sprintf(filter.condition, fmt.Sprintf("$%d", len(q.args))))
+	}
+
+	fields, err := s.parseSort(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	q.fields = fields
+
+	// In cursor mode, continue after the last row of the previous page
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := q.keysetCondition(c)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if len(conditions) > 0 {
+		q.where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var order []string
+	for _, field := range fields {
+		if field.desc {
+			order = append(order, field.expr+" DESC")
+		} else {
+			order = append(order, field.expr)
+		}
+	}
+	q.orderBy = " ORDER BY " + strings.Join(order, ", ")
+
+	return q, nil
+}
+
+// addCondition adds a condition to the WHERE clause; its parameters must already be bound to q.args
+func (q *listQuery) addCondition(condition string) {
+	if q.where == "" {
+		q.where = " WHERE " + condition
+	} else {
+		q.where += " AND " + condition
+	}
+}
+
+// limit returns the LIMIT/OFFSET clause, binding the values after the existing arguments.
+// One row more than requested is fetched so finishPage can tell whether another page follows.
+func (q *listQuery) limit() (string, []interface{}) {
+	args := append(append([]interface{}{}, q.args...), q.opts.Limit+1)
+	if q.opts.Cursor != "" {
+		return fmt.Sprintf(" LIMIT $%d", len(args)), args
+	}
+	args = append(args, q.opts.Offset)
+	return fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
+// parseFilterValue converts a raw filter value into the type expected by the database
+func parseFilterValue(kind filterKind, value string) (interface{}, error) {
+	switch kind {
+	case filterUUID:
+		return uuid.Parse(value)
+	case filterTime:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", value)
+	case filterNumber:
+		return strconv.ParseFloat(value, 64)
+	case filterBool:
+		return strconv.ParseBool(value)
+	case filterDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	default:
+		return value, nil
+	}
+}
+
+// count returns the number of rows in the table that match the list query filters
+func (q *listQuery) count(db *DB, table string) (int, error) {
+	var total int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", table, q.where)
+	if err := db.QueryRow(query, q.args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("error counting %s: %w", table, err)
+	}
+	return total, nil
+}
+
+// finishPage trims the extra row fetched by limit and builds the paging metadata for the response.
+// The total is only counted in offset mode; cursor mode skips it to keep deep pages cheap.
+func finishPage[T any](db *DB, q *listQuery, table string, items []T, id func(T) uuid.UUID) ([]T, *models.PageInfo, error) {
+	page := &models.PageInfo{}
+
+	if q.opts.Cursor == "" {
+		total, err := q.count(db, table)
+		if err != nil {
+			return nil, nil, err
+		}
+		page.Total = total
+	}
+
+	if len(items) > q.opts.Limit {
+		items = items[:q.opts.Limit]
+		next, err := q.nextCursor(db, table, id(items[len(items)-1]))
+		if err != nil {
+			return nil, nil, err
+		}
+		page.NextCursor = next
+	}
+
+	return items, page, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// Scopes of login throttles
+const (
+	ThrottleScopeEmail = "email"
+	ThrottleScopeIP    = "ip"
+)
+
+// LoginAttemptRepository handles database operations for the login audit trail and failed-attempt throttles
+type LoginAttemptRepository struct {
+	db *DB
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository
+func NewLoginAttemptRepository(db *DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// loginAttemptColumns is the column list selected for every login attempt query
+const loginAttemptColumns = `id, email, user_id, ip_address, user_agent, succeeded, failure_reason, created_at`
+
+// loginAttemptListSpec defines the sortable and filterable login attempt fields
+var loginAttemptListSpec = listSpec{
+	sortColumns: map[string]string{
+		"created_at": "created_at",
+		"email":      "email",
+	},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"email":          {condition: "LOWER(email) = LOWER(%s)", kind: filterString},
+		"user_id":        {condition: "user_id = %s", kind: filterUUID},
+		"ip_address":     {condition: "ip_address = %s", kind: filterString},
+		"succeeded":      {condition: "succeeded = %s", kind: filterBool},
+		"failure_reason": {condition: "failure_reason = %s", kind: filterString},
+		"created_after":  {condition: "created_at >= %s", kind: filterTime},
+		"created_before": {condition: "created_at < %s", kind: filterTime},
+	},
+}
+
+// scanLoginAttempt scans a row selected with loginAttemptColumns into a login attempt
+func scanLoginAttempt(row rowScanner) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	var ipAddress, userAgent, failureReason sql.NullString
+	if err := row.Scan(
+		&attempt.ID,
+		&attempt.Email,
+		&attempt.UserID, // NULL scans as uuid.Nil
+		&ipAddress,
+		&userAgent,
+		&attempt.Succeeded,
+		&failureReason,
+		&attempt.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	attempt.IPAddress = ipAddress.String
+	attempt.UserAgent = userAgent.String
+	attempt.FailureReason = failureReason.String
+
+	return &attempt, nil
+}
+
+// GetAllLoginAttempts retrieves a page of login attempts matching the list options, along with its paging metadata
+func (r *LoginAttemptRepository) GetAllLoginAttempts(opts models.ListOptions) ([]models.LoginAttempt, *models.PageInfo, error) {
+	q, err := loginAttemptListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit, args := q.limit()
+	rows, err := r.db.Query(`SELECT `+loginAttemptColumns+` FROM login_attempts`+q.where+q.orderBy+limit, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning login attempt row: %w", err)
+		}
+		attempts = append(attempts, *attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating login attempt rows: %w", err)
+	}
+
+	return finishPage(r.db, q, "login_attempts", attempts, func(a models.LoginAttempt) uuid.UUID { return a.ID })
+}
+
+// RecordAttempt adds an entry to the login audit trail
+func (r *LoginAttemptRepository) RecordAttempt(attempt models.LoginAttempt) error {
+	var userID interface{} = nil
+	if attempt.UserID != uuid.Nil {
+		userID = attempt.UserID
+	}
+
+	var failureReason interface{} = nil
+	if attempt.FailureReason != "" {
+		failureReason = attempt.FailureReason
+	}
+
+	query := `INSERT INTO login_attempts (email, user_id, ip_address, user_agent, succeeded, failure_reason)
+              VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.db.Exec(query, attempt.Email, userID, attempt.IPAddress, attempt.UserAgent, attempt.Succeeded, failureReason)
+	if err != nil {
+		return fmt.Errorf("error recording login attempt: %w", err)
+	}
+
+	return nil
+}
+
+// GetThrottle retrieves the failed-attempt counter for an email address or IP, or nil if there is none
+func (r *LoginAttemptRepository) GetThrottle(scope, key string) (*models.LoginThrottle, error) {
+	query := `SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE scope = $1 AND key = $2`
+
+	var throttle models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow(query, scope, key).Scan(&throttle.Failures, &throttle.LastFailureAt, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No failures recorded
+		}
+		return nil, fmt.Errorf("error querying login throttle: %w", err)
+	}
+
+	if lockedUntil.Valid {
+		throttle.LockedUntil = &lockedUntil.Time
+	}
+
+	return &throttle, nil
+}
+
+// RecordFailure counts a failed attempt for an email address or IP and locks it once maxFailures is reached.
+// Failures older than window restart the count.
+func (r *LoginAttemptRepository) RecordFailure(scope, key string, maxFailures int, window, lockout time.Duration) (*models.LoginThrottle, error) {
+	query := `INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+              VALUES ($1, $2, 1, NOW())
+              ON CONFLICT (scope, key) DO UPDATE SET
+              failures = CASE WHEN login_throttles.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+                              ELSE login_throttles.failures + 1 END,
+              last_failure_at = NOW()
+              RETURNING failures, last_failure_at, locked_until`
+
+	var throttle models.LoginThrottle
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow(query, scope, key, window.Seconds()).Scan(&throttle.Failures, &throttle.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("error recording login failure: %w", err)
+	}
+
+	if lockedUntil.Valid {
+		throttle.LockedUntil = &lockedUntil.Time
+	}
+
+	if throttle.Failures >= maxFailures {
+		lockUntil := throttle.LastFailureAt.Add(lockout)
+		_, err := r.db.Exec(`UPDATE login_throttles SET locked_until = $1 WHERE scope = $2 AND key = $3`, lockUntil, scope, key)
+		if err != nil {
+			return nil, fmt.Errorf("error locking login: %w", err)
+		}
+		throttle.LockedUntil = &lockUntil
+	}
+
+	return &throttle, nil
+}
+
+// ClearThrottle forgets the failed attempts of an email address or IP, lifting any lock
+func (r *LoginAttemptRepository) ClearThrottle(scope, key string) error {
+	_, err := r.db.Exec(`DELETE FROM login_throttles WHERE scope = $1 AND key = $2`, scope, key)
+	if err != nil {
+		return fmt.Errorf("error clearing login throttle: %w", err)
+	}
+	return nil
+}
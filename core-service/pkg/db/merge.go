@@ -0,0 +1,349 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+// ErrInvalidMerge is returned when a merge names a record more than once or keeps a field from a record
+// that is not part of it
+var ErrInvalidMerge = errors.New("invalid merge")
+
+// maxDuplicateCandidates is the number of candidate pairs a duplicate report considers
+const maxDuplicateCandidates = 5000
+
+// mergeEntity describes how duplicates of one type of record are found and merged
+type mergeEntity[T any] struct {
+	entity  auditEntity
+	spec    patchSpec // The fields whose values can be kept from a duplicate
+	columns string
+	scan    func(rowScanner) (*T, error)
+	id      func(*T) uuid.UUID
+	// keys are SQL expressions that duplicates are likely to share, such as a normalized email address.
+	// Only records with the same non-empty value of a key are compared.
+	keys []string
+	// references are the foreign keys of other records that are moved to the survivor
+	references []trashLink
+}
+
+// duplicateCandidates returns the pairs of records visible to the caller that share a key, up to
+// maxDuplicateCandidates of them, for the caller to score
+func (m mergeEntity[T]) duplicateCandidates(db *DB, access Access) ([][2]T, error) {
+	var args []interface{}
+	visible := access.visibleCondition(&args)
+
+	keys := make([]string, len(m.keys))
+	joins := make([]string, len(m.keys))
+	for i, key := range m.keys {
+		keys[i] = fmt.Sprintf("%s AS key%d", key, i)
+		joins[i] = fmt.Sprintf(`SELECT a.id AS a_id, b.id AS b_id FROM candidates a
+                JOIN candidates b ON a.key%[1]d = b.key%[1]d AND a.id < b.id WHERE a.key%[1]d <> ''`, i)
+	}
+
+	args = append(args, maxDuplicateCandidates)
+	query := fmt.Sprintf(`WITH candidates AS (SELECT id, %s FROM %s WHERE %s)
+              SELECT a_id, b_id FROM (%s) pairs ORDER BY a_id, b_id LIMIT $%d`,
+		strings.Join(keys, ", "), m.entity.table, visible, strings.Join(joins, " UNION "), len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying duplicate %s: %w", m.entity.table, err)
+	}
+	defer rows.Close()
+
+	var pairs [][2]uuid.UUID
+	var ids []string
+	seen := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var pair [2]uuid.UUID
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			return nil, fmt.Errorf("error scanning duplicate %s row: %w", m.entity.table, err)
+		}
+		pairs = append(pairs, pair)
+		for _, id := range pair {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id.String())
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating duplicate %s rows: %w", m.entity.table, err)
+	}
+
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	records, err := m.recordsByID(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([][2]T, 0, len(pairs))
+	for _, pair := range pairs {
+		a, b := records[pair[0]], records[pair[1]]
+		if a == nil || b == nil {
+			continue // Deleted since the pairs were listed
+		}
+		candidates = append(candidates, [2]T{*a, *b})
+	}
+
+	return candidates, nil
+}
+
+// recordsByID returns the records with the given IDs that are not in the trash
+func (m mergeEntity[T]) recordsByID(db *DB, ids []string) (map[uuid.UUID]*T, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL`, m.columns, m.entity.table)
+	rows, err := db.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", m.entity.table, err)
+	}
+	defer rows.Close()
+
+	records := make(map[uuid.UUID]*T, len(ids))
+	for rows.Next() {
+		record, err := m.scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s row: %w", m.entity.table, err)
+		}
+		records[m.id(record)] = record
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s rows: %w", m.entity.table, err)
+	}
+
+	return records, nil
+}
+
+// merge merges duplicates into the survivor in one transaction: the survivor takes the chosen value of each field,
+// the records and notes that refer to a duplicate are moved to the survivor, and the duplicates go to the trash.
+// The caller must be able to change every record; ifMatch applies to the survivor. It returns the survivor, or nil
+// when it does not exist or is not visible to the caller.
+func (m mergeEntity[T]) merge(db *DB, request models.MergeRequest, ifMatch Versions, access Access) (*T, error) {
+	if err := m.check(request); err != nil {
+		return nil, err
+	}
+
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	// Lock the records in ID order so that merges of overlapping records cannot deadlock
+	ids := append([]uuid.UUID{request.SurvivorID}, request.DuplicateIDs...)
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	snapshots := make(map[uuid.UUID]map[string]interface{}, len(ids))
+	for _, id := range ids {
+		var versions Versions
+		if id == request.SurvivorID {
+			versions = ifMatch
+		}
+
+		found, err := checkWritable(tx, m.entity.table, id, versions, access)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if id == request.SurvivorID {
+				return nil, nil // No record found with this ID
+			}
+			return nil, fmt.Errorf("%w: %s %s", ErrRecordNotFound, m.entity.entityType, id)
+		}
+
+		if snapshots[id], err = m.entity.takeSnapshot(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	patch, err := m.mergedValues(request, snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, duplicateID := range request.DuplicateIDs {
+		for _, link := range m.references {
+			condition := link.column + ` = $1`
+			set := link.column + ` = $2, updated_by = $3, updated_at = NOW()`
+			if err := updateChildren(tx, access, link, duplicateID, condition, set, request.SurvivorID, access.UserID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := moveNoteAssociations(tx, access, m.entity.entityType, request.SurvivorID, request.DuplicateIDs); err != nil {
+		return nil, err
+	}
+
+	record, err := applyPatch(tx, m.entity, m.spec, m.columns, m.scan, request.SurvivorID, patch, nil, access)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, duplicateID := range request.DuplicateIDs {
+		if _, err := trashRecord(tx, m.entity, duplicateID, nil, access); err != nil {
+			return nil, err
+		}
+		merged := map[string]models.AuditChange{"merged_into": {New: request.SurvivorID}}
+		if err := insertAuditEvent(tx, access.Actor, m.entity.entityType, duplicateID, "merge", merged); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := map[string]models.AuditChange{"merged_ids": {New: request.DuplicateIDs}}
+	if err := insertAuditEvent(tx, access.Actor, m.entity.entityType, request.SurvivorID, "merge", merged); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return record, nil
+}
+
+// check checks that a merge names each record once and only keeps values of fields that can be merged from
+// its records
+func (m mergeEntity[T]) check(request models.MergeRequest) error {
+	records := map[uuid.UUID]bool{request.SurvivorID: true}
+	for _, id := range request.DuplicateIDs {
+		if id == request.SurvivorID {
+			return fmt.Errorf("%w: the survivor cannot also be a duplicate", ErrInvalidMerge)
+		}
+		if records[id] {
+			return fmt.Errorf("%w: duplicate %s is listed more than once", ErrInvalidMerge, id)
+		}
+		records[id] = true
+	}
+
+	for name, source := range request.Fields {
+		if _, ok := m.spec[name]; !ok {
+			return fmt.Errorf("%w: field %q cannot be merged", ErrInvalidMerge, name)
+		}
+		if !records[source] {
+			return fmt.Errorf("%w: the value of %s must come from the survivor or a duplicate", ErrInvalidMerge, name)
+		}
+	}
+
+	return nil
+}
+
+// mergedValues returns the merge patch that gives the survivor the value kept for each field: the one of the record
+// chosen for it, otherwise the survivor's own, or the first duplicate's when the survivor has none. Object fields,
+// such as custom_fields, are merged key by key the same way.
+func (m mergeEntity[T]) mergedValues(request models.MergeRequest, snapshots map[uuid.UUID]map[string]interface{}) (models.MergePatch, error) {
+	survivor := snapshots[request.SurvivorID]
+	patch := models.MergePatch{}
+	for name, field := range m.spec {
+		value := survivor[field.column]
+		if field.kind == patchObject {
+			changes := mergedObject(request, snapshots, name, field.column)
+			if len(changes) == 0 {
+				continue
+			}
+			value = changes
+		} else if source, ok := request.Fields[name]; ok {
+			value = snapshots[source][field.column]
+		} else if value == nil || value == "" {
+			for _, id := range request.DuplicateIDs {
+				if duplicate := snapshots[id][field.column]; duplicate != nil && duplicate != "" {
+					value = duplicate
+					break
+				}
+			}
+		}
+
+		if reflect.DeepEqual(value, survivor[field.column]) {
+			continue
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding merged %s: %w", name, err)
+		}
+		patch[name] = raw
+	}
+
+	return patch, nil
+}
+
+// mergedObject returns the keys of an object field that the survivor of a merge takes from the duplicates: every
+// key of the record chosen for the field whose value differs from the survivor's, or else the keys the survivor
+// lacks, from the first duplicate that has each
+func mergedObject(request models.MergeRequest, snapshots map[uuid.UUID]map[string]interface{}, name, column string) map[string]interface{} {
+	survivor, _ := snapshots[request.SurvivorID][column].(map[string]interface{})
+	changes := map[string]interface{}{}
+	if source, ok := request.Fields[name]; ok {
+		values, _ := snapshots[source][column].(map[string]interface{})
+		for key, value := range values {
+			if !reflect.DeepEqual(value, survivor[key]) {
+				changes[key] = value
+			}
+		}
+		return changes
+	}
+
+	for _, id := range request.DuplicateIDs {
+		values, _ := snapshots[id][column].(map[string]interface{})
+		for key, value := range values {
+			if _, ok := survivor[key]; ok {
+				continue
+			}
+			if _, ok := changes[key]; !ok {
+				changes[key] = value
+			}
+		}
+	}
+	return changes
+}
+
+// moveNoteAssociations moves the notes associated with the duplicates of a merge to the survivor and records
+// the change of each note in the audit log
+func moveNoteAssociations(tx *sql.Tx, access Access, recordType string, survivorID uuid.UUID, duplicateIDs []uuid.UUID) error {
+	ids := make([]string, len(duplicateIDs))
+	for i, id := range duplicateIDs {
+		ids[i] = id.String()
+	}
+
+	notes, err := auditNotes.takeSnapshots(tx, `SELECT DISTINCT note_id FROM note_associations
+              WHERE record_type = $1 AND record_id = ANY($2::uuid[])`, recordType, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+
+	// A note associated with the survivor and a duplicate keeps a single association
+	query := `INSERT INTO note_associations (note_id, record_id, record_type)
+              SELECT note_id, $3, record_type FROM note_associations WHERE record_type = $1 AND record_id = ANY($2::uuid[])
+              ON CONFLICT DO NOTHING`
+	if _, err := tx.Exec(query, recordType, pq.Array(ids), survivorID); err != nil {
+		return fmt.Errorf("error moving note associations: %w", err)
+	}
+
+	query = `DELETE FROM note_associations WHERE record_type = $1 AND record_id = ANY($2::uuid[])`
+	if _, err := tx.Exec(query, recordType, pq.Array(ids)); err != nil {
+		return fmt.Errorf("error moving note associations: %w", err)
+	}
+
+	return recordAudits(tx, access.Actor, auditNotes, notes)
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrMFAAlreadyEnabled is returned when enrolling or activating MFA for a user who already uses it
+var ErrMFAAlreadyEnabled = errors.New("multi-factor authentication is already enabled")
+
+// MFARepository handles database operations for TOTP secrets, recovery codes and login challenges
+type MFARepository struct {
+	db *DB
+}
+
+// NewMFARepository creates a new MFA repository
+func NewMFARepository(db *DB) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+// GetSecret retrieves the user's encrypted TOTP secret and the last time step accepted for it.
+// The secret is empty when the user has not started enrollment.
+func (r *MFARepository) GetSecret(userID uuid.UUID) (string, int64, error) {
+	query := `SELECT mfa_secret, mfa_last_step FROM users WHERE id = $1`
+
+	var secret sql.NullString
+	var lastStep sql.NullInt64
+	if err := r.db.QueryRow(query, userID).Scan(&secret, &lastStep); err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, nil // No user found
+		}
+		return "", 0, fmt.Errorf("error querying MFA secret: %w", err)
+	}
+
+	return secret.String, lastStep.Int64, nil
+}
+
+// SetPendingSecret stores a new secret for a user who has not activated MFA yet, replacing an unfinished enrollment
+func (r *MFARepository) SetPendingSecret(userID uuid.UUID, sealedSecret string) error {
+	query := `UPDATE users SET mfa_secret = $2, mfa_last_step = NULL WHERE id = $1 AND NOT mfa_enabled`
+	result, err := r.db.Exec(query, userID, sealedSecret)
+	if err != nil {
+		return fmt.Errorf("error storing MFA secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// ActivateMFA turns on MFA once the user confirmed the pending secret with the code of the given time step,
+// and stores the hashes of their recovery codes
+func (r *MFARepository) ActivateMFA(userID uuid.UUID, step int64, codeHashes []string) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	query := `UPDATE users SET mfa_enabled = TRUE, mfa_last_step = $2
+              WHERE id = $1 AND NOT mfa_enabled AND mfa_secret IS NOT NULL`
+	result, err := tx.Exec(query, userID, step)
+	if err != nil {
+		return fmt.Errorf("error activating MFA: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DisableMFA turns off MFA for the user and removes their secret, recovery codes and pending login challenges;
+// it returns ErrUserNotFound when the user does not exist
+func (r *MFARepository) DisableMFA(userID uuid.UUID) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET mfa_enabled = FALSE, mfa_secret = NULL, mfa_last_step = NULL WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("error disabling MFA: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_challenges WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error deleting MFA challenges: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records that a code of the given time step was accepted. It returns false when that step or a later
+// one was already used, so concurrent requests cannot both succeed with the same code.
+func (r *MFARepository) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `UPDATE users SET mfa_last_step = $2 WHERE id = $1 AND (mfa_last_step IS NULL OR mfa_last_step < $2)`
+	result, err := r.db.Exec(query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("error recording TOTP step: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used, returning false when there is no such code
+func (r *MFARepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := r.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("error using recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left
+func (r *MFARepository) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes
+func (r *MFARepository) RegenerateRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores the given hashes in their place
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("error creating recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CreateChallenge stores a login challenge for a user who passed the password step
+func (r *MFARepository) CreateChallenge(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	if _, err := r.db.Exec(query, userID, tokenHash, expiresAt); err != nil {
+		return fmt.Errorf("error creating MFA challenge: %w", err)
+	}
+	return nil
+}
+
+// GetChallenge retrieves an unused, unexpired challenge by its token hash that has had fewer than maxAttempts
+// wrong codes. It returns nil when there is no such challenge.
+func (r *MFARepository) GetChallenge(tokenHash string, maxAttempts int) (*models.MFAChallenge, error) {
+	query := `SELECT id, user_id, attempts, expires_at FROM mfa_challenges
+              WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2`
+
+	var challenge models.MFAChallenge
+	err := r.db.QueryRow(query, tokenHash, maxAttempts).Scan(&challenge.ID, &challenge.UserID, &challenge.Attempts, &challenge.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No usable challenge
+		}
+		return nil, fmt.Errorf("error querying MFA challenge: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+// FailChallenge counts a wrong code entered for the challenge
+func (r *MFARepository) FailChallenge(id uuid.UUID) error {
+	if _, err := r.db.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error updating MFA challenge: %w", err)
+	}
+	return nil
+}
+
+// ConsumeChallenge marks the challenge as used, returning false when it was already used
+func (r *MFARepository) ConsumeChallenge(id uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(`UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("error consuming MFA challenge: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
 )
 
 // NoteRepository handles database operations for notes
@@ -18,10 +19,58 @@ func NewNoteRepository(db *DB) *NoteRepository {
 	return &NoteRepository{db: db}
 }
 
-// GetAllNotes retrieves all notes from the database
-func (r *NoteRepository) GetAllNotes() ([]models.Note, error) {
-	query := `SELECT id, content, created_by, created_at, updated_at FROM notes ORDER BY created_at DESC`
-	rows, err := r.db.Query(query)
+// noteColumns is the column list selected for every note query
+const noteColumns = `id, content, created_by, updated_by, created_at, updated_at, owner_id, visibility, version`
+
+// noteListSpec defines the sortable and filterable note fields
+var noteListSpec = listSpec{
+	sortColumns: map[string]string{
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"q":              {condition: "search_vector @@ websearch_to_tsquery('english', %s)", kind: filterString},
+		"created_by":     {condition: "created_by = %s", kind: filterUUID},
+		"updated_by":     {condition: "updated_by = %s", kind: filterUUID},
+		"owner_id":       {condition: "owner_id = %s", kind: filterUUID},
+		"visibility":     {condition: "visibility = %s", kind: filterString},
+		"created_after":  {condition: "created_at >= %s", kind: filterTime},
+		"created_before": {condition: "created_at < %s", kind: filterTime},
+		"updated_after":  {condition: "updated_at >= %s", kind: filterTime},
+		"record_type":    {condition: "EXISTS (SELECT 1 FROM note_associations na WHERE na.note_id = notes.id AND na.record_type = %s)", kind: filterString},
+		"record_id":      {condition: "EXISTS (SELECT 1 FROM note_associations na WHERE na.note_id = notes.id AND na.record_id = %s)", kind: filterUUID},
+	},
+}
+
+// notePatchSpec defines the note fields a merge patch may change; associations have their own endpoints
+var notePatchSpec = patchSpec{
+	"content":    {column: "content", kind: patchString, required: true},
+	"visibility": visibilityPatchField,
+}
+
+// scanNote scans a row selected with noteColumns into a note
+func scanNote(row rowScanner) (*models.Note, error) {
+	var note models.Note
+	if err := row.Scan(
+		&note.ID,
+		&note.Content,
+		&note.CreatedBy, // NULL scans as uuid.Nil
+		&note.UpdatedBy,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.OwnerID,
+		&note.Visibility,
+		&note.Version,
+	); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// queryNotes runs a query selecting noteColumns and loads the associations of every note returned
+func (r *NoteRepository) queryNotes(query string, args ...interface{}) ([]models.Note, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying notes: %w", err)
 	}
@@ -29,192 +78,119 @@ func (r *NoteRepository) GetAllNotes() ([]models.Note, error) {
 
 	var notes []models.Note
 	for rows.Next() {
-		var note models.Note
-		var createdBy sql.NullString // Handle NULL values
-
-		if err := rows.Scan(
-			&note.ID,
-			&note.Content,
-			&createdBy,
-			&note.CreatedAt,
-			&note.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning note row: %w", err)
-		}
-
-		// Convert NullString to UUID if valid
-		if createdBy.Valid {
-			parsedID, err := uuid.Parse(createdBy.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing user ID: %w", err)
-			}
-			note.CreatedBy = parsedID
-		}
-
-		// Get note associations
-		associationsQuery := `SELECT record_id, record_type FROM note_associations WHERE note_id = $1`
-		associationRows, err := r.db.Query(associationsQuery, note.ID)
+		note, err := scanNote(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error querying note associations: %w", err)
-		}
-
-		var associations []models.RecordAssociation
-		for associationRows.Next() {
-			var association models.RecordAssociation
-			if err := associationRows.Scan(&association.RecordID, &association.RecordType); err != nil {
-				associationRows.Close()
-				return nil, fmt.Errorf("error scanning note association row: %w", err)
-			}
-			associations = append(associations, association)
-		}
-		associationRows.Close()
-
-		if err := associationRows.Err(); err != nil {
-			return nil, fmt.Errorf("error iterating note association rows: %w", err)
+			return nil, fmt.Errorf("error scanning note row: %w", err)
 		}
-
-		note.Records = associations
-		notes = append(notes, note)
+		notes = append(notes, *note)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating note rows: %w", err)
 	}
 
+	if err := r.loadAssociations(notes); err != nil {
+		return nil, err
+	}
+
 	return notes, nil
 }
 
-// GetNoteByID retrieves a single note by ID
-func (r *NoteRepository) GetNoteByID(id uuid.UUID) (*models.Note, error) {
-	query := `SELECT id, content, created_by, created_at, updated_at FROM notes WHERE id = $1`
-
-	var note models.Note
-	var createdBy sql.NullString // Handle NULL values
-
-	err := r.db.QueryRow(query, id).Scan(
-		&note.ID,
-		&note.Content,
-		&createdBy,
-		&note.CreatedAt,
-		&note.UpdatedAt,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No note found
-		}
-		return nil, fmt.Errorf("error querying note by ID: %w", err)
+// loadAssociations fetches the record associations for all given notes in a single query
+func (r *NoteRepository) loadAssociations(notes []models.Note) error {
+	if len(notes) == 0 {
+		return nil
 	}
 
-	// Convert NullString to UUID if valid
-	if createdBy.Valid {
-		parsedID, err := uuid.Parse(createdBy.String)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing user ID: %w", err)
-		}
-		note.CreatedBy = parsedID
+	ids := make([]string, len(notes))
+	index := make(map[uuid.UUID]int, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID.String()
+		index[note.ID] = i
 	}
 
-	// Get note associations
-	associationsQuery := `SELECT record_id, record_type FROM note_associations WHERE note_id = $1`
-	associationRows, err := r.db.Query(associationsQuery, note.ID)
+	associationsQuery := `SELECT note_id, record_id, record_type FROM note_associations WHERE note_id = ANY($1::uuid[])`
+	associationRows, err := r.db.Query(associationsQuery, pq.Array(ids))
 	if err != nil {
-		return nil, fmt.Errorf("error querying note associations: %w", err)
+		return fmt.Errorf("error querying note associations: %w", err)
 	}
 	defer associationRows.Close()
 
-	var associations []models.RecordAssociation
 	for associationRows.Next() {
+		var noteID uuid.UUID
 		var association models.RecordAssociation
-		if err := associationRows.Scan(&association.RecordID, &association.RecordType); err != nil {
-			return nil, fmt.Errorf("error scanning note association row: %w", err)
+		if err := associationRows.Scan(&noteID, &association.RecordID, &association.RecordType); err != nil {
+			return fmt.Errorf("error scanning note association row: %w", err)
 		}
-		associations = append(associations, association)
+		i := index[noteID]
+		notes[i].Records = append(notes[i].Records, association)
 	}
 
 	if err := associationRows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating note association rows: %w", err)
+		return fmt.Errorf("error iterating note association rows: %w", err)
 	}
 
-	note.Records = associations
-	return &note, nil
+	return nil
 }
 
-// GetNotesByRecordID retrieves all notes for a specific record (account, contact, opportunity)
-func (r *NoteRepository) GetNotesByRecordID(recordID uuid.UUID, recordType string) ([]models.Note, error) {
-	query := `
-		SELECT n.id, n.content, n.created_by, n.created_at, n.updated_at 
-		FROM notes n
-		JOIN note_associations na ON n.id = na.note_id
-		WHERE na.record_id = $1 AND na.record_type = $2
-		ORDER BY n.created_at DESC
-	`
-
-	rows, err := r.db.Query(query, recordID, recordType)
+// GetAllNotes retrieves a page of the notes visible to the caller matching the list options, along with its paging metadata
+func (r *NoteRepository) GetAllNotes(opts models.ListOptions, access Access) ([]models.Note, *models.PageInfo, error) {
+	q, err := noteListSpec.build(opts)
 	if err != nil {
-		return nil, fmt.Errorf("error querying notes by record: %w", err)
+		return nil, nil, err
 	}
-	defer rows.Close()
-
-	var notes []models.Note
-	for rows.Next() {
-		var note models.Note
-		var createdBy sql.NullString // Handle NULL values
-
-		if err := rows.Scan(
-			&note.ID,
-			&note.Content,
-			&createdBy,
-			&note.CreatedAt,
-			&note.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning note row: %w", err)
-		}
+	q.restrictTo(access)
 
-		// Convert NullString to UUID if valid
-		if createdBy.Valid {
-			parsedID, err := uuid.Parse(createdBy.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing user ID: %w", err)
-			}
-			note.CreatedBy = parsedID
-		}
+	limit, args := q.limit()
+	notes, err := r.queryNotes(`SELECT `+noteColumns+` FROM notes`+q.where+q.orderBy+limit, args...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Get note associations
-		associationsQuery := `SELECT record_id, record_type FROM note_associations WHERE note_id = $1`
-		associationRows, err := r.db.Query(associationsQuery, note.ID)
-		if err != nil {
-			return nil, fmt.Errorf("error querying note associations: %w", err)
-		}
+	return finishPage(r.db, q, "notes", notes, func(n models.Note) uuid.UUID { return n.ID })
+}
 
-		var associations []models.RecordAssociation
-		for associationRows.Next() {
-			var association models.RecordAssociation
-			if err := associationRows.Scan(&association.RecordID, &association.RecordType); err != nil {
-				associationRows.Close()
-				return nil, fmt.Errorf("error scanning note association row: %w", err)
-			}
-			associations = append(associations, association)
-		}
-		associationRows.Close()
+// ExportNotes passes every note visible to the caller matching the list filters with their associated records to each, in list order,
+// streaming them from the database
+func (r *NoteRepository) ExportNotes(opts models.ListOptions, access Access, each func(*models.Note) error) error {
+	return streamList(r.db, noteListSpec, "notes", noteColumns, scanNote, opts, access, r.loadAssociations, each)
+}
 
-		if err := associationRows.Err(); err != nil {
-			return nil, fmt.Errorf("error iterating note association rows: %w", err)
+// GetNoteByID retrieves a single note by ID if it is visible to the caller
+func (r *NoteRepository) GetNoteByID(id uuid.UUID, access Access) (*models.Note, error) {
+	args := []interface{}{id}
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE id = $1 AND ` + access.visibleCondition(&args)
+	note, err := scanNote(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No note found
 		}
-
-		note.Records = associations
-		notes = append(notes, note)
+		return nil, fmt.Errorf("error querying note by ID: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating note rows: %w", err)
+	notes := []models.Note{*note}
+	if err := r.loadAssociations(notes); err != nil {
+		return nil, err
 	}
 
-	return notes, nil
+	return &notes[0], nil
+}
+
+// GetNotesByRecordID retrieves the notes visible to the caller for a specific record (account, contact, opportunity)
+func (r *NoteRepository) GetNotesByRecordID(recordID uuid.UUID, recordType string, access Access) ([]models.Note, error) {
+	args := []interface{}{recordID, recordType}
+	query := `
+		SELECT n.id, n.content, n.created_by, n.updated_by, n.created_at, n.updated_at, n.owner_id, n.visibility, n.version
+		FROM notes n
+		JOIN note_associations na ON n.id = na.note_id
+		WHERE na.record_id = $1 AND na.record_type = $2 AND ` + access.visibleCondition(&args) + `
+		ORDER BY n.created_at DESC, n.id
+	`
+	return r.queryNotes(query, args...)
 }
 
-// CreateNote creates a new note in the database with associations
-func (r *NoteRepository) CreateNote(data models.NoteCreate) (*models.Note, error) {
+// CreateNote creates a new note created and owned by the acting user in the database with associations
+func (r *NoteRepository) CreateNote(data models.NoteCreate, actor Actor) (*models.Note, error) {
 	// Start a transaction
 	tx, err := r.db.Begin()
 	if err != nil {
@@ -225,27 +201,16 @@ func (r *NoteRepository) CreateNote(data models.NoteCreate) (*models.Note, error
 	defer tx.Rollback()
 
 	// Insert the note
-	noteQuery := `INSERT INTO notes (content, created_by) 
-               VALUES ($1, $2) 
-               RETURNING id, content, created_by, created_at, updated_at`
-
-	var note models.Note
-	var createdByParam interface{} = nil
-	if data.CreatedBy != uuid.Nil {
-		createdByParam = data.CreatedBy
-	}
+	noteQuery := `INSERT INTO notes (content, created_by, updated_by, owner_id, visibility) 
+               VALUES ($1, $2, $2, $2, COALESCE(NULLIF($3, ''), 'public')) 
+               RETURNING ` + noteColumns
 
-	err = tx.QueryRow(
+	note, err := scanNote(tx.QueryRow(
 		noteQuery,
 		data.Content,
-		createdByParam,
-	).Scan(
-		&note.ID,
-		&note.Content,
-		&note.CreatedBy,
-		&note.CreatedAt,
-		&note.UpdatedAt,
-	)
+		actor.UserID,
+		data.Visibility,
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating note: %w", err)
@@ -260,35 +225,58 @@ func (r *NoteRepository) CreateNote(data models.NoteCreate) (*models.Note, error
 		}
 	}
 
+	if err := recordAudit(tx, actor, auditNotes, note.ID, nil); err != nil {
+		return nil, err
+	}
+
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("error committing transaction: %w", err)
 	}
 
 	note.Records = data.Records
-	return &note, nil
+	return note, nil
 }
 
-// UpdateNote updates an existing note in the database
-func (r *NoteRepository) UpdateNote(id uuid.UUID, data models.NoteUpdate) (*models.Note, error) {
+// UpdateNote updates an existing note in the database; only its owner can change it
+func (r *NoteRepository) UpdateNote(id uuid.UUID, data models.NoteUpdate, ifMatch Versions, access Access) (*models.Note, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, "notes", id, ifMatch, access)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // No note found with this ID
+	}
+
+	before, err := auditNotes.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE notes SET 
               content = COALESCE(NULLIF($1, ''), content),
+              visibility = COALESCE(NULLIF($3, ''), visibility),
+              updated_by = $4,
               updated_at = NOW()
               WHERE id = $2
-              RETURNING id, content, created_by, created_at, updated_at`
+              RETURNING ` + noteColumns
 
-	var note models.Note
-	err := r.db.QueryRow(
+	note, err := scanNote(tx.QueryRow(
 		query,
 		data.Content,
 		id,
-	).Scan(
-		&note.ID,
-		&note.Content,
-		&note.CreatedBy,
-		&note.CreatedAt,
-		&note.UpdatedAt,
-	)
+		data.Visibility,
+		access.UserID,
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -297,33 +285,66 @@ func (r *NoteRepository) UpdateNote(id uuid.UUID, data models.NoteUpdate) (*mode
 		return nil, fmt.Errorf("error updating note: %w", err)
 	}
 
-	// Get note associations
-	associationsQuery := `SELECT record_id, record_type FROM note_associations WHERE note_id = $1`
-	associationRows, err := r.db.Query(associationsQuery, note.ID)
-	if err != nil {
-		return nil, fmt.Errorf("error querying note associations: %w", err)
+	if err := recordAudit(tx, access.Actor, auditNotes, id, before); err != nil {
+		return nil, err
 	}
-	defer associationRows.Close()
 
-	var associations []models.RecordAssociation
-	for associationRows.Next() {
-		var association models.RecordAssociation
-		if err := associationRows.Scan(&association.RecordID, &association.RecordType); err != nil {
-			return nil, fmt.Errorf("error scanning note association row: %w", err)
-		}
-		associations = append(associations, association)
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
 	}
 
-	if err := associationRows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating note association rows: %w", err)
+	notes := []models.Note{*note}
+	if err := r.loadAssociations(notes); err != nil {
+		return nil, err
 	}
 
-	note.Records = associations
-	return &note, nil
+	return &notes[0], nil
+}
+
+// PatchNote applies a merge patch to a note; only its owner can change it
+func (r *NoteRepository) PatchNote(id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*models.Note, error) {
+	note, err := patchRecord(r.db, auditNotes, notePatchSpec, noteColumns, scanNote, id, patch, ifMatch, access)
+	if err != nil || note == nil {
+		return note, err
+	}
+
+	notes := []models.Note{*note}
+	if err := r.loadAssociations(notes); err != nil {
+		return nil, err
+	}
+
+	return &notes[0], nil
+}
+
+// DeleteNote moves a note to the trash with its associations; only its owner can delete it
+func (r *NoteRepository) DeleteNote(id uuid.UUID, ifMatch Versions, access Access) error {
+	found, err := moveToTrash(r.db, auditNotes, id, ifMatch, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no note found with ID %s", id)
+	}
+
+	return nil
+}
+
+// RestoreNote takes a note out of the trash; only its owner can restore it
+func (r *NoteRepository) RestoreNote(id uuid.UUID, access Access) error {
+	found, err := restoreFromTrash(r.db, auditNotes, id, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no note found in the trash with ID %s", ErrRecordNotFound, id)
+	}
+
+	return nil
 }
 
-// DeleteNote deletes a note and its associations from the database
-func (r *NoteRepository) DeleteNote(id uuid.UUID) error {
+// AddNoteAssociation adds an association between a note and a record; only the note's owner can add it
+func (r *NoteRepository) AddNoteAssociation(association models.AddNoteAssociation, access Access) error {
 	// Start a transaction
 	tx, err := r.db.Begin()
 	if err != nil {
@@ -333,25 +354,31 @@ func (r *NoteRepository) DeleteNote(id uuid.UUID) error {
 	// Defer a rollback in case anything fails
 	defer tx.Rollback()
 
-	// Delete note associations first (foreign key constraints)
-	_, err = tx.Exec(`DELETE FROM note_associations WHERE note_id = $1`, id)
+	found, err := checkWritable(tx, "notes", association.NoteID, nil, access)
 	if err != nil {
-		return fmt.Errorf("error deleting note associations: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no note found with ID %s", ErrRecordNotFound, association.NoteID)
 	}
 
-	// Delete the note
-	result, err := tx.Exec(`DELETE FROM notes WHERE id = $1`, id)
+	before, err := auditNotes.takeSnapshot(tx, association.NoteID)
 	if err != nil {
-		return fmt.Errorf("error deleting note: %w", err)
+		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	query := `INSERT INTO note_associations (note_id, record_id, record_type) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(query, association.NoteID, association.RecordID, association.RecordType)
 	if err != nil {
-		return fmt.Errorf("error getting rows affected: %w", err)
+		return fmt.Errorf("error adding note association: %w", err)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no note found with ID %s", id)
+	if err := touchNote(tx, association.NoteID, access.UserID); err != nil {
+		return err
+	}
+
+	if err := recordAudit(tx, access.Actor, auditNotes, association.NoteID, before); err != nil {
+		return err
 	}
 
 	// Commit the transaction
@@ -362,20 +389,32 @@ func (r *NoteRepository) DeleteNote(id uuid.UUID) error {
 	return nil
 }
 
-// AddNoteAssociation adds an association between a note and a record
-func (r *NoteRepository) AddNoteAssociation(association models.AddNoteAssociation) error {
-	query := `INSERT INTO note_associations (note_id, record_id, record_type) VALUES ($1, $2, $3)`
-	_, err := r.db.Exec(query, association.NoteID, association.RecordID, association.RecordType)
+// RemoveNoteAssociation removes an association between a note and a record; only the note's owner can remove it
+func (r *NoteRepository) RemoveNoteAssociation(association models.AddNoteAssociation, access Access) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
 	if err != nil {
-		return fmt.Errorf("error adding note association: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, "notes", association.NoteID, nil, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no note found with ID %s", ErrRecordNotFound, association.NoteID)
+	}
+
+	before, err := auditNotes.takeSnapshot(tx, association.NoteID)
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-// RemoveNoteAssociation removes an association between a note and a record
-func (r *NoteRepository) RemoveNoteAssociation(association models.AddNoteAssociation) error {
 	query := `DELETE FROM note_associations WHERE note_id = $1 AND record_id = $2 AND record_type = $3`
-	result, err := r.db.Exec(query, association.NoteID, association.RecordID, association.RecordType)
+	result, err := tx.Exec(query, association.NoteID, association.RecordID, association.RecordType)
 	if err != nil {
 		return fmt.Errorf("error removing note association: %w", err)
 	}
@@ -386,8 +425,43 @@ func (r *NoteRepository) RemoveNoteAssociation(association models.AddNoteAssocia
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no association found for note ID %s and record ID %s", association.NoteID, association.RecordID)
+		return fmt.Errorf("%w: no association found for note ID %s and record ID %s", ErrRecordNotFound, association.NoteID, association.RecordID)
+	}
+
+	if err := touchNote(tx, association.NoteID, access.UserID); err != nil {
+		return err
+	}
+
+	if err := recordAudit(tx, access.Actor, auditNotes, association.NoteID, before); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 
 	return nil
 }
+
+// TransferNote gives a note to another active user; only its owner can transfer it
+func (r *NoteRepository) TransferNote(id, ownerID uuid.UUID, access Access) error {
+	found, err := transferOwnership(r.db, auditNotes, id, ownerID, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no note found with ID %s", ErrRecordNotFound, id)
+	}
+
+	return nil
+}
+
+// touchNote marks a note as updated by the user within a transaction. A note's associations are part of it, so
+// changing them gives the note a new version.
+func touchNote(tx *sql.Tx, id, userID uuid.UUID) error {
+	if _, err := tx.Exec(`UPDATE notes SET updated_by = $2, updated_at = NOW() WHERE id = $1`, id, userID); err != nil {
+		return fmt.Errorf("error updating note: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrOIDCStateInvalid is returned for unknown, expired or already used single sign-on login states
+var ErrOIDCStateInvalid = errors.New("invalid or expired login state")
+
+// OIDCRepository handles database operations for single sign-on identities and pending logins
+type OIDCRepository struct {
+	db *DB
+}
+
+// NewOIDCRepository creates a new OIDC repository
+func NewOIDCRepository(db *DB) *OIDCRepository {
+	return &OIDCRepository{db: db}
+}
+
+// CreateLoginState stores a pending login under the hash of its state parameter, together with its PKCE verifier
+// and nonce. Logins abandoned more than a day ago are cleaned up at the same time.
+func (r *OIDCRepository) CreateLoginState(stateHash, codeVerifier, nonce string, expiresAt time.Time) error {
+	if _, err := r.db.Exec(`DELETE FROM oidc_login_states WHERE expires_at < NOW() - INTERVAL '1 day'`); err != nil {
+		return fmt.Errorf("error deleting expired login states: %w", err)
+	}
+
+	query := `INSERT INTO oidc_login_states (state_hash, code_verifier, nonce, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := r.db.Exec(query, stateHash, codeVerifier, nonce, expiresAt); err != nil {
+		return fmt.Errorf("error creating login state: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeLoginState marks a pending login as used and returns its PKCE verifier and nonce
+func (r *OIDCRepository) ConsumeLoginState(stateHash string) (string, string, error) {
+	query := `UPDATE oidc_login_states SET used_at = NOW()
+              WHERE state_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+              RETURNING code_verifier, nonce`
+
+	var codeVerifier, nonce string
+	if err := r.db.QueryRow(query, stateHash).Scan(&codeVerifier, &nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrOIDCStateInvalid
+		}
+		return "", "", fmt.Errorf("error consuming login state: %w", err)
+	}
+
+	return codeVerifier, nonce, nil
+}
+
+// GetUserByIdentity retrieves the user linked to an account at the provider
+func (r *OIDCRepository) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+              WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)`
+	user, err := scanUser(r.db.QueryRow(query, issuer, subject))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No linked user
+		}
+		return nil, fmt.Errorf("error querying user by identity: %w", err)
+	}
+
+	return user, nil
+}
+
+// LinkIdentity links an existing user to an account at the provider
+func (r *OIDCRepository) LinkIdentity(userID uuid.UUID, issuer, subject, email string) error {
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())`
+	if _, err := r.db.Exec(query, userID, issuer, subject, email); err != nil {
+		return fmt.Errorf("error linking identity: %w", err)
+	}
+	return nil
+}
+
+// RecordIdentityLogin records a login through the provider and the email address it reported
+func (r *OIDCRepository) RecordIdentityLogin(issuer, subject, email string) error {
+	query := `UPDATE user_identities SET last_login_at = NOW(), email = $3 WHERE issuer = $1 AND subject = $2`
+	if _, err := r.db.Exec(query, issuer, subject, email); err != nil {
+		return fmt.Errorf("error recording identity login: %w", err)
+	}
+	return nil
+}
+
+// ProvisionUser creates a user for a first login through the provider and links the identity to it.
+// The user gets a random password, so they can only sign in through the provider until they reset it.
+func (r *OIDCRepository) ProvisionUser(username, email, role string, emailVerified bool, issuer, subject string, actor Actor) (*models.User, error) {
+	randomPassword := make([]byte, 32)
+	if _, err := rand.Read(randomPassword); err != nil {
+		return nil, fmt.Errorf("error generating password: %w", err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword(randomPassword, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (username, email, password_hash, role, email_verified_at)
+              VALUES ($1, $2, $3, $4, CASE WHEN $5 THEN NOW() END)
+              RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRow(query, username, email, string(hashedPassword), role, emailVerified))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateUser
+		}
+		if isForeignKeyViolation(err) {
+			return nil, ErrUnknownRole
+		}
+		return nil, fmt.Errorf("error creating user: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())`,
+		user.ID, issuer, subject, email)
+	if err != nil {
+		return nil, fmt.Errorf("error linking identity: %w", err)
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, user.ID, nil); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return user, nil
+}
@@ -19,123 +19,90 @@ func NewOpportunityRepository(db *DB) *OpportunityRepository {
 	return &OpportunityRepository{db: db}
 }
 
-// GetAllOpportunities retrieves all opportunities from the database
-func (r *OpportunityRepository) GetAllOpportunities() ([]models.Opportunity, error) {
-	query := `SELECT id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at 
-              FROM opportunities ORDER BY close_date, opportunity_name`
-	rows, err := r.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("error querying opportunities: %w", err)
-	}
-	defer rows.Close()
-
-	var opportunities []models.Opportunity
-	for rows.Next() {
-		var opportunity models.Opportunity
-		var accountID, primaryContactID sql.NullString // Handle NULL values
-		var closeDate sql.NullTime
-		var amount, probability sql.NullFloat64
-
-		if err := rows.Scan(
-			&opportunity.ID,
-			&opportunity.OpportunityName,
-			&accountID,
-			&primaryContactID,
-			&opportunity.Stage,
-			&amount,
-			&closeDate,
-			&probability,
-			&opportunity.CreatedAt,
-			&opportunity.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning opportunity row: %w", err)
-		}
-
-		// Convert NullString to UUID if valid
-		if accountID.Valid {
-			parsedID, err := uuid.Parse(accountID.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing account ID: %w", err)
-			}
-			opportunity.AccountID = parsedID
-		}
-
-		if primaryContactID.Valid {
-			parsedID, err := uuid.Parse(primaryContactID.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing primary contact ID: %w", err)
-			}
-			opportunity.PrimaryContactID = parsedID
-		}
-
-		// Handle nullable fields
-		if amount.Valid {
-			opportunity.Amount = amount.Float64
-		}
-
-		if closeDate.Valid {
-			opportunity.CloseDate = &closeDate.Time
-		}
-
-		if probability.Valid {
-			opportunity.Probability = probability.Float64
-		}
-
-		opportunities = append(opportunities, opportunity)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating opportunity rows: %w", err)
-	}
+// opportunityColumns is the column list selected for every opportunity query
+const opportunityColumns = `id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at, created_by, updated_by, owner_id, visibility, version, custom_fields`
+
+// opportunityListSpec defines the sortable and filterable opportunity fields
+var opportunityListSpec = listSpec{
+	sortColumns: map[string]string{
+		"opportunity_name": "opportunity_name",
+		"stage":            "stage",
+		"amount":           "COALESCE(amount, 0)",
+		"close_date":       "COALESCE(close_date, 'infinity'::date)",
+		"probability":      "COALESCE(probability, 0)",
+		"created_at":       "created_at",
+		"updated_at":       "updated_at",
+	},
+	defaultSort: "close_date,opportunity_name",
+	filters: map[string]listFilter{
+		"q":                  {condition: "search_vector @@ websearch_to_tsquery('english', %s)", kind: filterString},
+		"opportunity_name":   {condition: "opportunity_name ILIKE '%%' || %s || '%%'", kind: filterString},
+		"stage":              {condition: "stage = %s", kind: filterString},
+		"account_id":         {condition: "account_id = %s", kind: filterUUID},
+		"primary_contact_id": {condition: "primary_contact_id = %s", kind: filterUUID},
+		"min_amount":         {condition: "amount >= %s", kind: filterNumber},
+		"max_amount":         {condition: "amount <= %s", kind: filterNumber},
+		"close_after":        {condition: "close_date >= %s", kind: filterTime},
+		"close_before":       {condition: "close_date < %s", kind: filterTime},
+		"created_by":         {condition: "created_by = %s", kind: filterUUID},
+		"updated_by":         {condition: "updated_by = %s", kind: filterUUID},
+		"owner_id":           {condition: "owner_id = %s", kind: filterUUID},
+		"visibility":         {condition: "visibility = %s", kind: filterString},
+		"created_after":      {condition: "created_at >= %s", kind: filterTime},
+		"created_before":     {condition: "created_at < %s", kind: filterTime},
+		"updated_after":      {condition: "updated_at >= %s", kind: filterTime},
+	},
+}
 
-	return opportunities, nil
+// opportunityPatchSpec defines the opportunity fields a merge patch may change
+var opportunityPatchSpec = patchSpec{
+	"opportunity_name":   {column: "opportunity_name", kind: patchString, required: true},
+	"account_id":         {column: "account_id", kind: patchUUID},
+	"primary_contact_id": {column: "primary_contact_id", kind: patchUUID},
+	"stage":              {column: "stage", kind: patchString, required: true},
+	"amount":             {column: "amount", kind: patchNumber},
+	"close_date":         {column: "close_date", kind: patchDate},
+	"probability":        {column: "probability", kind: patchNumber},
+	"visibility":         visibilityPatchField,
+	"custom_fields":      customFieldsPatchField,
 }
 
-// GetOpportunityByID retrieves a single opportunity by ID
-func (r *OpportunityRepository) GetOpportunityByID(id uuid.UUID) (*models.Opportunity, error) {
-	query := `SELECT id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at 
-              FROM opportunities WHERE id = $1`
+// opportunityBulk applies bulk opportunity operations
+var opportunityBulk = bulkEntity[models.OpportunityCreate, models.Opportunity]{
+	entity:  auditOpportunities,
+	insert:  insertOpportunity,
+	id:      func(o *models.Opportunity) uuid.UUID { return o.ID },
+	patch:   opportunityPatchSpec,
+	columns: opportunityColumns,
+	scan:    scanOpportunity,
+}
 
+// scanOpportunity scans a row selected with opportunityColumns into an opportunity
+func scanOpportunity(row rowScanner) (*models.Opportunity, error) {
 	var opportunity models.Opportunity
-	var accountID, primaryContactID sql.NullString // Handle NULL values
 	var closeDate sql.NullTime
 	var amount, probability sql.NullFloat64
+	var customFields []byte
 
-	err := r.db.QueryRow(query, id).Scan(
+	if err := row.Scan(
 		&opportunity.ID,
 		&opportunity.OpportunityName,
-		&accountID,
-		&primaryContactID,
+		&opportunity.AccountID,        // NULL scans as uuid.Nil
+		&opportunity.PrimaryContactID, // NULL scans as uuid.Nil
 		&opportunity.Stage,
 		&amount,
 		&closeDate,
 		&probability,
 		&opportunity.CreatedAt,
 		&opportunity.UpdatedAt,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No opportunity found
-		}
-		return nil, fmt.Errorf("error querying opportunity by ID: %w", err)
-	}
-
-	// Convert NullString to UUID if valid
-	if accountID.Valid {
-		parsedID, err := uuid.Parse(accountID.String)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing account ID: %w", err)
-		}
-		opportunity.AccountID = parsedID
-	}
-
-	if primaryContactID.Valid {
-		parsedID, err := uuid.Parse(primaryContactID.String)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing primary contact ID: %w", err)
-		}
-		opportunity.PrimaryContactID = parsedID
+		&opportunity.CreatedBy,
+		&opportunity.UpdatedBy, // NULL scans as uuid.Nil
+		&opportunity.OwnerID,
+		&opportunity.Visibility,
+		&opportunity.Version,
+		&customFields,
+	); err != nil {
+		return nil, err
 	}
 
 	// Handle nullable fields
@@ -151,89 +118,136 @@ func (r *OpportunityRepository) GetOpportunityByID(id uuid.UUID) (*models.Opport
 		opportunity.Probability = probability.Float64
 	}
 
+	if err := decodeCustomFields(customFields, &opportunity.CustomFields); err != nil {
+		return nil, err
+	}
+
 	return &opportunity, nil
 }
 
-// GetOpportunitiesByAccountID retrieves all opportunities for a specific account
-func (r *OpportunityRepository) GetOpportunitiesByAccountID(accountID uuid.UUID) ([]models.Opportunity, error) {
-	query := `SELECT id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at 
-              FROM opportunities WHERE account_id = $1 ORDER BY close_date, opportunity_name`
-	rows, err := r.db.Query(query, accountID)
+// queryOpportunities runs a query selecting opportunityColumns and scans every row
+func (r *OpportunityRepository) queryOpportunities(query string, args ...interface{}) ([]models.Opportunity, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying opportunities by account ID: %w", err)
+		return nil, fmt.Errorf("error querying opportunities: %w", err)
 	}
 	defer rows.Close()
 
 	var opportunities []models.Opportunity
 	for rows.Next() {
-		var opportunity models.Opportunity
-		var primaryContactID sql.NullString // Handle NULL values
-		var closeDate sql.NullTime
-		var amount, probability sql.NullFloat64
-
-		if err := rows.Scan(
-			&opportunity.ID,
-			&opportunity.OpportunityName,
-			&opportunity.AccountID,
-			&primaryContactID,
-			&opportunity.Stage,
-			&amount,
-			&closeDate,
-			&probability,
-			&opportunity.CreatedAt,
-			&opportunity.UpdatedAt,
-		); err != nil {
+		opportunity, err := scanOpportunity(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning opportunity row: %w", err)
 		}
+		opportunities = append(opportunities, *opportunity)
+	}
 
-		// Convert NullString to UUID if valid
-		if primaryContactID.Valid {
-			parsedID, err := uuid.Parse(primaryContactID.String)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing primary contact ID: %w", err)
-			}
-			opportunity.PrimaryContactID = parsedID
-		}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating opportunity rows: %w", err)
+	}
 
-		// Handle nullable fields
-		if amount.Valid {
-			opportunity.Amount = amount.Float64
-		}
+	return opportunities, nil
+}
 
-		if closeDate.Valid {
-			opportunity.CloseDate = &closeDate.Time
-		}
+// GetAllOpportunities retrieves a page of the opportunities visible to the caller matching the list options, along with its paging metadata
+func (r *OpportunityRepository) GetAllOpportunities(opts models.ListOptions, access Access) ([]models.Opportunity, *models.PageInfo, error) {
+	spec, err := opportunityListSpec.withCustomFields(r.db, "opportunity", opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q, err := spec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.restrictTo(access)
+
+	limit, args := q.limit()
+	opportunities, err := r.queryOpportunities(`SELECT `+opportunityColumns+` FROM opportunities`+q.where+q.orderBy+limit, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return finishPage(r.db, q, "opportunities", opportunities, func(o models.Opportunity) uuid.UUID { return o.ID })
+}
 
-		if probability.Valid {
-			opportunity.Probability = probability.Float64
+// ExportOpportunities passes every opportunity visible to the caller matching the list filters to each, in list order,
+// streaming them from the database
+func (r *OpportunityRepository) ExportOpportunities(opts models.ListOptions, access Access, each func(*models.Opportunity) error) error {
+	spec, err := opportunityListSpec.withCustomFields(r.db, "opportunity", opts)
+	if err != nil {
+		return err
+	}
+
+	return streamList(r.db, spec, "opportunities", opportunityColumns, scanOpportunity, opts, access, nil, each)
+}
+
+// GetOpportunityByID retrieves a single opportunity by ID if it is visible to the caller
+func (r *OpportunityRepository) GetOpportunityByID(id uuid.UUID, access Access) (*models.Opportunity, error) {
+	args := []interface{}{id}
+	query := `SELECT ` + opportunityColumns + ` FROM opportunities WHERE id = $1 AND ` + access.visibleCondition(&args)
+	opportunity, err := scanOpportunity(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No opportunity found
 		}
+		return nil, fmt.Errorf("error querying opportunity by ID: %w", err)
+	}
+
+	return opportunity, nil
+}
+
+// GetOpportunitiesByAccountID retrieves the opportunities of a specific account that are visible to the caller
+func (r *OpportunityRepository) GetOpportunitiesByAccountID(accountID uuid.UUID, access Access) ([]models.Opportunity, error) {
+	args := []interface{}{accountID}
+	query := `SELECT ` + opportunityColumns + ` FROM opportunities WHERE account_id = $1 AND ` + access.visibleCondition(&args) +
+		` ORDER BY close_date, opportunity_name, id`
+	return r.queryOpportunities(query, args...)
+}
 
-		opportunities = append(opportunities, opportunity)
+// CreateOpportunity creates a new opportunity created and owned by the acting user; it is public unless another visibility is given
+func (r *OpportunityRepository) CreateOpportunity(data models.OpportunityCreate, access Access) (*models.Opportunity, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating opportunity rows: %w", err)
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	opportunity, err := insertOpportunity(tx, data, access)
+	if err != nil {
+		return nil, err
 	}
 
-	return opportunities, nil
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return opportunity, nil
 }
 
-// CreateOpportunity creates a new opportunity in the database
-func (r *OpportunityRepository) CreateOpportunity(data models.OpportunityCreate) (*models.Opportunity, error) {
-	query := `INSERT INTO opportunities (opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7) 
-              RETURNING id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at`
+// insertOpportunity creates an opportunity within a transaction and records it in the audit log
+func insertOpportunity(tx *sql.Tx, data models.OpportunityCreate, access Access) (*models.Opportunity, error) {
+	query := `INSERT INTO opportunities (opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_by, updated_by, owner_id, visibility, custom_fields) 
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $8, COALESCE(NULLIF($9, ''), 'public'), COALESCE($10::jsonb, '{}')) 
+              RETURNING ` + opportunityColumns
+
+	if err := checkParents(tx, auditOpportunities, uuid.Nil, map[string]uuid.UUID{"account_id": data.AccountID, "primary_contact_id": data.PrimaryContactID}, access); err != nil {
+		return nil, err
+	}
 
 	var closeDate *time.Time
 	if data.CloseDate != "" {
 		t, err := time.Parse("2006-01-02", data.CloseDate)
 		if err != nil {
-			return nil, fmt.Errorf("invalid close date format (should be YYYY-MM-DD): %w", err)
+			return nil, fmt.Errorf("%w: invalid close date format (should be YYYY-MM-DD): %v", ErrInvalidRecord, err)
 		}
 		closeDate = &t
 	}
 
-	var opportunity models.Opportunity
 	var primaryContactID interface{} = nil
 	if data.PrimaryContactID != uuid.Nil {
 		primaryContactID = data.PrimaryContactID
@@ -252,7 +266,12 @@ func (r *OpportunityRepository) CreateOpportunity(data models.OpportunityCreate)
 		probability = data.Probability
 	}
 
-	err := r.db.QueryRow(
+	customFields, err := encodeCustomFields(data.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
+	opportunity, err := scanOpportunity(tx.QueryRow(
 		query,
 		data.OpportunityName,
 		data.AccountID,
@@ -261,28 +280,27 @@ func (r *OpportunityRepository) CreateOpportunity(data models.OpportunityCreate)
 		amount,
 		closeDateParam,
 		probability,
-	).Scan(
-		&opportunity.ID,
-		&opportunity.OpportunityName,
-		&opportunity.AccountID,
-		&opportunity.PrimaryContactID,
-		&opportunity.Stage,
-		&opportunity.Amount,
-		&opportunity.CloseDate,
-		&opportunity.Probability,
-		&opportunity.CreatedAt,
-		&opportunity.UpdatedAt,
-	)
+		access.UserID,
+		data.Visibility,
+		customFields,
+	))
 
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, fmt.Errorf("%w: a referenced record does not exist", ErrInvalidRecord)
+		}
 		return nil, fmt.Errorf("error creating opportunity: %w", err)
 	}
 
-	return &opportunity, nil
+	if err := recordAudit(tx, access.Actor, auditOpportunities, opportunity.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return opportunity, nil
 }
 
-// UpdateOpportunity updates an existing opportunity in the database
-func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.OpportunityUpdate) (*models.Opportunity, error) {
+// UpdateOpportunity updates an existing opportunity in the database; only its owner can change it
+func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.OpportunityUpdate, ifMatch Versions, access Access) (*models.Opportunity, error) {
 	// Parse close date if provided
 	var closeDate *time.Time
 	if data.CloseDate != "" {
@@ -293,6 +311,32 @@ func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.Oppo
 		closeDate = &t
 	}
 
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, "opportunities", id, ifMatch, access)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // No opportunity found with this ID
+	}
+
+	if err := checkParents(tx, auditOpportunities, id, map[string]uuid.UUID{"account_id": data.AccountID, "primary_contact_id": data.PrimaryContactID}, access); err != nil {
+		return nil, err
+	}
+
+	before, err := auditOpportunities.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE opportunities SET 
               opportunity_name = COALESCE(NULLIF($1, ''), opportunity_name),
               account_id = $2,
@@ -301,11 +345,13 @@ func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.Oppo
               amount = $5,
               close_date = $6,
               probability = $7,
+              visibility = COALESCE(NULLIF($9, ''), visibility),
+              custom_fields = jsonb_strip_nulls(custom_fields || COALESCE($11::jsonb, '{}')),
+              updated_by = $10,
               updated_at = NOW()
               WHERE id = $8
-              RETURNING id, opportunity_name, account_id, primary_contact_id, stage, amount, close_date, probability, created_at, updated_at`
+              RETURNING ` + opportunityColumns
 
-	var opportunity models.Opportunity
 	var primaryContactID interface{} = nil
 	var accountID interface{} = nil
 
@@ -330,7 +376,12 @@ func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.Oppo
 		probability = data.Probability
 	}
 
-	err := r.db.QueryRow(
+	customFields, err := encodeCustomFields(data.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
+	opportunity, err := scanOpportunity(tx.QueryRow(
 		query,
 		data.OpportunityName,
 		accountID,
@@ -340,18 +391,10 @@ func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.Oppo
 		closeDateParam,
 		probability,
 		id,
-	).Scan(
-		&opportunity.ID,
-		&opportunity.OpportunityName,
-		&opportunity.AccountID,
-		&opportunity.PrimaryContactID,
-		&opportunity.Stage,
-		&opportunity.Amount,
-		&opportunity.CloseDate,
-		&opportunity.Probability,
-		&opportunity.CreatedAt,
-		&opportunity.UpdatedAt,
-	)
+		data.Visibility,
+		access.UserID,
+		customFields,
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -360,24 +403,62 @@ func (r *OpportunityRepository) UpdateOpportunity(id uuid.UUID, data models.Oppo
 		return nil, fmt.Errorf("error updating opportunity: %w", err)
 	}
 
-	return &opportunity, nil
+	if err := recordAudit(tx, access.Actor, auditOpportunities, id, before); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return opportunity, nil
 }
 
-// DeleteOpportunity deletes an opportunity from the database
-func (r *OpportunityRepository) DeleteOpportunity(id uuid.UUID) error {
-	query := `DELETE FROM opportunities WHERE id = $1`
-	result, err := r.db.Exec(query, id)
+// PatchOpportunity applies a merge patch to an opportunity; only its owner can change it
+func (r *OpportunityRepository) PatchOpportunity(id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*models.Opportunity, error) {
+	return patchRecord(r.db, auditOpportunities, opportunityPatchSpec, opportunityColumns, scanOpportunity, id, patch, ifMatch, access)
+}
+
+// BulkOpportunities creates, updates and deletes opportunities in one request; see bulkEntity.run
+func (r *OpportunityRepository) BulkOpportunities(ops []BulkOperation[models.OpportunityCreate], atomic bool, access Access) []BulkResult[models.Opportunity] {
+	return opportunityBulk.run(r.db, ops, atomic, access)
+}
+
+// DeleteOpportunity moves an opportunity to the trash; only its owner can delete it
+func (r *OpportunityRepository) DeleteOpportunity(id uuid.UUID, ifMatch Versions, access Access) error {
+	found, err := moveToTrash(r.db, auditOpportunities, id, ifMatch, access)
 	if err != nil {
-		return fmt.Errorf("error deleting opportunity: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no opportunity found with ID %s", id)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return nil
+}
+
+// RestoreOpportunity takes an opportunity out of the trash unless its account is still there; only its owner can restore it
+func (r *OpportunityRepository) RestoreOpportunity(id uuid.UUID, access Access) error {
+	found, err := restoreFromTrash(r.db, auditOpportunities, id, access)
 	if err != nil {
-		return fmt.Errorf("error getting rows affected: %w", err)
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no opportunity found in the trash with ID %s", ErrRecordNotFound, id)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no opportunity found with ID %s", id)
+	return nil
+}
+
+// TransferOpportunity gives an opportunity to another active user; only its owner can transfer it
+func (r *OpportunityRepository) TransferOpportunity(id, ownerID uuid.UUID, access Access) error {
+	found, err := transferOwnership(r.db, auditOpportunities, id, ownerID, access)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: no opportunity found with ID %s", ErrRecordNotFound, id)
 	}
 
 	return nil
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotRecordOwner is returned when a caller who can see a record tries to change it without owning it
+	ErrNotRecordOwner = errors.New("only the record owner can change this record")
+	// ErrInvalidOwner is returned when a record is transferred to a user that does not exist or is deactivated
+	ErrInvalidOwner = errors.New("new owner must be an active user")
+)
+
+// Access identifies the caller a record query runs for. Callers see the records they own, public records
+// and team records owned by a member of one of their teams, and can only change the records they own.
+// AllRecords bypasses these rules for admins; records in the trash stay hidden from everyone but the trash list.
+type Access struct {
+	Actor
+	AllRecords bool
+}
+
+// queryRower is implemented by both *DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// visibleCondition returns the SQL condition matching the records the caller may see, binding the caller's
+// user ID to args when needed. Records in the trash are excluded. The condition uses the unqualified
+// owner_id, visibility and deleted_at columns.
+func (a Access) visibleCondition(args *[]interface{}) string {
+	return "(deleted_at IS NULL AND " + a.sharedCondition(args) + ")"
+}
+
+// trashedCondition returns the SQL condition matching the records in the trash the caller may see
+func (a Access) trashedCondition(args *[]interface{}) string {
+	return "(deleted_at IS NOT NULL AND " + a.sharedCondition(args) + ")"
+}
+
+// sharedCondition returns the SQL condition matching the records shared with the caller, in or out of the trash
+func (a Access) sharedCondition(args *[]interface{}) string {
+	if a.AllRecords {
+		return "TRUE"
+	}
+
+	*args = append(*args, a.UserID)
+	return fmt.Sprintf(`(owner_id = $%[1]d OR visibility = 'public' OR (visibility = 'team' AND owner_id IN (
+                SELECT teammate.user_id FROM team_members teammate
+                JOIN team_members caller ON caller.team_id = teammate.team_id
+                WHERE caller.user_id = $%[1]d)))`, len(*args))
+}
+
+// ownedCondition returns the SQL condition matching the records the caller may change
+func (a Access) ownedCondition(args *[]interface{}) string {
+	if a.AllRecords {
+		return "TRUE"
+	}
+
+	*args = append(*args, a.UserID)
+	return fmt.Sprintf("owner_id = $%d", len(*args))
+}
+
+// restrictTo limits a list query to the records the caller may see
+func (q *listQuery) restrictTo(access Access) {
+	q.addCondition(access.visibleCondition(&q.args))
+}
+
+// checkWritable reports whether a record exists and is visible to the caller, locking it for the change.
+// It returns ErrNotRecordOwner when the caller can see the record but may not change it, and ErrVersionConflict
+// when the change is conditional on other versions of it.
+func checkWritable(q queryRower, table string, id uuid.UUID, ifMatch Versions, access Access) (bool, error) {
+	return checkOwnership(q, table, id, ifMatch, access, access.visibleCondition)
+}
+
+// checkRestorable reports whether a record is in the trash and visible to the caller.
+// It returns ErrNotRecordOwner when the caller can see the record but may not restore it.
+func checkRestorable(q queryRower, table string, id uuid.UUID, access Access) (bool, error) {
+	return checkOwnership(q, table, id, nil, access, access.trashedCondition)
+}
+
+// checkOwnership reports whether a record matching the visibility condition exists, whether the caller owns it
+// and whether it is at one of the versions the change is conditional on
+func checkOwnership(q queryRower, table string, id uuid.UUID, ifMatch Versions, access Access, visibleCondition func(*[]interface{}) string) (bool, error) {
+	args := []interface{}{id}
+	visible := visibleCondition(&args)
+	owned := access.ownedCondition(&args)
+
+	var isVisible, isOwned bool
+	var version int64
+	query := fmt.Sprintf(`SELECT %s, %s, version FROM %s WHERE id = $1 FOR UPDATE`, visible, owned, table)
+	if err := q.QueryRow(query, args...).Scan(&isVisible, &isOwned, &version); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil // No record found with this ID
+		}
+		return false, fmt.Errorf("error checking %s ownership: %w", table, err)
+	}
+
+	if !isVisible {
+		return false, nil
+	}
+
+	if !isOwned {
+		return true, ErrNotRecordOwner
+	}
+
+	if err := ifMatch.check(version); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// transferOwnership gives a record the caller may change to another active user and records it in the audit log.
+// It reports false when the record does not exist or is not visible to the caller.
+func transferOwnership(db *DB, entity auditEntity, id, ownerID uuid.UUID, access Access) (bool, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkWritable(tx, entity.table, id, nil, access)
+	if err != nil || !found {
+		return found, err
+	}
+
+	before, err := entity.takeSnapshot(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET owner_id = $2, updated_by = $3, updated_at = NOW()
+              WHERE id = $1 AND EXISTS(SELECT 1 FROM users WHERE id = $2 AND is_active)`, entity.table)
+	result, err := tx.Exec(query, id, ownerID, access.UserID)
+	if err != nil {
+		return false, fmt.Errorf("error transferring %s: %w", entity.table, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return true, ErrInvalidOwner
+	}
+
+	if err := recordAudit(tx, access.Actor, entity, id, before); err != nil {
+		return false, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
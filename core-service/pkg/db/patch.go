@@ -0,0 +1,226 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrInvalidPatch is returned when a merge patch names a field that cannot be changed,
+// or gives a field a value it cannot take
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// patchKind describes how a patched value is parsed before being bound to the query
+type patchKind int
+
+const (
+	patchString patchKind = iota
+	patchUUID
+	patchNumber
+	patchDate
+	patchObject // A JSON object merged into the column's, where null removes a key
+)
+
+// patchField maps a merge patch field onto a column
+type patchField struct {
+	column   string
+	kind     patchKind
+	required bool     // The column cannot be cleared or set to an empty string
+	values   []string // The only values the field may take, when set
+}
+
+// patchSpec describes which fields of an entity a merge patch may change
+type patchSpec map[string]patchField
+
+// build returns the SET assignments for a merge patch, binding their values after the existing arguments.
+// Fields are assigned in a stable order so identical patches produce identical SQL.
+func (s patchSpec) build(patch models.MergePatch, args *[]interface{}) ([]string, error) {
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var assignments []string
+	for _, name := range names {
+		field, ok := s[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q cannot be changed", ErrInvalidPatch, name)
+		}
+
+		value, err := field.parse(patch[name])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value for field %q: %v", ErrInvalidPatch, name, err)
+		}
+
+		*args = append(*args, value)
+		if field.kind == patchObject {
+			assignments = append(assignments, fmt.Sprintf("%[1]s = jsonb_strip_nulls(%[1]s || $%[2]d::jsonb)", field.column, len(*args)))
+			continue
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", field.column, len(*args)))
+	}
+
+	return assignments, nil
+}
+
+// links returns the UUID columns a valid merge patch sets, mapped to their new values; null maps to uuid.Nil
+func (s patchSpec) links(patch models.MergePatch) map[string]uuid.UUID {
+	links := map[string]uuid.UUID{}
+	for name, raw := range patch {
+		field := s[name]
+		if field.kind != patchUUID {
+			continue
+		}
+		value, _ := field.parse(raw) // Already checked by build
+		id, _ := value.(uuid.UUID)
+		links[field.column] = id
+	}
+	return links
+}
+
+// parse converts a patched JSON value into the type expected by the database; null clears the column
+func (f patchField) parse(raw json.RawMessage) (interface{}, error) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		if f.required {
+			return nil, errors.New("it cannot be cleared")
+		}
+		return nil, nil
+	}
+
+	if f.kind == patchObject {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, errors.New("must be an object")
+		}
+		return string(raw), nil
+	}
+
+	if f.kind == patchNumber {
+		var number float64
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return nil, errors.New("must be a number or null")
+		}
+		return number, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return nil, errors.New("must be a string or null")
+	}
+
+	if text == "" && f.required {
+		return nil, errors.New("it cannot be empty")
+	}
+
+	if f.values != nil && !slices.Contains(f.values, text) {
+		return nil, fmt.Errorf("must be one of %s", strings.Join(f.values, ", "))
+	}
+
+	switch f.kind {
+	case patchUUID:
+		return uuid.Parse(text)
+	case patchDate:
+		date, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, errors.New("must be a date (YYYY-MM-DD) or null")
+		}
+		return date, nil
+	default:
+		return text, nil
+	}
+}
+
+// visibilityPatchField lets a merge patch change a record's visibility
+var visibilityPatchField = patchField{column: "visibility", kind: patchString, required: true, values: []string{"private", "team", "public"}}
+
+// customFieldsPatchField lets a merge patch change a record's custom field values, which the handlers validate
+var customFieldsPatchField = patchField{column: "custom_fields", kind: patchObject, required: true}
+
+// patchRecord applies a merge patch to a record the caller may change and records it in the audit log, returning
+// the record's row selected with columns. It returns nil when the record does not exist or is not visible to the caller.
+func patchRecord[T any](db *DB, entity auditEntity, spec patchSpec, columns string, scan func(rowScanner) (*T, error),
+	id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*T, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	record, err := applyPatch(tx, entity, spec, columns, scan, id, patch, ifMatch, access)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return record, nil
+}
+
+// applyPatch applies a merge patch to a record within a transaction; see patchRecord
+func applyPatch[T any](tx *sql.Tx, entity auditEntity, spec patchSpec, columns string, scan func(rowScanner) (*T, error),
+	id uuid.UUID, patch models.MergePatch, ifMatch Versions, access Access) (*T, error) {
+	args := []interface{}{id}
+	assignments, err := spec.build(patch, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := checkWritable(tx, entity.table, id, ifMatch, access)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // No record found with this ID
+	}
+
+	if err := checkParents(tx, entity, id, spec.links(patch), access); err != nil {
+		return nil, err
+	}
+
+	// An empty patch changes nothing
+	if len(assignments) == 0 {
+		record, err := scan(tx.QueryRow(fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`, columns, entity.table), id))
+		if err != nil {
+			return nil, fmt.Errorf("error querying %s: %w", entity.table, err)
+		}
+		return record, nil
+	}
+
+	before, err := entity.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, access.UserID)
+	assignments = append(assignments, fmt.Sprintf("updated_by = $%d", len(args)), "updated_at = NOW()")
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = $1 RETURNING %s`, entity.table, strings.Join(assignments, ", "), columns)
+	record, err := scan(tx.QueryRow(query, args...))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, fmt.Errorf("%w: a referenced record does not exist", ErrInvalidPatch)
+		}
+		return nil, fmt.Errorf("error patching %s: %w", entity.table, err)
+	}
+
+	if err := recordAudit(tx, access.Actor, entity, id, before); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
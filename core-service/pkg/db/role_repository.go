@@ -0,0 +1,231 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateRole is returned when a role name is already taken
+	ErrDuplicateRole = errors.New("role already exists")
+	// ErrBuiltinRole is returned when changing or deleting a built-in role
+	ErrBuiltinRole = errors.New("built-in roles cannot be changed")
+	// ErrRoleInUse is returned when deleting a role that is still assigned to users
+	ErrRoleInUse = errors.New("role is assigned to users")
+)
+
+// RoleRepository handles database operations for roles and their permissions
+type RoleRepository struct {
+	db *DB
+}
+
+// NewRoleRepository creates a new role repository
+func NewRoleRepository(db *DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+// roleColumns is the column list selected for every role query
+const roleColumns = `name, COALESCE(description, ''),
+                     ARRAY(SELECT permission FROM role_permissions WHERE role_name = roles.name ORDER BY permission),
+                     is_builtin, (SELECT COUNT(*) FROM users WHERE users.role = roles.name), created_at, updated_at`
+
+// scanRole scans a row selected with roleColumns into a role
+func scanRole(row rowScanner) (*models.Role, error) {
+	var role models.Role
+	if err := row.Scan(
+		&role.Name,
+		&role.Description,
+		pq.Array(&role.Permissions),
+		&role.IsBuiltin,
+		&role.UserCount,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	return &role, nil
+}
+
+// GetAllRoles retrieves every role, built-in roles first
+func (r *RoleRepository) GetAllRoles() ([]models.Role, error) {
+	rows, err := r.db.Query(`SELECT ` + roleColumns + ` FROM roles ORDER BY is_builtin DESC, name`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning role row: %w", err)
+		}
+		roles = append(roles, *role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role rows: %w", err)
+	}
+
+	return roles, nil
+}
+
+// GetRole retrieves a role by name
+func (r *RoleRepository) GetRole(name string) (*models.Role, error) {
+	role, err := scanRole(r.db.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = $1`, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No role found with this name
+		}
+		return nil, fmt.Errorf("error querying role: %w", err)
+	}
+
+	return role, nil
+}
+
+// CreateRole creates a custom role with its permissions
+func (r *RoleRepository) CreateRole(data models.RoleCreate) (*models.Role, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO roles (name, description) VALUES ($1, NULLIF($2, ''))`, data.Name, data.Description); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateRole
+		}
+		return nil, fmt.Errorf("error creating role: %w", err)
+	}
+
+	if err := replaceRolePermissions(tx, data.Name, data.Permissions); err != nil {
+		return nil, err
+	}
+
+	role, err := scanRole(tx.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = $1`, data.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error querying role: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return role, nil
+}
+
+// UpdateRole updates a custom role's description and, when given, replaces its permissions
+func (r *RoleRepository) UpdateRole(name string, data models.RoleUpdate) (*models.Role, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	var isBuiltin bool
+	if err := tx.QueryRow(`SELECT is_builtin FROM roles WHERE name = $1 FOR UPDATE`, name).Scan(&isBuiltin); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No role found with this name
+		}
+		return nil, fmt.Errorf("error querying role: %w", err)
+	}
+
+	if isBuiltin {
+		return nil, ErrBuiltinRole
+	}
+
+	query := `UPDATE roles SET description = CASE WHEN $2 THEN NULLIF($3, '') ELSE description END, updated_at = NOW()
+              WHERE name = $1`
+	var description string
+	if data.Description != nil {
+		description = *data.Description
+	}
+	if _, err := tx.Exec(query, name, data.Description != nil, description); err != nil {
+		return nil, fmt.Errorf("error updating role: %w", err)
+	}
+
+	if data.Permissions != nil {
+		if err := replaceRolePermissions(tx, name, data.Permissions); err != nil {
+			return nil, err
+		}
+	}
+
+	role, err := scanRole(tx.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = $1`, name))
+	if err != nil {
+		return nil, fmt.Errorf("error querying role: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return role, nil
+}
+
+// DeleteRole deletes a custom role that is no longer assigned to any user; it returns ErrRecordNotFound when there is
+// no role with the name
+func (r *RoleRepository) DeleteRole(name string) error {
+	var isBuiltin bool
+	if err := r.db.QueryRow(`SELECT is_builtin FROM roles WHERE name = $1`, name).Scan(&isBuiltin); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: no role found with name %s", ErrRecordNotFound, name)
+		}
+		return fmt.Errorf("error querying role: %w", err)
+	}
+
+	if isBuiltin {
+		return ErrBuiltinRole
+	}
+
+	result, err := r.db.Exec(`DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrRoleInUse
+		}
+		return fmt.Errorf("error deleting role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: no role found with name %s", ErrRecordNotFound, name)
+	}
+
+	return nil
+}
+
+// replaceRolePermissions replaces the permissions granted by a role within a transaction
+func replaceRolePermissions(tx *sql.Tx, name string, permissions []string) error {
+	if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_name = $1`, name); err != nil {
+		return fmt.Errorf("error deleting role permissions: %w", err)
+	}
+
+	query := `INSERT INTO role_permissions (role_name, permission)
+              SELECT $1, permission FROM unnest($2::text[]) AS permission
+              ON CONFLICT DO NOTHING`
+	if _, err := tx.Exec(query, name, pq.Array(permissions)); err != nil {
+		return fmt.Errorf("error creating role permissions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+// SearchRecordTypes are the record types covered by full-text search
+var SearchRecordTypes = []string{"account", "contact", "opportunity", "note"}
+
+// headlineOptions controls how ts_headline builds result snippets
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" ... \""
+
+// SearchRepository handles full-text search across CRM records
+type SearchRepository struct {
+	db *DB
+}
+
+// NewSearchRepository creates a new search repository
+func NewSearchRepository(db *DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+// searchListSpec pages search results by rank, best match first
+var searchListSpec = listSpec{
+	sortColumns: map[string]string{"rank": "rank"},
+	defaultSort: "-rank",
+}
+
+// escapeHTML returns a SQL expression that escapes the HTML special characters of a text expression, so record
+// text can be placed next to the markup ts_headline adds
+func escapeHTML(expr string) string {
+	for _, entity := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}, {"'", "&#39;"}} {
+		expr = fmt.Sprintf("replace(%s, %s, %s)", expr, pq.QuoteLiteral(entity[0]), pq.QuoteLiteral(entity[1]))
+	}
+	return expr
+}
+
+// Search runs a ranked full-text search over the records of the given types that are visible to the caller,
+// returning a page of the results in the same offset or cursor mode as the list queries.
+// The query uses web search syntax: quoted phrases, "or" and -excluded terms.
+func (r *SearchRepository) Search(text string, recordTypes []string, opts models.ListOptions, access Access) ([]models.SearchResult, *models.PageInfo, error) {
+	q, err := searchListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The search parameters are bound after the cursor position, if any
+	q.args = append(q.args, text, pq.Array(recordTypes))
+	textParam, typesParam := len(q.args)-1, len(q.args)
+	visible := access.visibleCondition(&q.args)
+
+	matches := fmt.Sprintf(`
+		WITH query AS (SELECT websearch_to_tsquery('english', $%[1]d) AS q),
+		matches AS (
+			SELECT id, 'account' AS record_type, name AS title,
+			       concat_ws(' ', name, industry, website, address, city, state, country) AS document,
+			       ts_rank(search_vector, query.q) AS rank
+			FROM accounts, query
+			WHERE 'account' = ANY($%[2]d::text[]) AND search_vector @@ query.q AND %[3]s
+			UNION ALL
+			SELECT id, 'contact', first_name || ' ' || last_name,
+			       concat_ws(' ', first_name, last_name, email, title, city, state, country),
+			       ts_rank(search_vector, query.q)
+			FROM contacts, query
+			WHERE 'contact' = ANY($%[2]d::text[]) AND search_vector @@ query.q AND %[3]s
+			UNION ALL
+			SELECT id, 'opportunity', opportunity_name,
+			       concat_ws(' ', opportunity_name, stage),
+			       ts_rank(search_vector, query.q)
+			FROM opportunities, query
+			WHERE 'opportunity' = ANY($%[2]d::text[]) AND search_vector @@ query.q AND %[3]s
+			UNION ALL
+			SELECT id, 'note', left(content, 80),
+			       content,
+			       ts_rank(search_vector, query.q)
+			FROM notes, query
+			WHERE 'note' = ANY($%[2]d::text[]) AND search_vector @@ query.q AND %[3]s
+		)`, textParam, typesParam, visible)
+
+	page := &models.PageInfo{}
+	if opts.Cursor == "" {
+		if err := r.db.QueryRow(matches+` SELECT COUNT(*) FROM matches`, q.args...).Scan(&page.Total); err != nil {
+			return nil, nil, fmt.Errorf("error counting search results: %w", err)
+		}
+	}
+
+	// Rank and page the matches first, then build snippets only for the rows returned
+	q.args = append(q.args, headlineOptions)
+	headlineParam := len(q.args)
+	limit, args := q.limit()
+	query := fmt.Sprintf(`%s,
+		page AS (SELECT * FROM matches%s%s%s)
+		SELECT m.id, m.record_type, m.title, ts_headline('english', %s, query.q, $%d), m.rank, m.rank::text AS rank_text
+		FROM page m, query
+		%s`, matches, q.where, q.orderBy, limit, escapeHTML("m.document"), headlineParam, q.orderBy)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error running search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	var ranks []string
+	for rows.Next() {
+		var result models.SearchResult
+		var rank string
+		if err := rows.Scan(
+			&result.RecordID,
+			&result.RecordType,
+			&result.Title,
+			&result.Snippet,
+			&result.Rank,
+			&rank,
+		); err != nil {
+			return nil, nil, fmt.Errorf("error scanning search result row: %w", err)
+		}
+		results = append(results, result)
+		ranks = append(ranks, rank)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating search result rows: %w", err)
+	}
+
+	// One row more than requested was fetched to tell whether another page follows
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+		last := results[len(results)-1]
+		next, err := encodeCursor(listCursor{Sort: opts.Sort, Values: []string{ranks[len(results)-1], last.RecordID.String()}})
+		if err != nil {
+			return nil, nil, err
+		}
+		page.NextCursor = next
+	}
+
+	return results, page, nil
+}
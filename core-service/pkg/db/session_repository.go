@@ -0,0 +1,282 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole session is revoked, since the token has most likely been stolen.
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
+
+// sessionColumns is the column list selected for every session query
+const sessionColumns = `id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at, mfa_verified`
+
+// SessionRepository handles database operations for user sessions and refresh tokens
+type SessionRepository struct {
+	db *DB
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// scanSession scans a row selected with sessionColumns into a session
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var userAgent, ipAddress sql.NullString
+	var revokedAt sql.NullTime
+	if err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&userAgent,
+		&ipAddress,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&revokedAt,
+		&session.MFAVerified,
+	); err != nil {
+		return nil, err
+	}
+
+	session.UserAgent = userAgent.String
+	session.IPAddress = ipAddress.String
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+// CreateSession starts a new session for the user together with its first refresh token
+func (r *SessionRepository) CreateSession(userID uuid.UUID, userAgent, ipAddress, tokenHash string, expiresAt time.Time, mfaVerified bool) (*models.Session, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	query := `INSERT INTO user_sessions (user_id, user_agent, ip_address, expires_at, mfa_verified)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING ` + sessionColumns
+	session, err := scanSession(tx.QueryRow(query, userID, userAgent, ipAddress, expiresAt, mfaVerified))
+	if err != nil {
+		return nil, fmt.Errorf("error creating session: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		session.ID, tokenHash, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating refresh token: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return session, nil
+}
+
+// RotateRefreshToken consumes a refresh token and stores its replacement in the same session.
+// Presenting a token that was already consumed revokes the session and returns ErrRefreshTokenReused.
+func (r *SessionRepository) RotateRefreshToken(oldHash, newHash string, expiresAt time.Time) (*models.Session, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	// Lock the token row so two concurrent refreshes cannot both succeed
+	var tokenID, sessionID uuid.UUID
+	var usedAt, revokedAt sql.NullTime
+	var tokenExpiresAt time.Time
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.session_id, rt.used_at, rt.expires_at, s.revoked_at
+		FROM refresh_tokens rt
+		JOIN user_sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s`, oldHash).Scan(&tokenID, &sessionID, &usedAt, &tokenExpiresAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("error querying refresh token: %w", err)
+	}
+
+	if revokedAt.Valid || time.Now().After(tokenExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if usedAt.Valid {
+		if _, err := tx.Exec(`UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = 'refresh_token_reuse' WHERE id = $1`, sessionID); err != nil {
+			return nil, fmt.Errorf("error revoking session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("error committing transaction: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		return nil, fmt.Errorf("error consuming refresh token: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		sessionID, newHash, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating refresh token: %w", err)
+	}
+
+	query := `UPDATE user_sessions SET last_used_at = NOW(), expires_at = $1 WHERE id = $2 RETURNING ` + sessionColumns
+	session, err := scanSession(tx.QueryRow(query, expiresAt, sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("error updating session: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return session, nil
+}
+
+// MarkSessionMFAVerified records that the session completed a second factor
+func (r *SessionRepository) MarkSessionMFAVerified(id uuid.UUID) (*models.Session, error) {
+	query := `UPDATE user_sessions SET mfa_verified = TRUE WHERE id = $1 RETURNING ` + sessionColumns
+	session, err := scanSession(r.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No session found
+		}
+		return nil, fmt.Errorf("error updating session: %w", err)
+	}
+	return session, nil
+}
+
+// GetActiveSessions retrieves the user's sessions that are neither revoked nor expired
+func (r *SessionRepository) GetActiveSessions(userID uuid.UUID) ([]models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM user_sessions
+              WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+              ORDER BY last_used_at DESC`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning session row: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session rows: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// IsSessionActive reports whether the session exists and has been neither revoked nor expired
+func (r *SessionRepository) IsSessionActive(id uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM user_sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())`
+	var active bool
+	if err := r.db.QueryRow(query, id).Scan(&active); err != nil {
+		return false, fmt.Errorf("error checking session: %w", err)
+	}
+	return active, nil
+}
+
+// RevokeSession revokes a single session belonging to the user
+func (r *SessionRepository) RevokeSession(id, userID uuid.UUID, reason string) error {
+	query := `UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $3
+              WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, id, userID, reason)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no session found with ID %s", id)
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes every active session of the user and returns how many were revoked
+func (r *SessionRepository) RevokeAllSessions(userID uuid.UUID, reason string) (int64, error) {
+	query := `UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $2
+              WHERE user_id = $1 AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, userID, reason)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+// RevokeSessionsByRole revokes every active session of the users with the given role and returns how many were revoked
+func (r *SessionRepository) RevokeSessionsByRole(role, reason string) (int64, error) {
+	query := `UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $2
+              WHERE user_id IN (SELECT id FROM users WHERE role = $1) AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, role, reason)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+// RevokeOtherSessions revokes every active session of the user except the given one
+func (r *SessionRepository) RevokeOtherSessions(userID, keepID uuid.UUID, reason string) (int64, error) {
+	query := `UPDATE user_sessions SET revoked_at = NOW(), revoked_reason = $3
+              WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+	result, err := r.db.Exec(query, userID, keepID, reason)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
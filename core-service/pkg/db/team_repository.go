@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrDuplicateTeam is returned when a team name is already taken
+var ErrDuplicateTeam = errors.New("team name already in use")
+
+// TeamRepository handles database operations for teams and their members
+type TeamRepository struct {
+	db *DB
+}
+
+// NewTeamRepository creates a new team repository
+func NewTeamRepository(db *DB) *TeamRepository {
+	return &TeamRepository{db: db}
+}
+
+// teamColumns is the column list selected for every team query
+const teamColumns = `id, name, COALESCE(description, ''), (SELECT COUNT(*) FROM team_members WHERE team_id = teams.id), created_at, updated_at`
+
+// scanTeam scans a row selected with teamColumns into a team
+func scanTeam(row rowScanner) (*models.Team, error) {
+	var team models.Team
+	if err := row.Scan(
+		&team.ID,
+		&team.Name,
+		&team.Description,
+		&team.MemberCount,
+		&team.CreatedAt,
+		&team.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// GetAllTeams retrieves every team ordered by name
+func (r *TeamRepository) GetAllTeams() ([]models.Team, error) {
+	rows, err := r.db.Query(`SELECT ` + teamColumns + ` FROM teams ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []models.Team
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning team row: %w", err)
+		}
+		teams = append(teams, *team)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating team rows: %w", err)
+	}
+
+	return teams, nil
+}
+
+// GetTeamByID retrieves a single team by ID
+func (r *TeamRepository) GetTeamByID(id uuid.UUID) (*models.Team, error) {
+	team, err := scanTeam(r.db.QueryRow(`SELECT `+teamColumns+` FROM teams WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No team found
+		}
+		return nil, fmt.Errorf("error querying team by ID: %w", err)
+	}
+
+	return team, nil
+}
+
+// CreateTeam creates a new team
+func (r *TeamRepository) CreateTeam(data models.TeamInput) (*models.Team, error) {
+	query := `INSERT INTO teams (name, description) VALUES ($1, NULLIF($2, ''))
+              RETURNING ` + teamColumns
+	team, err := scanTeam(r.db.QueryRow(query, data.Name, data.Description))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateTeam
+		}
+		return nil, fmt.Errorf("error creating team: %w", err)
+	}
+
+	return team, nil
+}
+
+// UpdateTeam renames a team and replaces its description
+func (r *TeamRepository) UpdateTeam(id uuid.UUID, data models.TeamInput) (*models.Team, error) {
+	query := `UPDATE teams SET name = $1, description = NULLIF($2, ''), updated_at = NOW()
+              WHERE id = $3
+              RETURNING ` + teamColumns
+	team, err := scanTeam(r.db.QueryRow(query, data.Name, data.Description, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No team found with this ID
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateTeam
+		}
+		return nil, fmt.Errorf("error updating team: %w", err)
+	}
+
+	return team, nil
+}
+
+// DeleteTeam deletes a team; records shared with it are then only visible to the members of the owner's other teams
+func (r *TeamRepository) DeleteTeam(id uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM teams WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting team: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: no team found with ID %s", ErrRecordNotFound, id)
+	}
+
+	return nil
+}
+
+// GetTeamMembers retrieves the users in a team ordered by username
+func (r *TeamRepository) GetTeamMembers(teamID uuid.UUID) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+              WHERE id IN (SELECT user_id FROM team_members WHERE team_id = $1)
+              ORDER BY username, id`
+	rows, err := r.db.Query(query, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying team members: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user row: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// AddTeamMember adds a user to a team; adding an existing member does nothing
+func (r *TeamRepository) AddTeamMember(teamID, userID uuid.UUID) error {
+	query := `INSERT INTO team_members (team_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if _, err := r.db.Exec(query, teamID, userID); err != nil {
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: no team or user found with IDs %s and %s", ErrRecordNotFound, teamID, userID)
+		}
+		return fmt.Errorf("error adding team member: %w", err)
+	}
+	return nil
+}
+
+// RemoveTeamMember removes a user from a team
+func (r *TeamRepository) RemoveTeamMember(teamID, userID uuid.UUID) error {
+	result, err := r.db.Exec(`DELETE FROM team_members WHERE team_id = $1 AND user_id = $2`, teamID, userID)
+	if err != nil {
+		return fmt.Errorf("error removing team member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: user %s is not a member of team %s", ErrRecordNotFound, userID, teamID)
+	}
+
+	return nil
+}
@@ -0,0 +1,452 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+// ErrParentInTrash is returned when restoring a record whose parent is still in the trash, or when linking a record
+// to a parent in the trash
+var ErrParentInTrash = errors.New("the record this belongs to is in the trash; restore it first")
+
+// purgeBatchSize is the number of records of one type purged per transaction
+const purgeBatchSize = 500
+
+// TrashConfig controls how long deleted records stay in the trash
+type TrashConfig struct {
+	Retention     time.Duration // Time in the trash before a record is purged; zero keeps records until restored
+	PurgeInterval time.Duration // Time between purge runs
+}
+
+// LoadTrashConfig reads the trash settings from environment variables:
+//
+//	TRASH_RETENTION_DAYS  days deleted records stay in the trash before being purged (default 30, 0 never purges)
+//	TRASH_PURGE_INTERVAL  time between purge runs as a Go duration (default 1h)
+func LoadTrashConfig() (*TrashConfig, error) {
+	days, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil || days < 0 {
+		return nil, fmt.Errorf("invalid TRASH_RETENTION_DAYS: must be a non-negative integer")
+	}
+
+	interval, err := time.ParseDuration(getEnv("TRASH_PURGE_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid TRASH_PURGE_INTERVAL: must be a positive duration such as 1h")
+	}
+
+	return &TrashConfig{
+		Retention:     time.Duration(days) * 24 * time.Hour,
+		PurgeInterval: interval,
+	}, nil
+}
+
+// trashLink is a foreign key from a child table to its parent
+type trashLink struct {
+	parent auditEntity
+	child  auditEntity
+	column string
+}
+
+// trashCascades are the children deleted with their parent (ON DELETE CASCADE). They go to the trash with it,
+// sharing its deleted_at, and come back when it is restored.
+var trashCascades = []trashLink{
+	{parent: auditAccounts, child: auditOpportunities, column: "account_id"},
+}
+
+// purgeLinks are the foreign keys that unlink or delete other rows when a record is purged
+var purgeLinks = []trashLink{
+	{parent: auditAccounts, child: auditContacts, column: "account_id"},
+	{parent: auditAccounts, child: auditOpportunities, column: "account_id"},
+	{parent: auditContacts, child: auditOpportunities, column: "primary_contact_id"},
+}
+
+// checkParents checks the parents a record of the entity is being linked to, given as link columns mapped to parent
+// IDs, where uuid.Nil clears the link. Linking to a parent the caller cannot see is an ErrInvalidRecord and linking
+// to one in the trash an ErrParentInTrash, so purging the parent cannot unexpectedly unlink or delete the record.
+// Links the record with the given ID already has are kept as they are; new records have the ID uuid.Nil.
+func checkParents(tx *sql.Tx, entity auditEntity, id uuid.UUID, parents map[string]uuid.UUID, access Access) error {
+	for _, link := range purgeLinks {
+		parentID, ok := parents[link.column]
+		if link.child != entity || !ok || parentID == uuid.Nil {
+			continue
+		}
+
+		if id != uuid.Nil {
+			var unchanged bool
+			query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1 AND %s = $2)`, entity.table, link.column)
+			if err := tx.QueryRow(query, id, parentID).Scan(&unchanged); err != nil {
+				return fmt.Errorf("error checking %s: %w", link.column, err)
+			}
+			if unchanged {
+				continue
+			}
+		}
+
+		args := []interface{}{parentID}
+		var inTrash bool
+		query := fmt.Sprintf(`SELECT deleted_at IS NOT NULL FROM %s WHERE id = $1 AND %s`, link.parent.table, access.sharedCondition(&args))
+		if err := tx.QueryRow(query, args...).Scan(&inTrash); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %s %s does not exist", ErrInvalidRecord, link.parent.entityType, parentID)
+			}
+			return fmt.Errorf("error checking %s: %w", link.column, err)
+		}
+		if inTrash {
+			return ErrParentInTrash
+		}
+	}
+
+	return nil
+}
+
+// moveToTrash moves a record the caller may change to the trash, along with its cascaded children.
+// It reports false when the record does not exist or is not visible to the caller.
+func moveToTrash(db *DB, entity auditEntity, id uuid.UUID, ifMatch Versions, access Access) (bool, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := trashRecord(tx, entity, id, ifMatch, access)
+	if err != nil || !found {
+		return found, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// trashRecord moves a record to the trash within a transaction; see moveToTrash
+func trashRecord(tx *sql.Tx, entity auditEntity, id uuid.UUID, ifMatch Versions, access Access) (bool, error) {
+	found, err := checkWritable(tx, entity.table, id, ifMatch, access)
+	if err != nil || !found {
+		return found, err
+	}
+
+	before, err := entity.takeSnapshot(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	// NOW() is fixed for the transaction, so the children get exactly the parent's deleted_at
+	for _, link := range trashCascades {
+		if link.parent != entity {
+			continue
+		}
+
+		condition := fmt.Sprintf(`%s = $1 AND deleted_at IS NULL`, link.column)
+		if err := updateChildren(tx, access, link, id, condition, `deleted_at = NOW(), deleted_by = $2`, access.UserID); err != nil {
+			return false, err
+		}
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET deleted_at = NOW(), deleted_by = $2 WHERE id = $1`, entity.table)
+	if _, err := tx.Exec(query, id, access.UserID); err != nil {
+		return false, fmt.Errorf("error deleting %s: %w", entity.entityType, err)
+	}
+
+	if err := recordAudit(tx, access.Actor, entity, id, before); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// restoreFromTrash takes a record the caller may change out of the trash, along with the children that were
+// deleted with it. It reports false when the record is not in the trash or is not visible to the caller.
+func restoreFromTrash(db *DB, entity auditEntity, id uuid.UUID, access Access) (bool, error) {
+	// Start a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	found, err := checkRestorable(tx, entity.table, id, access)
+	if err != nil || !found {
+		return found, err
+	}
+
+	before, err := entity.takeSnapshot(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	for _, link := range trashCascades {
+		// A child cannot come back without its parent, which would take it along when purged
+		if link.child == entity {
+			var parentInTrash bool
+			query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s parent JOIN %s child ON parent.id = child.%s
+                                  WHERE child.id = $1 AND parent.deleted_at IS NOT NULL)`,
+				link.parent.table, link.child.table, link.column)
+			if err := tx.QueryRow(query, id).Scan(&parentInTrash); err != nil {
+				return false, fmt.Errorf("error checking %s: %w", link.parent.entityType, err)
+			}
+			if parentInTrash {
+				return true, ErrParentInTrash
+			}
+		}
+
+		if link.parent == entity {
+			condition := fmt.Sprintf(`%s = $1 AND deleted_at = (SELECT deleted_at FROM %s WHERE id = $1)`, link.column, entity.table)
+			if err := updateChildren(tx, access, link, id, condition, `deleted_at = NULL, deleted_by = NULL`); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET deleted_at = NULL, deleted_by = NULL WHERE id = $1`, entity.table)
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, fmt.Errorf("error restoring %s: %w", entity.entityType, err)
+	}
+
+	if err := recordAudit(tx, access.Actor, entity, id, before); err != nil {
+		return false, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// updateChildren applies the SET clause to the children of a record that match the condition and records the
+// changes. The condition binds the parent's ID as $1; the SET clause binds setArgs from $2.
+func updateChildren(tx *sql.Tx, access Access, link trashLink, parentID uuid.UUID, condition, set string, setArgs ...interface{}) error {
+	children, err := link.child.takeSnapshots(tx, fmt.Sprintf(`SELECT id FROM %s WHERE %s`, link.child.table, condition), parentID)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE %s`, link.child.table, set, condition)
+	if _, err := tx.Exec(query, append([]interface{}{parentID}, setArgs...)...); err != nil {
+		return fmt.Errorf("error updating %s: %w", link.child.table, err)
+	}
+
+	return recordAudits(tx, access.Actor, link.child, children)
+}
+
+// TrashRepository lists and purges the records in the trash
+type TrashRepository struct {
+	db        *DB
+	retention time.Duration
+}
+
+// NewTrashRepository creates a new trash repository
+func NewTrashRepository(db *DB, config *TrashConfig) *TrashRepository {
+	return &TrashRepository{
+		db:        db,
+		retention: config.Retention,
+	}
+}
+
+// trashRecords is the relation of every record in the trash
+const trashRecords = `(
+	SELECT id, 'account' AS record_type, name AS title, owner_id, visibility, deleted_at, deleted_by
+	FROM accounts WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT id, 'contact', first_name || ' ' || last_name, owner_id, visibility, deleted_at, deleted_by
+	FROM contacts WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT id, 'opportunity', opportunity_name, owner_id, visibility, deleted_at, deleted_by
+	FROM opportunities WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT id, 'note', left(content, 80), owner_id, visibility, deleted_at, deleted_by
+	FROM notes WHERE deleted_at IS NOT NULL
+) AS trash`
+
+// trashListSpec defines the sortable and filterable trash fields
+var trashListSpec = listSpec{
+	sortColumns: map[string]string{
+		"deleted_at":  "deleted_at",
+		"title":       "title",
+		"record_type": "record_type",
+	},
+	defaultSort: "-deleted_at",
+	filters: map[string]listFilter{
+		"record_type":    {condition: "record_type = %s", kind: filterString},
+		"owner_id":       {condition: "owner_id = %s", kind: filterUUID},
+		"deleted_by":     {condition: "deleted_by = %s", kind: filterUUID},
+		"deleted_after":  {condition: "deleted_at >= %s", kind: filterTime},
+		"deleted_before": {condition: "deleted_at < %s", kind: filterTime},
+	},
+}
+
+// GetTrash retrieves a page of the deleted records of the given types that are visible to the caller,
+// most recently deleted first by default
+func (r *TrashRepository) GetTrash(opts models.ListOptions, recordTypes []string, access Access) ([]models.TrashItem, *models.PageInfo, error) {
+	q, err := trashListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	q.args = append(q.args, pq.Array(recordTypes))
+	q.addCondition(fmt.Sprintf("record_type = ANY($%d::text[])", len(q.args)))
+	q.addCondition(access.trashedCondition(&q.args))
+
+	limit, args := q.limit()
+	query := `SELECT id, record_type, title, owner_id, deleted_at, deleted_by FROM ` + trashRecords + q.where + q.orderBy + limit
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying trash: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.TrashItem
+	for rows.Next() {
+		var item models.TrashItem
+		var deletedBy uuid.NullUUID
+		if err := rows.Scan(
+			&item.RecordID,
+			&item.RecordType,
+			&item.Title,
+			&item.OwnerID,
+			&item.DeletedAt,
+			&deletedBy,
+		); err != nil {
+			return nil, nil, fmt.Errorf("error scanning trash row: %w", err)
+		}
+		item.DeletedBy = deletedBy.UUID
+
+		if r.retention > 0 {
+			purgeAt := item.DeletedAt.Add(r.retention)
+			item.PurgeAt = &purgeAt
+		}
+
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating trash rows: %w", err)
+	}
+
+	return finishPage(r.db, q, trashRecords, items, func(item models.TrashItem) uuid.UUID { return item.RecordID })
+}
+
+// Purge permanently deletes the records that have been in the trash longer than the retention period
+// and returns how many were deleted
+func (r *TrashRepository) Purge() (int, error) {
+	if r.retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-r.retention)
+	total := 0
+
+	// Children go before their parents, so every purged record gets its own purge event
+	for _, entity := range []auditEntity{auditNotes, auditOpportunities, auditContacts, auditAccounts} {
+		for {
+			purged, err := r.purgeBatch(entity, cutoff)
+			if err != nil {
+				return total, err
+			}
+			total += purged
+			if purged < purgeBatchSize {
+				break
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// purgeBatch permanently deletes up to purgeBatchSize records of one type deleted before the cutoff.
+// Rows the database unlinks or deletes along with them are recorded in the audit log as well.
+func (r *TrashRepository) purgeBatch(entity auditEntity, cutoff time.Time) (int, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	// Skip rows another instance is already purging
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE deleted_at < $1 ORDER BY deleted_at LIMIT $2 FOR UPDATE SKIP LOCKED`, entity.table)
+	purged, err := entity.takeSnapshots(tx, query, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(purged))
+	for id := range purged {
+		ids = append(ids, id.String())
+	}
+
+	type linkedRows struct {
+		entity    auditEntity
+		snapshots map[uuid.UUID]map[string]interface{}
+	}
+	var linked []linkedRows
+	for _, link := range purgeLinks {
+		if link.parent != entity {
+			continue
+		}
+
+		query := fmt.Sprintf(`SELECT id FROM %s WHERE %s = ANY($1::uuid[])`, link.child.table, link.column)
+		snapshots, err := link.child.takeSnapshots(tx, query, pq.Array(ids))
+		if err != nil {
+			return 0, err
+		}
+		linked = append(linked, linkedRows{link.child, snapshots})
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1::uuid[])`, entity.table), pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("error purging %s: %w", entity.table, err)
+	}
+
+	for id, before := range purged {
+		if err := insertAuditEvent(tx, Actor{}, entity.entityType, id, "purge", auditDiff(before, nil)); err != nil {
+			return 0, err
+		}
+	}
+	for _, rows := range linked {
+		if err := recordAudits(tx, Actor{}, rows.entity, rows.snapshots); err != nil {
+			return 0, err
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return len(purged), nil
+}
+
+// PurgeEvery purges the trash now and then once per interval, logging the outcome. It never returns.
+func (r *TrashRepository) PurgeEvery(interval time.Duration) {
+	for {
+		purged, err := r.Purge()
+		if err != nil {
+			log.Printf("ERROR: Trash purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d records from the trash", purged)
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -2,12 +2,27 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/google/uuid"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUserDeactivated is returned when a deactivated user presents valid credentials
+	ErrUserDeactivated = errors.New("user account is deactivated")
+	// ErrInvalidPassword is returned when the current password given for a password change does not match
+	ErrInvalidPassword = errors.New("current password is incorrect")
+	// ErrDuplicateUser is returned when a username or email is already taken
+	ErrDuplicateUser = errors.New("username or email already in use")
+	// ErrUnknownRole is returned when a user is given a role that does not exist
+	ErrUnknownRole = errors.New("role does not exist")
+	// ErrUserNotFound is returned when a user to change does not exist
+	ErrUserNotFound = errors.New("user not found")
 )
 
 // UserRepository handles database operations for users
@@ -20,50 +35,108 @@ func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-// GetAllUsers retrieves all users from the database
-func (r *UserRepository) GetAllUsers() ([]models.User, error) {
-	query := `SELECT id, username, email, role, created_at, updated_at FROM users ORDER BY username`
-	rows, err := r.db.Query(query)
+// userColumns is the column list selected for every user query, including the permissions granted by the user's role
+const userColumns = `id, username, email, role, is_active, deactivated_at, email_verified_at, mfa_enabled,
+                     ARRAY(SELECT permission FROM role_permissions WHERE role_name = users.role ORDER BY permission),
+                     created_at, updated_at`
+
+// userListSpec defines the sortable and filterable user fields
+var userListSpec = listSpec{
+	sortColumns: map[string]string{
+		"username":   "username",
+		"email":      "email",
+		"role":       "role",
+		"created_at": "created_at",
+	},
+	defaultSort: "username",
+	filters: map[string]listFilter{
+		"username":      {condition: "username ILIKE '%%' || %s || '%%'", kind: filterString},
+		"email":         {condition: "LOWER(email) = LOWER(%s)", kind: filterString},
+		"role":          {condition: "role = %s", kind: filterString},
+		"is_active":     {condition: "is_active = %s", kind: filterBool},
+		"verified":      {condition: "(email_verified_at IS NOT NULL) = %s", kind: filterBool},
+		"created_after": {condition: "created_at >= %s", kind: filterTime},
+	},
+}
+
+// scanUser scans a row selected with userColumns, followed by any extra destinations, into a user
+func scanUser(row rowScanner, extra ...interface{}) (*models.User, error) {
+	var user models.User
+	var deactivatedAt, emailVerifiedAt sql.NullTime
+	dest := append([]interface{}{
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Role,
+		&user.IsActive,
+		&deactivatedAt,
+		&emailVerifiedAt,
+		&user.MFAEnabled,
+		pq.Array(&user.Permissions),
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	return &user, nil
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key constraint violation
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// GetAllUsers retrieves a page of users matching the list options, along with its paging metadata
+func (r *UserRepository) GetAllUsers(opts models.ListOptions) ([]models.User, *models.PageInfo, error) {
+	q, err := userListSpec.build(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit, args := q.limit()
+	rows, err := r.db.Query(`SELECT `+userColumns+` FROM users`+q.where+q.orderBy+limit, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying users: %w", err)
+		return nil, nil, fmt.Errorf("error querying users: %w", err)
 	}
 	defer rows.Close()
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(
-			&user.ID,
-			&user.Username,
-			&user.Email,
-			&user.Role,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("error scanning user row: %w", err)
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning user row: %w", err)
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating user rows: %w", err)
+		return nil, nil, fmt.Errorf("error iterating user rows: %w", err)
 	}
 
-	return users, nil
+	return finishPage(r.db, q, "users", users, func(u models.User) uuid.UUID { return u.ID })
 }
 
 // GetUserByID retrieves a single user by ID
 func (r *UserRepository) GetUserByID(id uuid.UUID) (*models.User, error) {
-	query := `SELECT id, username, email, role, created_at, updated_at FROM users WHERE id = $1`
-	var user models.User
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(r.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No user found
@@ -71,21 +144,13 @@ func (r *UserRepository) GetUserByID(id uuid.UUID) (*models.User, error) {
 		return nil, fmt.Errorf("error querying user by ID: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetUserByEmail retrieves a single user by email address
 func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
-	query := `SELECT id, username, email, role, created_at, updated_at FROM users WHERE email = $1`
-	var user models.User
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	user, err := scanUser(r.db.QueryRow(query, email))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No user found
@@ -93,28 +158,23 @@ func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 		return nil, fmt.Errorf("error querying user by email: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-// CheckUserPassword verifies if the provided credentials are valid
+// dummyPasswordHash is compared against when an email is unknown, so the lookup takes as long as for a real user
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// CheckUserPassword verifies if the provided credentials are valid.
+// Valid credentials of a deactivated user return ErrUserDeactivated.
+// Unknown emails still cost a bcrypt comparison, so response times do not reveal which emails exist.
 func (r *UserRepository) CheckUserPassword(email, password string) (*models.User, error) {
-	query := `SELECT id, username, email, role, password_hash, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT ` + userColumns + `, password_hash FROM users WHERE email = $1`
 
-	var user models.User
 	var passwordHash string
-
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Role,
-		&passwordHash,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-
+	user, err := scanUser(r.db.QueryRow(query, email), &passwordHash)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
 			return nil, nil // No user found
 		}
 		return nil, fmt.Errorf("error querying user credentials: %w", err)
@@ -126,40 +186,351 @@ func (r *UserRepository) CheckUserPassword(email, password string) (*models.User
 		return nil, nil // Password doesn't match
 	}
 
-	return &user, nil
+	if !user.IsActive {
+		return nil, ErrUserDeactivated
+	}
+
+	return user, nil
 }
 
 // CreateUser creates a new user in the database
-func (r *UserRepository) CreateUser(userData models.UserCreate) (*models.User, error) {
+func (r *UserRepository) CreateUser(userData models.UserCreate, actor Actor) (*models.User, error) {
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userData.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("error hashing password: %w", err)
 	}
 
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
 	query := `INSERT INTO users (username, email, password_hash, role) 
               VALUES ($1, $2, $3, $4) 
-              RETURNING id, username, email, role, created_at, updated_at`
+              RETURNING ` + userColumns
 
-	var user models.User
-	err = r.db.QueryRow(
+	user, err := scanUser(tx.QueryRow(
 		query,
 		userData.Username,
 		userData.Email,
 		string(hashedPassword),
 		userData.Role,
-	).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	))
 
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateUser
+		}
+		if isForeignKeyViolation(err) {
+			return nil, ErrUnknownRole
+		}
 		return nil, fmt.Errorf("error creating user: %w", err)
 	}
 
-	return &user, nil
+	if err := recordAudit(tx, actor, auditUsers, user.ID, nil); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateUser updates an existing user in the database; empty fields are left unchanged.
+// Changing the email address clears its verification.
+func (r *UserRepository) UpdateUser(id uuid.UUID, data models.UserUpdate, actor Actor) (*models.User, error) {
+	var passwordHash string
+	if data.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing password: %w", err)
+		}
+		passwordHash = string(hashedPassword)
+	}
+
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	before, err := auditUsers.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, nil // No user found with this ID
+	}
+
+	query := `UPDATE users SET 
+              username = COALESCE(NULLIF($1, ''), username),
+              email = COALESCE(NULLIF($2, ''), email),
+              email_verified_at = CASE WHEN NULLIF($2, '') IS NULL OR $2 = email THEN email_verified_at END,
+              password_hash = COALESCE(NULLIF($3, ''), password_hash),
+              role = COALESCE(NULLIF($4, ''), role),
+              updated_at = NOW()
+              WHERE id = $5
+              RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRow(
+		query,
+		data.Username,
+		data.Email,
+		passwordHash,
+		data.Role,
+		id,
+	))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No user found with this ID
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateUser
+		}
+		if isForeignKeyViolation(err) {
+			return nil, ErrUnknownRole
+		}
+		return nil, fmt.Errorf("error updating user: %w", err)
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, id, before); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the user's password after checking the current one
+func (r *UserRepository) ChangePassword(id uuid.UUID, currentPassword, newPassword string, actor Actor) error {
+	var passwordHash string
+	err := r.db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, id).Scan(&passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("error querying user credentials: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(currentPassword)); err != nil {
+		return ErrInvalidPassword
+	}
+
+	return r.SetPassword(id, newPassword, actor)
+}
+
+// SetPassword replaces the user's password without checking the current one, as done by a password reset
+func (r *UserRepository) SetPassword(id uuid.UUID, newPassword string, actor Actor) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	before, err := auditUsers.takeSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`, string(hashedPassword), id)
+	if err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, id, before); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that the user confirmed the given address.
+// It returns false when the user's email has changed since the verification was sent.
+func (r *UserRepository) MarkEmailVerified(id uuid.UUID, email string, actor Actor) (bool, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	before, err := auditUsers.takeSnapshot(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+              WHERE id = $1 AND email = $2`
+	result, err := tx.Exec(query, id, email)
+	if err != nil {
+		return false, fmt.Errorf("error verifying email: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, id, before); err != nil {
+		return false, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// SetUserActive activates or deactivates a user. Deactivated users keep their records but cannot log in.
+func (r *UserRepository) SetUserActive(id uuid.UUID, active bool, actor Actor) (*models.User, error) {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	before, err := auditUsers.takeSnapshot(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, nil // No user found with this ID
+	}
+
+	query := `UPDATE users SET 
+              is_active = $1,
+              deactivated_at = CASE WHEN $1 THEN NULL ELSE COALESCE(deactivated_at, NOW()) END,
+              updated_at = NOW()
+              WHERE id = $2
+              RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRow(query, active, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No user found with this ID
+		}
+		return nil, fmt.Errorf("error updating user status: %w", err)
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, id, before); err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+// DeleteUser deletes a user after transferring the records they created or own to another user
+func (r *UserRepository) DeleteUser(id, reassignTo uuid.UUID, actor Actor) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	before, err := auditUsers.takeSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+
+	// created_by and owner_id are NOT NULL on these tables, so the records must move before the user can go;
+	// updated_by and deleted_by are cleared by the database when the user is deleted
+	for _, entity := range []auditEntity{auditAccounts, auditContacts, auditOpportunities, auditNotes} {
+		reassigned, err := entity.takeSnapshots(tx, fmt.Sprintf(`SELECT id FROM %s WHERE created_by = $1 OR updated_by = $1 OR deleted_by = $1 OR owner_id = $1`, entity.table), id)
+		if err != nil {
+			return err
+		}
+
+		for _, column := range []string{"created_by", "owner_id"} {
+			query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2`, entity.table, column, column)
+			if _, err := tx.Exec(query, reassignTo, id); err != nil {
+				return fmt.Errorf("error reassigning %s: %w", entity.table, err)
+			}
+		}
+
+		if err := recordAudits(tx, actor, entity, reassigned); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := recordAudit(tx, actor, auditUsers, id, before); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
 }
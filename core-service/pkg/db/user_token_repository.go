@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of single-use user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// ErrUserTokenInvalid is returned for unknown, expired or already used tokens
+var ErrUserTokenInvalid = errors.New("invalid or expired token")
+
+// UserTokenRepository handles database operations for password reset and email verification tokens
+type UserTokenRepository struct {
+	db *DB
+}
+
+// NewUserTokenRepository creates a new user token repository
+func NewUserTokenRepository(db *DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// CreateToken stores a new token for the user, invalidating any earlier unused token with the same purpose
+func (r *UserTokenRepository) CreateToken(userID uuid.UUID, purpose, email, tokenHash string, expiresAt time.Time) error {
+	// Start a transaction
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	// Defer a rollback in case anything fails
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, purpose)
+	if err != nil {
+		return fmt.Errorf("error invalidating previous tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO user_tokens (user_id, purpose, email, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		userID, purpose, email, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error creating token: %w", err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeToken marks a valid token as used and returns the user it was issued for and the address it was sent to
+func (r *UserTokenRepository) ConsumeToken(purpose, tokenHash string) (uuid.UUID, string, error) {
+	query := `UPDATE user_tokens SET used_at = NOW()
+              WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+              RETURNING user_id, email`
+
+	var userID uuid.UUID
+	var email string
+	if err := r.db.QueryRow(query, tokenHash, purpose).Scan(&userID, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, "", ErrUserTokenInvalid
+		}
+		return uuid.Nil, "", fmt.Errorf("error consuming token: %w", err)
+	}
+
+	return userID, email, nil
+}
@@ -0,0 +1,25 @@
+package db
+
+import "errors"
+
+// ErrVersionConflict is returned when a change is conditional on a version of a record other than its current one
+var ErrVersionConflict = errors.New("record has changed since it was read")
+
+// Versions lists the record versions a change is conditional on, as sent in an If-Match header.
+// A nil list places no condition on the change; an empty one matches no version.
+type Versions []int64
+
+// check returns ErrVersionConflict when the record's current version is not one of the listed versions
+func (v Versions) check(version int64) error {
+	if v == nil {
+		return nil
+	}
+
+	for _, listed := range v {
+		if listed == version {
+			return nil
+		}
+	}
+
+	return ErrVersionConflict
+}
@@ -0,0 +1,92 @@
+package dedupe
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// companySuffixes are words that do not tell companies apart, such as legal forms
+var companySuffixes = map[string]bool{
+	"the": true, "inc": true, "incorporated": true, "llc": true, "llp": true, "ltd": true, "limited": true,
+	"corp": true, "corporation": true, "co": true, "company": true, "plc": true, "gmbh": true, "ag": true,
+	"sa": true, "bv": true, "nv": true, "pty": true, "group": true,
+}
+
+// websiteScheme matches the scheme and www. prefix of a website address
+var websiteScheme = regexp.MustCompile(`^([a-z][a-z0-9+.-]*://)?(www\.)?`)
+
+// NormalizeEmail lowercases an email address and drops the +tag of its mailbox, so that
+// "Ada.L+crm@Example.com" and "ada.l@example.com" are the same address
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	mailbox, domain, ok := strings.Cut(email, "@")
+	if !ok || mailbox == "" || domain == "" {
+		return ""
+	}
+	mailbox, _, _ = strings.Cut(mailbox, "+")
+	return mailbox + "@" + domain
+}
+
+// NormalizePhone returns the last 10 digits of a phone number, ignoring formatting and country codes,
+// or "" when it has fewer than 7 digits
+func NormalizePhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+
+	number := digits.String()
+	if len(number) < 7 {
+		return ""
+	}
+	if len(number) > 10 {
+		number = number[len(number)-10:]
+	}
+	return number
+}
+
+// WebsiteDomain returns the host of a website address without www., such as "acme.com" for
+// "https://www.Acme.com/about"
+func WebsiteDomain(website string) string {
+	website = websiteScheme.ReplaceAllString(strings.ToLower(strings.TrimSpace(website)), "")
+	if i := strings.IndexAny(website, "/:?#"); i >= 0 {
+		website = website[:i]
+	}
+	return website
+}
+
+// EmailDomain returns the domain of an email address
+func EmailDomain(email string) string {
+	_, domain, _ := strings.Cut(NormalizeEmail(email), "@")
+	return domain
+}
+
+// NormalizeName lowercases a name and reduces it to its words, so punctuation and spacing do not matter
+func NormalizeName(name string) string {
+	return strings.Join(words(name), " ")
+}
+
+// NormalizeCompanyName is NormalizeName without legal forms and similar words, so that "Acme" and
+// "ACME, Inc." are the same company
+func NormalizeCompanyName(name string) string {
+	var kept []string
+	for _, word := range words(name) {
+		if !companySuffixes[word] {
+			kept = append(kept, word)
+		}
+	}
+	if len(kept) == 0 {
+		return NormalizeName(name)
+	}
+	return strings.Join(kept, " ")
+}
+
+// words splits lowercased text into its runs of letters and digits
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
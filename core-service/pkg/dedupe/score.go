@@ -0,0 +1,125 @@
+package dedupe
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// similarNames is the similarity from which two names count as alike
+const similarNames = 0.9
+
+// Match is how likely two records are to be the same, from 0 to 100, and what they have in common
+type Match struct {
+	Score   int
+	Reasons []string
+}
+
+// add raises the score by points for a reason
+func (m *Match) add(points float64, reason string) {
+	m.Score += int(math.Round(points))
+	m.Reasons = append(m.Reasons, reason)
+}
+
+// addName raises the score by up to points for names that are alike
+func (m *Match) addName(a, b string, points float64) {
+	if a == "" || b == "" {
+		return
+	}
+	if a == b {
+		m.add(points, "same name")
+		return
+	}
+	if similarity := Similarity(a, b); similarity >= similarNames {
+		m.add(points*similarity, fmt.Sprintf("similar name (%d%%)", int(similarity*100)))
+	}
+}
+
+// ScoreContacts scores two contacts: the same email address counts most, then the name, the phone number and
+// the account
+func ScoreContacts(a, b *models.Contact) Match {
+	var m Match
+	if email := NormalizeEmail(a.Email); email != "" && email == NormalizeEmail(b.Email) {
+		m.add(50, "same email")
+	}
+	m.addName(NormalizeName(a.FirstName+" "+a.LastName), NormalizeName(b.FirstName+" "+b.LastName), 25)
+	if phone := NormalizePhone(a.Phone); phone != "" && phone == NormalizePhone(b.Phone) {
+		m.add(20, "same phone")
+	}
+	if a.AccountID != uuid.Nil && a.AccountID == b.AccountID {
+		m.add(5, "same account")
+	}
+	return m
+}
+
+// ScoreAccounts scores two accounts: the name counts most, then the website domain and the phone number
+func ScoreAccounts(a, b *models.Account) Match {
+	var m Match
+	m.addName(NormalizeCompanyName(a.Name), NormalizeCompanyName(b.Name), 50)
+	if domain := WebsiteDomain(a.Website); domain != "" && domain == WebsiteDomain(b.Website) {
+		m.add(35, "same website domain")
+	}
+	if phone := NormalizePhone(a.Phone); phone != "" && phone == NormalizePhone(b.Phone) {
+		m.add(15, "same phone")
+	}
+	return m
+}
+
+// Similarity returns the Jaro-Winkler similarity of two strings, from 0 for nothing in common to 1 for equal.
+// It favours strings with the same beginning, which suits names with suffixes or typos near the end.
+func Similarity(a, b string) float64 {
+	s, t := []rune(a), []rune(b)
+	if len(s) == 0 || len(t) == 0 {
+		if len(s) == len(t) {
+			return 1
+		}
+		return 0
+	}
+
+	window := max(len(s), len(t))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	sMatched := make([]bool, len(s))
+	tMatched := make([]bool, len(t))
+	matches := 0
+	for i := range s {
+		for j := max(0, i-window); j < min(len(t), i+window+1); j++ {
+			if !tMatched[j] && s[i] == t[j] {
+				sMatched[i], tMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// Count the matched characters that are out of order
+	transpositions, j := 0, 0
+	for i := range s {
+		if !sMatched[i] {
+			continue
+		}
+		for !tMatched[j] {
+			j++
+		}
+		if s[i] != t[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s)) + m/float64(len(t)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s), len(t)) && s[prefix] == t[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
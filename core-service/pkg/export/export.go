@@ -0,0 +1,179 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Formats are the supported export formats
+var Formats = []string{"csv", "ndjson", "xlsx"}
+
+// contentTypes maps each format to its media type
+var contentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Writer writes exported rows in one format
+type Writer interface {
+	// WriteRow writes one row, with a value for each column
+	WriteRow(values []interface{}) error
+	// Flush writes the buffered rows to the underlying writer
+	Flush() error
+	// Close finishes the file; it does not close the underlying writer
+	Close() error
+}
+
+// ContentType returns the media type of a format, or "" when the format is not supported
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// NewWriter creates a writer of the format that writes the given columns to w, starting with a header where
+// the format has one
+func NewWriter(format string, w io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case "csv":
+		return newCSVWriter(w, columns)
+	case "ndjson":
+		return newNDJSONWriter(w, columns)
+	case "xlsx":
+		return newXLSXWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// csvWriter writes rows as CSV with a header row
+type csvWriter struct {
+	w     *csv.Writer
+	cells []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	writer := &csvWriter{w: csv.NewWriter(w), cells: make([]string, len(columns))}
+	if err := writer.w.Write(columns); err != nil {
+		return nil, fmt.Errorf("error writing CSV header: %w", err)
+	}
+	return writer, nil
+}
+
+func (w *csvWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		w.cells[i] = csvCell(value)
+	}
+	return w.w.Write(w.cells)
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Close() error {
+	return w.Flush()
+}
+
+// csvCell formats a value as a CSV cell. Text that a spreadsheet would run as a formula is prefixed with a quote.
+func csvCell(value interface{}) string {
+	text, ok := value.(string)
+	if !ok {
+		return formatValue(value)
+	}
+
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// ndjsonWriter writes rows as newline-delimited JSON objects, with the columns in order
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte // The JSON-encoded column names
+}
+
+func newNDJSONWriter(w io.Writer, columns []string) (*ndjsonWriter, error) {
+	writer := &ndjsonWriter{w: bufio.NewWriter(w)}
+	for _, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding column name: %w", err)
+		}
+		writer.keys = append(writer.keys, key)
+	}
+	return writer, nil
+}
+
+func (w *ndjsonWriter) WriteRow(values []interface{}) error {
+	w.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		w.w.Write(w.keys[i])
+		w.w.WriteByte(':')
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %w", w.keys[i], err)
+		}
+		w.w.Write(encoded)
+	}
+	_, err := w.w.WriteString("}\n")
+	return err
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.Flush()
+}
+
+// formatValue formats a value as text: the nil UUID is empty, times are RFC 3339 and values without a
+// plain text form are JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case uuid.UUID:
+		if v == uuid.Nil {
+			return ""
+		}
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
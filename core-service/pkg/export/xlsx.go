@@ -0,0 +1,187 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xlsxMaxRows is the number of rows an Excel worksheet holds; longer exports continue on another sheet
+const xlsxMaxRows = 1048576
+
+// xlsxWriter writes rows as an Excel workbook, one worksheet at a time, without holding the rows in memory.
+// Text is written as inline strings so the workbook needs no shared string table, which would have to be
+// written before the sheets that use it.
+type xlsxWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []string
+	refs    []string // Column letters: A, B, ... Z, AA, ...
+	sheets  int
+	rows    int // Rows written to the current sheet, including the header
+}
+
+func newXLSXWriter(w io.Writer, columns []string) (*xlsxWriter, error) {
+	writer := &xlsxWriter{zip: zip.NewWriter(w), columns: columns}
+	for i := range columns {
+		writer.refs = append(writer.refs, columnRef(i))
+	}
+
+	if err := writer.startSheet(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// columnRef returns the letters of the column at an index
+func columnRef(i int) string {
+	ref := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		ref = string(rune('A'+(i-1)%26)) + ref
+	}
+	return ref
+}
+
+// startSheet starts the next worksheet with the header row
+func (w *xlsxWriter) startSheet() error {
+	w.sheets++
+	entry, err := w.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", w.sheets))
+	if err != nil {
+		return fmt.Errorf("error creating worksheet: %w", err)
+	}
+
+	w.sheet = bufio.NewWriter(entry)
+	w.rows = 0
+	w.sheet.WriteString(xml.Header)
+	w.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(w.columns))
+	for i, column := range w.columns {
+		header[i] = column
+	}
+	return w.writeRow(header)
+}
+
+// endSheet finishes the current worksheet
+func (w *xlsxWriter) endSheet() error {
+	w.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := w.sheet.Flush(); err != nil {
+		return fmt.Errorf("error writing worksheet: %w", err)
+	}
+	return nil
+}
+
+func (w *xlsxWriter) WriteRow(values []interface{}) error {
+	if w.rows == xlsxMaxRows {
+		if err := w.endSheet(); err != nil {
+			return err
+		}
+		if err := w.startSheet(); err != nil {
+			return err
+		}
+	}
+	return w.writeRow(values)
+}
+
+// writeRow writes a row to the current sheet. Numbers and booleans are typed cells; everything else is text.
+func (w *xlsxWriter) writeRow(values []interface{}) error {
+	w.rows++
+	row := strconv.Itoa(w.rows)
+	w.sheet.WriteString(`<row r="` + row + `">`)
+
+	for i, value := range values {
+		ref := w.refs[i] + row
+		switch v := value.(type) {
+		case float64:
+			w.sheet.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+		case int:
+			w.sheet.WriteString(`<c r="` + ref + `"><v>` + strconv.Itoa(v) + `</v></c>`)
+		case int64:
+			w.sheet.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case bool:
+			boolean := "0"
+			if v {
+				boolean = "1"
+			}
+			w.sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + boolean + `</v></c>`)
+		default:
+			text := formatValue(value)
+			if text == "" {
+				continue
+			}
+			w.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(w.sheet, []byte(text))
+			w.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (w *xlsxWriter) Flush() error {
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	return w.zip.Flush()
+}
+
+// Close finishes the last worksheet and writes the parts of the workbook that list the sheets
+func (w *xlsxWriter) Close() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	var sheets, rels, overrides strings.Builder
+	for i := 1; i <= w.sheets; i++ {
+		name := "Export"
+		if i > 1 {
+			name = fmt.Sprintf("Export %d", i)
+		}
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	// The styles relationship follows the sheets
+	styles := w.sheets + 1
+
+	parts := []struct{ name, content string }{
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() +
+			fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, styles) +
+			`</Relationships>`},
+		{"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+			`<borders count="1"><border/></borders>` +
+			`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+			`<cellXfs count="1"><xf/></cellXfs>` +
+			`</styleSheet>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			overrides.String() + `</Types>`},
+	}
+
+	for _, part := range parts {
+		entry, err := w.zip.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", part.name, err)
+		}
+		if _, err := io.WriteString(entry, xml.Header+part.content); err != nil {
+			return fmt.Errorf("error writing %s: %w", part.name, err)
+		}
+	}
+
+	return w.zip.Close()
+}
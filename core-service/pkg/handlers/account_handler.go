@@ -1,41 +1,61 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
 
 // AccountHandler handles HTTP requests for accounts
 type AccountHandler struct {
-	repo *db.AccountRepository
+	repo   *db.AccountRepository
+	fields customFields
 }
 
 // NewAccountHandler creates a new account handler
-func NewAccountHandler(repo *db.AccountRepository) *AccountHandler {
-	return &AccountHandler{repo: repo}
+func NewAccountHandler(repo *db.AccountRepository, customFieldRepo *db.CustomFieldRepository) *AccountHandler {
+	return &AccountHandler{repo: repo, fields: customFields{repo: customFieldRepo, entityType: "account"}}
 }
 
-// RegisterRoutes registers the account routes to the given router group
+// RegisterRoutes registers the account routes to the given router group; reads and writes require separate permissions
 func (h *AccountHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermAccountsRead)
+	write := RequirePermission(auth.PermAccountsWrite)
+
 	accounts := rg.Group("/accounts")
 	{
-		accounts.GET("", h.GetAllAccounts)
-		accounts.POST("", h.CreateAccount)
-		accounts.GET("/:id", h.GetAccountByID)
-		accounts.PUT("/:id", h.UpdateAccount)
-		accounts.DELETE("/:id", h.DeleteAccount)
+		accounts.GET("", read, h.GetAllAccounts)
+		accounts.GET("/export", read, h.ExportAccounts)
+		accounts.GET("/duplicates", read, h.GetDuplicateAccounts)
+		accounts.POST("/merge", write, h.MergeAccounts)
+		accounts.POST("", write, h.CreateAccount)
+		accounts.POST("/bulk", write, h.BulkAccounts)
+		accounts.GET("/:id", read, h.GetAccountByID)
+		accounts.PUT("/:id", write, h.UpdateAccount)
+		accounts.PATCH("/:id", write, h.PatchAccount)
+		accounts.DELETE("/:id", write, h.DeleteAccount)
+		accounts.POST("/:id/transfer", write, h.TransferAccount)
+		accounts.POST("/:id/restore", write, h.RestoreAccount)
 	}
 }
 
-// GetAllAccounts returns all accounts
+// GetAllAccounts returns a page of the accounts visible to the caller matching the query-string filters
 func (h *AccountHandler) GetAllAccounts(c *gin.Context) {
-	accounts, err := h.repo.GetAllAccounts()
+	opts, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	accounts, page, err := h.repo.GetAllAccounts(opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
 		return
 	}
 
@@ -44,13 +64,67 @@ func (h *AccountHandler) GetAllAccounts(c *gin.Context) {
 		accounts = []models.Account{}
 	}
 
-	// Return in paginated format matching OpenAPI spec
-	c.JSON(http.StatusOK, gin.H{
-		"data":   accounts,
-		"total":  len(accounts),
-		"limit":  100,
-		"offset": 0,
-	})
+	respondWithList(c, accounts, opts, page)
+}
+
+// accountExport lists the columns accounts are exported with, in order
+var accountExport = newExportSpec[models.Account]("id", "name", "industry", "website", "phone", "address", "city", "state", "zip", "country",
+	"owner_id", "visibility", "created_by", "created_at", "updated_by", "updated_at", "version", "custom_fields")
+
+// ExportAccounts streams the accounts visible to the caller matching the query-string filters as a CSV, NDJSON or
+// XLSX file, optionally with only the columns listed in the columns parameter; each account's custom fields are a JSON object
+func (h *AccountHandler) ExportAccounts(c *gin.Context) {
+	streamExport(c, "accounts", accountExport, h.repo.ExportAccounts)
+}
+
+// GetDuplicateAccounts returns a page of the pairs of accounts visible to the caller that are likely duplicates,
+// best match first
+func (h *AccountHandler) GetDuplicateAccounts(c *gin.Context) {
+	opts, minScore, err := parseDuplicateOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	candidates, err := h.repo.GetDuplicateCandidates(recordAccess(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	duplicates, total := findDuplicates(candidates, dedupe.ScoreAccounts, minScore, opts,
+		func(match dedupe.Match, pair [2]models.Account) models.AccountDuplicate {
+			return models.AccountDuplicate{Score: match.Score, Reasons: match.Reasons, Records: pair[:]}
+		})
+
+	respondWithList(c, duplicates, opts, &models.PageInfo{Total: total})
+}
+
+// MergeAccounts merges duplicate accounts into the survivor, moving their contacts, opportunities and notes to it, and returns the survivor
+func (h *AccountHandler) MergeAccounts(c *gin.Context) {
+	var request models.MergeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := h.repo.MergeAccounts(request, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, request.SurvivorID, h.repo.GetAccountByID, accountTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if account == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	c.Header("ETag", accountTag(account))
+	c.JSON(http.StatusOK, account)
 }
 
 // GetAccountByID returns a single account by ID
@@ -62,7 +136,7 @@ func (h *AccountHandler) GetAccountByID(c *gin.Context) {
 		return
 	}
 
-	account, err := h.repo.GetAccountByID(id)
+	account, err := h.repo.GetAccountByID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -73,10 +147,17 @@ func (h *AccountHandler) GetAccountByID(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, accountTag(account)) {
+		return
+	}
+
 	c.JSON(http.StatusOK, account)
 }
 
-// CreateAccount creates a new account
+// CreateAccount creates a new account; the caller is recorded as its creator and owner.
+// Under the default duplicate rules, an account is answered with 409 and the matching accounts when the caller can
+// see one with the same phone number, or with the same name apart from legal forms such as Inc. and, if both have
+// a website, the same website domain; allow_duplicate=true creates it anyway.
 func (h *AccountHandler) CreateAccount(c *gin.Context) {
 	var accountData models.AccountCreate
 	if err := c.ShouldBindJSON(&accountData); err != nil {
@@ -84,27 +165,47 @@ func (h *AccountHandler) CreateAccount(c *gin.Context) {
 		return
 	}
 
-	// Validate that the created_by user exists
-	userExists, err := h.repo.UserExists(accountData.CreatedBy)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error validating user: " + err.Error()})
+	if !h.fields.validateCreate(c, &accountData.CustomFields) {
 		return
 	}
-	if !userExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID in created_by field"})
+
+	allowDuplicate, err := parseAllowDuplicate(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	account, err := h.repo.CreateAccount(accountData)
+	account, matches, err := h.repo.CreateAccount(accountData, allowDuplicate, recordAccess(c))
+	if errors.Is(err, db.ErrDuplicateRecord) {
+		respondDuplicateRecord(c, err, matches)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
+	c.Header("ETag", accountTag(account))
 	c.JSON(http.StatusCreated, account)
 }
 
-// UpdateAccount updates an existing account
+// BulkAccounts creates, updates and deletes up to 1000 accounts in one request, all or nothing unless the mode is partial.
+// Creates matching existing accounts fail with 409 unless allow_duplicate is set on the request or the operation.
+func (h *AccountHandler) BulkAccounts(c *gin.Context) {
+	check, ok := bulkCustomFieldCheck(c, h.fields, func(data *models.AccountCreate) *map[string]interface{} { return &data.CustomFields })
+	if !ok {
+		return
+	}
+
+	check, ok = bulkDuplicateCheck(c, check)
+	if !ok {
+		return
+	}
+
+	respondBulk(c, h.repo.BulkAccounts, check)
+}
+
+// UpdateAccount updates an existing account owned by the caller
 func (h *AccountHandler) UpdateAccount(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -119,9 +220,17 @@ func (h *AccountHandler) UpdateAccount(c *gin.Context) {
 		return
 	}
 
-	account, err := h.repo.UpdateAccount(id, accountData)
+	if !h.fields.validateChanges(c, &accountData.CustomFields) {
+		return
+	}
+
+	account, err := h.repo.UpdateAccount(id, accountData, parseIfMatch(c), recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetAccountByID, accountTag)
+			return
+		}
+		respondRecordError(c, err)
 		return
 	}
 
@@ -130,10 +239,48 @@ func (h *AccountHandler) UpdateAccount(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", accountTag(account))
 	c.JSON(http.StatusOK, account)
 }
 
-// DeleteAccount deletes an account
+// PatchAccount applies a JSON Merge Patch to an account owned by the caller; null clears a field
+func (h *AccountHandler) PatchAccount(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID format"})
+		return
+	}
+
+	patch, ok := bindMergePatch(c)
+	if !ok {
+		return
+	}
+
+	if !h.fields.validatePatch(c, patch) {
+		return
+	}
+
+	account, err := h.repo.PatchAccount(id, patch, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetAccountByID, accountTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if account == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	c.Header("ETag", accountTag(account))
+	c.JSON(http.StatusOK, account)
+}
+
+// DeleteAccount deletes an account owned by the caller
 func (h *AccountHandler) DeleteAccount(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -142,16 +289,62 @@ func (h *AccountHandler) DeleteAccount(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DeleteAccount(id)
+	err = h.repo.DeleteAccount(id, parseIfMatch(c), recordAccess(c))
 	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetAccountByID, accountTag)
+			return
+		}
 		// Check if the error is "account not found"
 		if err.Error() == "no account found with ID "+idStr {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
 }
+
+// TransferAccount gives an account owned by the caller to another active user
+func (h *AccountHandler) TransferAccount(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID format"})
+		return
+	}
+
+	var transfer models.OwnershipTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.repo.TransferAccount(id, transfer.OwnerID, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account transferred successfully", "owner_id": transfer.OwnerID})
+}
+
+// RestoreAccount takes an account owned by the caller out of the trash
+func (h *AccountHandler) RestoreAccount(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID format"})
+		return
+	}
+
+	err = h.repo.RestoreAccount(id, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account restored successfully"})
+}
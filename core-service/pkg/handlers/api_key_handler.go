@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// APIKeyHandler handles HTTP requests for the current user's API keys
+type APIKeyHandler struct {
+	repo *db.APIKeyRepository
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(repo *db.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{repo: repo}
+}
+
+// RegisterRoutes registers the API key routes to the given router group.
+// Keys can only be managed with a login session, never with another API key.
+func (h *APIKeyHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	apiKeys := rg.Group("/users/me/api-keys")
+	apiKeys.Use(RequireSessionAuth())
+	{
+		apiKeys.GET("", h.GetAPIKeys)
+		apiKeys.POST("", h.CreateAPIKey)
+		apiKeys.DELETE("/:id", h.RevokeAPIKey)
+	}
+}
+
+// GetAPIKeys returns the current user's active API keys
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	keys, err := h.repo.GetAPIKeysByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize keys to empty slice if nil to avoid returning null
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey creates a new API key for the current user. The key is only returned in this response.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var keyData models.APIKeyCreate
+	if err := c.ShouldBindJSON(&keyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if keyData.ExpiresAt != nil && !keyData.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate API key"})
+		return
+	}
+
+	apiKey, err := h.repo.CreateAPIKey(userID, keyData, prefix, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIKeyCreated{APIKey: *apiKey, Key: key})
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID format"})
+		return
+	}
+
+	err = h.repo.RevokeAPIKey(id, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// AuditHandler handles HTTP requests for the audit log of record and user changes
+type AuditHandler struct {
+	repo *db.AuditRepository
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(repo *db.AuditRepository) *AuditHandler {
+	return &AuditHandler{repo: repo}
+}
+
+// RegisterRoutes registers the audit routes to the given router group. The full log and user histories
+// require users:admin; a record's history requires read access to that type of record.
+func (h *AuditHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	admin := RequirePermission(auth.PermUsersAdmin)
+
+	rg.GET("/audit", admin, h.GetAuditEvents)
+	rg.GET("/accounts/:id/history", RequirePermission(auth.PermAccountsRead), h.history("account", "Account"))
+	rg.GET("/contacts/:id/history", RequirePermission(auth.PermContactsRead), h.history("contact", "Contact"))
+	rg.GET("/opportunities/:id/history", RequirePermission(auth.PermOpportunitiesRead), h.history("opportunity", "Opportunity"))
+	rg.GET("/notes/:id/history", RequirePermission(auth.PermNotesRead), h.history("note", "Note"))
+	rg.GET("/users/:id/history", admin, h.history("user", "User"))
+}
+
+// GetAuditEvents returns a page of audit events matching the query-string filters, newest first
+func (h *AuditHandler) GetAuditEvents(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, page, err := h.repo.GetAuditEvents(opts)
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+
+	// Initialize events to empty slice if nil to avoid returning null
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+
+	respondWithList(c, events, opts, page)
+}
+
+// history returns a handler for the audit events of one entity of the given type; records must be visible to the caller
+func (h *AuditHandler) history(entityType, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + entityType + " ID format"})
+			return
+		}
+
+		opts, err := parseListOptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		events, page, err := h.repo.GetEntityHistory(entityType, id, opts, recordAccess(c))
+		if err != nil {
+			respondListError(c, err)
+			return
+		}
+
+		if page == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": name + " not found"})
+			return
+		}
+
+		// Initialize events to empty slice if nil to avoid returning null
+		if events == nil {
+			events = []models.AuditEvent{}
+		}
+
+		respondWithList(c, events, opts, page)
+	}
+}
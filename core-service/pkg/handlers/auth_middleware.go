@@ -2,17 +2,32 @@ package handlers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
 )
 
-// AuthMiddleware validates JWT tokens and sets user information in context
-func AuthMiddleware() gin.HandlerFunc {
+// Authentication methods recorded in the request context under "auth_method"
+const (
+	authMethodJWT    = "jwt"
+	authMethodAPIKey = "api_key"
+)
+
+// AuthMiddleware validates JWT tokens or API keys and sets user information in context.
+// Tokens bound to a session are rejected once that session has been revoked or has expired.
+// API keys are accepted as a Bearer credential or in the X-API-Key header.
+func AuthMiddleware(tokens *auth.TokenService, sessions *db.SessionRepository, apiKeys *db.APIKeyRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKeys, apiKey)
+			return
+		}
+
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -31,58 +46,141 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// Get the token
 		tokenString := parts[1]
+		if auth.IsAPIKey(tokenString) {
+			authenticateAPIKey(c, apiKeys, tokenString)
+			return
+		}
 
 		// Parse and validate the token
-		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
-
+		claims, err := tokens.ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Invalid token: %v", err)})
 			c.Abort()
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-			// Convert string ID to UUID
-			userID, err := uuid.Parse(claims.UserID)
+		// Convert string ID to UUID
+		userID, err := uuid.Parse(claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+			c.Abort()
+			return
+		}
+
+		// Check the token's session is still active
+		if claims.SessionID != "" {
+			sessionID, err := uuid.Parse(claims.SessionID)
 			if err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session ID in token"})
 				c.Abort()
 				return
 			}
 
-			// Set user information in context
-			c.Set("user_id", userID)
-			c.Set("username", claims.Username)
-			c.Set("email", claims.Email)
-			c.Set("role", claims.Role)
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			active, err := sessions.IsSessionActive(sessionID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			if !active {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				c.Abort()
+				return
+			}
+
+			c.Set("session_id", sessionID)
+		}
+
+		// Set user information in context
+		c.Set("user_id", userID)
+		c.Set("username", claims.Username)
+		c.Set("email", claims.Email)
+		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+		c.Set("mfa", claims.MFA)
+		c.Set("auth_method", authMethodJWT)
+		c.Next()
+	}
+}
+
+// authenticateAPIKey validates an API key, checks its scopes against the request and sets its owner in context
+func authenticateAPIKey(c *gin.Context, apiKeys *db.APIKeyRepository, key string) {
+	apiKey, user, err := apiKeys.Authenticate(auth.HashToken(key))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	if apiKey == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		c.Abort()
+		return
+	}
+
+	if !auth.ScopesAllow(apiKey.Scopes, c.Request.Method) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key scope does not allow this request"})
+		c.Abort()
+		return
+	}
+
+	// Usage tracking is best effort and must not fail the request
+	if err := apiKeys.TouchAPIKey(apiKey.ID, c.ClientIP()); err != nil {
+		log.Printf("Failed to record API key usage: %v", err)
+	}
+
+	// Set user information in context
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("email", user.Email)
+	c.Set("role", user.Role)
+	c.Set("permissions", user.Permissions)
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("auth_method", authMethodAPIKey)
+	c.Next()
+}
+
+// RequireSessionAuth middleware rejects requests authenticated with an API key.
+// It guards credential management, so a leaked key cannot be used to mint new keys or change the password.
+func RequireSessionAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") == authMethodAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireMFA middleware rejects token-authenticated users whose role requires multi-factor authentication
+// until their session has completed it. API keys are exempt: they can only be created from such a session.
+func RequireMFA(cfg *auth.MFAConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") == authMethodJWT && cfg.RoleRequiresMFA(c.GetString("role")) && !c.GetBool("mfa") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Multi-factor authentication is required for your role; enroll at /v1/api/users/me/mfa"})
 			c.Abort()
 			return
 		}
+
+		c.Next()
 	}
 }
 
-// RequireRole middleware ensures the user has the required role
-func RequireRole(role string) gin.HandlerFunc {
+// RequirePermission middleware ensures the user's role grants the required permission.
+// Token permissions are fixed when the token is issued; changing a role's permissions ends its users' sessions.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("role")
-		if !exists {
+		if _, exists := c.Get("user_id"); !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			c.Abort()
 			return
 		}
 
-		if userRole != role {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		if !auth.HasPermission(c.GetStringSlice("permissions"), permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required_permission": permission})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// bulkRunner applies the valid operations of a bulk request; it is implemented by the repositories' Bulk methods
+type bulkRunner[C, T any] func(ops []db.BulkOperation[C], atomic bool, access db.Access) []db.BulkResult[T]
+
+// respondBulk validates a bulk request, applies its valid operations with run and responds with the outcome of each
+// operation. Each parsed operation is also validated with check, when given. In atomic mode nothing is applied
+// unless every operation is valid and succeeds.
+func respondBulk[C, T any](c *gin.Context, run bulkRunner[C, T], check func(*db.BulkOperation[C]) error) {
+	var request models.BulkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	atomic := request.Mode != "partial"
+	results := make([]models.BulkItemResult, len(request.Operations))
+	var ops []db.BulkOperation[C]
+	for i, operation := range request.Operations {
+		results[i].Index = i
+		if operation.ID != uuid.Nil {
+			id := operation.ID
+			results[i].ID = &id
+		}
+
+		op, err := parseBulkOperation[C](operation)
+		if err == nil && check != nil {
+			err = check(&op)
+		}
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			continue
+		}
+		op.Index = i
+		ops = append(ops, op)
+	}
+
+	if atomic && len(ops) < len(results) {
+		for i := range results {
+			if results[i].Status == 0 {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = db.ErrBulkAborted.Error()
+			}
+		}
+		writeBulkResponse(c, atomic, results)
+		return
+	}
+
+	for _, result := range run(ops, atomic, recordAccess(c)) {
+		item := &results[result.Index]
+		if result.ID != uuid.Nil {
+			id := result.ID
+			item.ID = &id
+		}
+
+		if result.Err != nil {
+			item.Status = recordErrorStatus(result.Err)
+			item.Error = result.Err.Error()
+			item.Matches = result.Matches
+			continue
+		}
+
+		item.Status = http.StatusOK
+		if request.Operations[result.Index].Op == "create" {
+			item.Status = http.StatusCreated
+		}
+		if result.Record != nil {
+			item.Data = result.Record
+		}
+	}
+
+	writeBulkResponse(c, atomic, results)
+}
+
+// parseBulkOperation checks that a bulk operation names a valid operation and carries what it needs,
+// validating the data of creates like the single-record create endpoint does
+func parseBulkOperation[C any](operation models.BulkOperation) (db.BulkOperation[C], error) {
+	op := db.BulkOperation[C]{Op: operation.Op, ID: operation.ID, AllowDuplicate: operation.AllowDuplicate}
+	if operation.Version != nil {
+		op.IfMatch = db.Versions{*operation.Version}
+	}
+
+	switch operation.Op {
+	case "create":
+		if len(operation.Data) == 0 {
+			return op, errors.New("data is required to create a record")
+		}
+		if err := json.Unmarshal(operation.Data, &op.Create); err != nil {
+			return op, err
+		}
+		if err := binding.Validator.ValidateStruct(&op.Create); err != nil {
+			return op, err
+		}
+	case "update":
+		if operation.ID == uuid.Nil {
+			return op, errors.New("id is required to update a record")
+		}
+		if err := json.Unmarshal(operation.Data, &op.Patch); err != nil || op.Patch == nil {
+			return op, errors.New("data must be a JSON Merge Patch object to update a record")
+		}
+	case "delete":
+		if operation.ID == uuid.Nil {
+			return op, errors.New("id is required to delete a record")
+		}
+	default:
+		return op, errors.New("op must be create, update or delete")
+	}
+
+	return op, nil
+}
+
+// writeBulkResponse responds with the outcome of every operation. Partial requests and atomic requests that
+// succeed return 200; an atomic request that failed returns 422, or 500 when an operation failed unexpectedly.
+func writeBulkResponse(c *gin.Context, atomic bool, results []models.BulkItemResult) {
+	response := models.BulkResponse{Mode: "partial", Results: results}
+	if atomic {
+		response.Mode = "atomic"
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+		if atomic && result.Status >= http.StatusInternalServerError {
+			status = http.StatusInternalServerError
+		} else if atomic && result.Status >= http.StatusBadRequest && status == http.StatusOK {
+			status = http.StatusUnprocessableEntity
+		}
+	}
+
+	c.JSON(status, response)
+}
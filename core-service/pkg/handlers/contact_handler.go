@@ -1,42 +1,62 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
 
 // ContactHandler handles HTTP requests for contacts
 type ContactHandler struct {
-	repo *db.ContactRepository
+	repo   *db.ContactRepository
+	fields customFields
 }
 
 // NewContactHandler creates a new contact handler
-func NewContactHandler(repo *db.ContactRepository) *ContactHandler {
-	return &ContactHandler{repo: repo}
+func NewContactHandler(repo *db.ContactRepository, customFieldRepo *db.CustomFieldRepository) *ContactHandler {
+	return &ContactHandler{repo: repo, fields: customFields{repo: customFieldRepo, entityType: "contact"}}
 }
 
-// RegisterRoutes registers the contact routes to the given router group
+// RegisterRoutes registers the contact routes to the given router group; reads and writes require separate permissions
 func (h *ContactHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermContactsRead)
+	write := RequirePermission(auth.PermContactsWrite)
+
 	contacts := rg.Group("/contacts")
 	{
-		contacts.GET("", h.GetAllContacts)
-		contacts.POST("", h.CreateContact)
-		contacts.GET("/:id", h.GetContactByID)
-		contacts.PUT("/:id", h.UpdateContact)
-		contacts.DELETE("/:id", h.DeleteContact)
-		contacts.GET("/account/:id", h.GetContactsByAccountID)
+		contacts.GET("", read, h.GetAllContacts)
+		contacts.GET("/export", read, h.ExportContacts)
+		contacts.GET("/duplicates", read, h.GetDuplicateContacts)
+		contacts.POST("/merge", write, h.MergeContacts)
+		contacts.POST("", write, h.CreateContact)
+		contacts.POST("/bulk", write, h.BulkContacts)
+		contacts.GET("/:id", read, h.GetContactByID)
+		contacts.PUT("/:id", write, h.UpdateContact)
+		contacts.PATCH("/:id", write, h.PatchContact)
+		contacts.DELETE("/:id", write, h.DeleteContact)
+		contacts.POST("/:id/transfer", write, h.TransferContact)
+		contacts.POST("/:id/restore", write, h.RestoreContact)
+		contacts.GET("/account/:id", read, h.GetContactsByAccountID)
 	}
 }
 
-// GetAllContacts returns all contacts
+// GetAllContacts returns a page of the contacts visible to the caller matching the query-string filters
 func (h *ContactHandler) GetAllContacts(c *gin.Context) {
-	contacts, err := h.repo.GetAllContacts()
+	opts, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contacts, page, err := h.repo.GetAllContacts(opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
 		return
 	}
 
@@ -45,13 +65,67 @@ func (h *ContactHandler) GetAllContacts(c *gin.Context) {
 		contacts = []models.Contact{}
 	}
 
-	// Return in paginated format matching OpenAPI spec
-	c.JSON(http.StatusOK, gin.H{
-		"data":   contacts,
-		"total":  len(contacts),
-		"limit":  100,
-		"offset": 0,
-	})
+	respondWithList(c, contacts, opts, page)
+}
+
+// contactExport lists the columns contacts are exported with, in order
+var contactExport = newExportSpec[models.Contact]("id", "first_name", "last_name", "email", "phone", "title", "account_id", "address", "city",
+	"state", "zip", "country", "owner_id", "visibility", "created_by", "created_at", "updated_by", "updated_at", "version", "custom_fields")
+
+// ExportContacts streams the contacts visible to the caller matching the query-string filters as a CSV, NDJSON or
+// XLSX file, optionally with only the columns listed in the columns parameter; each contact's custom fields are a JSON object
+func (h *ContactHandler) ExportContacts(c *gin.Context) {
+	streamExport(c, "contacts", contactExport, h.repo.ExportContacts)
+}
+
+// GetDuplicateContacts returns a page of the pairs of contacts visible to the caller that are likely duplicates,
+// best match first
+func (h *ContactHandler) GetDuplicateContacts(c *gin.Context) {
+	opts, minScore, err := parseDuplicateOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	candidates, err := h.repo.GetDuplicateCandidates(recordAccess(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	duplicates, total := findDuplicates(candidates, dedupe.ScoreContacts, minScore, opts,
+		func(match dedupe.Match, pair [2]models.Contact) models.ContactDuplicate {
+			return models.ContactDuplicate{Score: match.Score, Reasons: match.Reasons, Records: pair[:]}
+		})
+
+	respondWithList(c, duplicates, opts, &models.PageInfo{Total: total})
+}
+
+// MergeContacts merges duplicate contacts into the survivor, moving their opportunities and notes to it, and returns the survivor
+func (h *ContactHandler) MergeContacts(c *gin.Context) {
+	var request models.MergeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := h.repo.MergeContacts(request, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, request.SurvivorID, h.repo.GetContactByID, contactTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if contact == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+
+	c.Header("ETag", contactTag(contact))
+	c.JSON(http.StatusOK, contact)
 }
 
 // GetContactByID returns a single contact by ID
@@ -63,7 +137,7 @@ func (h *ContactHandler) GetContactByID(c *gin.Context) {
 		return
 	}
 
-	contact, err := h.repo.GetContactByID(id)
+	contact, err := h.repo.GetContactByID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -74,10 +148,14 @@ func (h *ContactHandler) GetContactByID(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, contactTag(contact)) {
+		return
+	}
+
 	c.JSON(http.StatusOK, contact)
 }
 
-// GetContactsByAccountID returns all contacts for a specific account
+// GetContactsByAccountID returns the contacts visible to the caller for a specific account
 func (h *ContactHandler) GetContactsByAccountID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -86,7 +164,7 @@ func (h *ContactHandler) GetContactsByAccountID(c *gin.Context) {
 		return
 	}
 
-	contacts, err := h.repo.GetContactsByAccountID(id)
+	contacts, err := h.repo.GetContactsByAccountID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -100,7 +178,10 @@ func (h *ContactHandler) GetContactsByAccountID(c *gin.Context) {
 	c.JSON(http.StatusOK, contacts)
 }
 
-// CreateContact creates a new contact
+// CreateContact creates a new contact; the caller is recorded as its creator and owner.
+// Under the default duplicate rules, if the caller can already see a contact with the same email address, the same
+// name at the same email domain or the same phone number, the contact is not created and the matches are returned
+// with 409, unless allow_duplicate=true.
 func (h *ContactHandler) CreateContact(c *gin.Context) {
 	var contactData models.ContactCreate
 	if err := c.ShouldBindJSON(&contactData); err != nil {
@@ -108,21 +189,52 @@ func (h *ContactHandler) CreateContact(c *gin.Context) {
 		return
 	}
 
+	if !h.fields.validateCreate(c, &contactData.CustomFields) {
+		return
+	}
+
 	// Validate account ID if provided
 	if contactData.AccountID != uuid.Nil {
 		// Optional: Check if account exists
 	}
 
-	contact, err := h.repo.CreateContact(contactData)
+	allowDuplicate, err := parseAllowDuplicate(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	contact, matches, err := h.repo.CreateContact(contactData, allowDuplicate, recordAccess(c))
+	if errors.Is(err, db.ErrDuplicateRecord) {
+		respondDuplicateRecord(c, err, matches)
+		return
+	}
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.Header("ETag", contactTag(contact))
 	c.JSON(http.StatusCreated, contact)
 }
 
-// UpdateContact updates an existing contact
+// BulkContacts creates, updates and deletes up to 1000 contacts in one request, all or nothing unless the mode is partial.
+// Creates matching existing contacts fail with 409 unless allow_duplicate is set on the request or the operation.
+func (h *ContactHandler) BulkContacts(c *gin.Context) {
+	check, ok := bulkCustomFieldCheck(c, h.fields, func(data *models.ContactCreate) *map[string]interface{} { return &data.CustomFields })
+	if !ok {
+		return
+	}
+
+	check, ok = bulkDuplicateCheck(c, check)
+	if !ok {
+		return
+	}
+
+	respondBulk(c, h.repo.BulkContacts, check)
+}
+
+// UpdateContact updates an existing contact owned by the caller
 func (h *ContactHandler) UpdateContact(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -137,14 +249,59 @@ func (h *ContactHandler) UpdateContact(c *gin.Context) {
 		return
 	}
 
+	if !h.fields.validateChanges(c, &contactData.CustomFields) {
+		return
+	}
+
 	// Validate account ID if provided
 	if contactData.AccountID != uuid.Nil {
 		// Optional: Check if account exists
 	}
 
-	contact, err := h.repo.UpdateContact(id, contactData)
+	contact, err := h.repo.UpdateContact(id, contactData, parseIfMatch(c), recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetContactByID, contactTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if contact == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+
+	c.Header("ETag", contactTag(contact))
+	c.JSON(http.StatusOK, contact)
+}
+
+// PatchContact applies a JSON Merge Patch to a contact owned by the caller; null clears a field
+func (h *ContactHandler) PatchContact(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID format"})
+		return
+	}
+
+	patch, ok := bindMergePatch(c)
+	if !ok {
+		return
+	}
+
+	if !h.fields.validatePatch(c, patch) {
+		return
+	}
+
+	contact, err := h.repo.PatchContact(id, patch, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetContactByID, contactTag)
+			return
+		}
+		respondRecordError(c, err)
 		return
 	}
 
@@ -153,10 +310,11 @@ func (h *ContactHandler) UpdateContact(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", contactTag(contact))
 	c.JSON(http.StatusOK, contact)
 }
 
-// DeleteContact deletes a contact
+// DeleteContact deletes a contact owned by the caller
 func (h *ContactHandler) DeleteContact(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -165,16 +323,62 @@ func (h *ContactHandler) DeleteContact(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DeleteContact(id)
+	err = h.repo.DeleteContact(id, parseIfMatch(c), recordAccess(c))
 	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetContactByID, contactTag)
+			return
+		}
 		// Check if the error is "contact not found"
 		if err.Error() == "no contact found with ID "+idStr {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Contact deleted successfully"})
 }
+
+// TransferContact gives a contact owned by the caller to another active user
+func (h *ContactHandler) TransferContact(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID format"})
+		return
+	}
+
+	var transfer models.OwnershipTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.repo.TransferContact(id, transfer.OwnerID, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact transferred successfully", "owner_id": transfer.OwnerID})
+}
+
+// RestoreContact takes a contact owned by the caller out of the trash
+func (h *ContactHandler) RestoreContact(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID format"})
+		return
+	}
+
+	err = h.repo.RestoreContact(id, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contact restored successfully"})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/customfields"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// CustomFieldHandler handles HTTP requests for the custom fields of accounts, contacts and opportunities
+type CustomFieldHandler struct {
+	repo *db.CustomFieldRepository
+}
+
+// NewCustomFieldHandler creates a new custom field handler
+func NewCustomFieldHandler(repo *db.CustomFieldRepository) *CustomFieldHandler {
+	return &CustomFieldHandler{repo: repo}
+}
+
+// RegisterRoutes registers the custom field routes to the given router group; every user can read the definitions,
+// and managing them requires users:admin
+func (h *CustomFieldHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	admin := RequirePermission(auth.PermUsersAdmin)
+
+	fields := rg.Group("/custom-fields")
+	{
+		fields.GET("", h.GetCustomFields)
+		fields.POST("", admin, h.CreateCustomField)
+		fields.GET("/:id", h.GetCustomFieldByID)
+		fields.PUT("/:id", admin, h.UpdateCustomField)
+		fields.DELETE("/:id", admin, h.DeleteCustomField)
+	}
+}
+
+// GetCustomFields returns the custom fields of every record type, or of the one given as entity_type
+func (h *CustomFieldHandler) GetCustomFields(c *gin.Context) {
+	entityType := c.Query("entity_type")
+	switch entityType {
+	case "", "account", "contact", "opportunity":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entity_type must be account, contact or opportunity"})
+		return
+	}
+
+	fields, err := h.repo.GetCustomFieldDefinitions(entityType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize fields to empty slice if nil to avoid returning null
+	if fields == nil {
+		fields = []models.CustomFieldDefinition{}
+	}
+
+	c.JSON(http.StatusOK, fields)
+}
+
+// GetCustomFieldByID returns a single custom field by ID
+func (h *CustomFieldHandler) GetCustomFieldByID(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom field ID format"})
+		return
+	}
+
+	field, err := h.repo.GetCustomFieldDefinitionByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if field == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, field)
+}
+
+// CreateCustomField defines a new custom field for a record type
+func (h *CustomFieldHandler) CreateCustomField(c *gin.Context) {
+	var fieldData models.CustomFieldDefinitionCreate
+	if err := c.ShouldBindJSON(&fieldData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	defaultValue, err := customfields.ValidateDefinition(fieldData.Name, fieldData.Type, fieldData.Options, fieldData.Default)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fieldData.Default = defaultValue
+
+	field, err := h.repo.CreateCustomFieldDefinition(fieldData, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateCustomField) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, field)
+}
+
+// UpdateCustomField replaces the label, requirement, options and default of a custom field. Making a field
+// required or removing options does not change the values records already have.
+func (h *CustomFieldHandler) UpdateCustomField(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom field ID format"})
+		return
+	}
+
+	var fieldData models.CustomFieldDefinitionUpdate
+	if err := c.ShouldBindJSON(&fieldData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.repo.GetCustomFieldDefinitionByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+		return
+	}
+
+	defaultValue, err := customfields.ValidateDefinition(existing.Name, existing.Type, fieldData.Options, fieldData.Default)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fieldData.Default = defaultValue
+
+	field, err := h.repo.UpdateCustomFieldDefinition(id, fieldData, currentActor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if field == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, field)
+}
+
+// DeleteCustomField deletes a custom field and removes its value from every record
+func (h *CustomFieldHandler) DeleteCustomField(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom field ID format"})
+		return
+	}
+
+	found, err := h.repo.DeleteCustomFieldDefinition(id, currentActor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom field not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Custom field deleted successfully"})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/customfields"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// customFields validates the custom field values of one record type against the type's definitions
+type customFields struct {
+	repo       *db.CustomFieldRepository
+	entityType string
+}
+
+// definitions reads the custom fields of the record type, writing the error response and returning false when that fails
+func (f customFields) definitions(c *gin.Context) ([]models.CustomFieldDefinition, bool) {
+	definitions, err := f.repo.GetCustomFieldDefinitions(f.entityType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return definitions, true
+}
+
+// validateCreate validates the custom field values of a record to create and adds the defaults of the fields left
+// out. It writes the error response and returns false when they are invalid.
+func (f customFields) validateCreate(c *gin.Context, values *map[string]interface{}) bool {
+	definitions, ok := f.definitions(c)
+	if !ok {
+		return false
+	}
+
+	valid, err := customfields.Validate(definitions, *values)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	*values = valid
+	return true
+}
+
+// validateChanges validates the custom field values a full update sets or removes; nil values change nothing.
+// It writes the error response and returns false when they are invalid.
+func (f customFields) validateChanges(c *gin.Context, values *map[string]interface{}) bool {
+	if *values == nil {
+		return true
+	}
+
+	definitions, ok := f.definitions(c)
+	if !ok {
+		return false
+	}
+
+	valid, err := customfields.ValidateChanges(definitions, *values)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	*values = valid
+	return true
+}
+
+// validatePatch validates the custom_fields of a merge patch. It writes the error response and returns false when
+// they are invalid.
+func (f customFields) validatePatch(c *gin.Context, patch models.MergePatch) bool {
+	if _, ok := patch["custom_fields"]; !ok {
+		return true
+	}
+
+	definitions, ok := f.definitions(c)
+	if !ok {
+		return false
+	}
+
+	if err := validatePatchCustomFields(definitions, patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// validatePatchCustomFields validates the custom_fields of a merge patch against the definitions and replaces them
+// with their stored form. A value that is not an object is left for the repository to reject.
+func validatePatchCustomFields(definitions []models.CustomFieldDefinition, patch models.MergePatch) error {
+	raw, ok := patch["custom_fields"]
+	if !ok {
+		return nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil || values == nil {
+		return nil
+	}
+
+	valid, err := customfields.ValidateChanges(definitions, values)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(valid)
+	if err != nil {
+		return err
+	}
+	patch["custom_fields"] = encoded
+	return nil
+}
+
+// bulkCustomFieldCheck returns the check of the custom fields of bulk creates and updates, with values giving the
+// custom field values of a create. It writes the error response and returns false when the definitions cannot be read.
+func bulkCustomFieldCheck[C any](c *gin.Context, f customFields, values func(*C) *map[string]interface{}) (func(*db.BulkOperation[C]) error, bool) {
+	definitions, ok := f.definitions(c)
+	if !ok {
+		return nil, false
+	}
+
+	return func(op *db.BulkOperation[C]) error {
+		switch op.Op {
+		case "create":
+			valid, err := customfields.Validate(definitions, *values(&op.Create))
+			if err != nil {
+				return err
+			}
+			*values(&op.Create) = valid
+		case "update":
+			return validatePatchCustomFields(definitions, op.Patch)
+		}
+		return nil
+	}, true
+}
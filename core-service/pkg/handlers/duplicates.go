@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/dedupe"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// defaultMinDuplicateScore is the score from which a pair of records is reported as duplicates
+const defaultMinDuplicateScore = 40
+
+// parseDuplicateOptions reads the limit, offset and min_score parameters of a duplicate report, which is
+// always sorted by score
+func parseDuplicateOptions(c *gin.Context) (models.ListOptions, int, error) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		return opts, 0, err
+	}
+	if opts.Sort != "" || opts.Cursor != "" {
+		return opts, 0, fmt.Errorf("duplicates are sorted by score and paged with limit and offset")
+	}
+
+	minScore := defaultMinDuplicateScore
+	if value, ok := opts.Filters["min_score"]; ok {
+		minScore, err = strconv.Atoi(value)
+		if err != nil || minScore < 0 || minScore > 100 {
+			return opts, 0, fmt.Errorf("min_score must be an integer from 0 to 100")
+		}
+		delete(opts.Filters, "min_score")
+	}
+
+	for name := range opts.Filters {
+		return opts, 0, fmt.Errorf("unknown parameter %q", name)
+	}
+
+	return opts, minScore, nil
+}
+
+// findDuplicates scores candidate pairs and returns the page of the pairs scoring at least minScore, best first,
+// along with how many pairs do
+func findDuplicates[T, D any](candidates [][2]T, score func(a, b *T) dedupe.Match, minScore int, opts models.ListOptions,
+	build func(dedupe.Match, [2]T) D) ([]D, int) {
+	type scored struct {
+		match dedupe.Match
+		pair  [2]T
+	}
+
+	var matches []scored
+	for _, pair := range candidates {
+		if match := score(&pair[0], &pair[1]); match.Score >= minScore && match.Score > 0 {
+			matches = append(matches, scored{match, pair})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].match.Score > matches[j].match.Score })
+
+	duplicates := []D{}
+	for i := opts.Offset; i < len(matches) && i < opts.Offset+opts.Limit; i++ {
+		duplicates = append(duplicates, build(matches[i].match, matches[i].pair))
+	}
+
+	return duplicates, len(matches)
+}
+
+// parseAllowDuplicate reads the allow_duplicate parameter, which creates a record even if it matches existing ones
+func parseAllowDuplicate(c *gin.Context) (bool, error) {
+	value := c.Query("allow_duplicate")
+	if value == "" {
+		return false, nil
+	}
+	allow, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("allow_duplicate must be true or false")
+	}
+	return allow, nil
+}
+
+// bulkDuplicateCheck extends the check of bulk operations so that the allow_duplicate parameter lets every create
+// of the request match existing records. It writes the error response and returns false when the parameter is invalid.
+func bulkDuplicateCheck[C any](c *gin.Context, check func(*db.BulkOperation[C]) error) (func(*db.BulkOperation[C]) error, bool) {
+	allowDuplicate, err := parseAllowDuplicate(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return func(op *db.BulkOperation[C]) error {
+		op.AllowDuplicate = op.AllowDuplicate || allowDuplicate
+		return check(op)
+	}, true
+}
+
+// respondDuplicateRecord reports that a record was not created because it matches existing records
+func respondDuplicateRecord(c *gin.Context, err error, matches interface{}) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":   err.Error(),
+		"hint":    "pass allow_duplicate=true to create it anyway",
+		"matches": matches,
+	})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// versionTag returns the ETag of a record version
+func versionTag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// accountTag returns an account's ETag. An account read by ID includes its contacts, so their versions are
+// added to the tag after a dash; If-Match only compares the account's own version.
+func accountTag(account *models.Account) string {
+	if len(account.Contacts) == 0 {
+		return versionTag(account.Version)
+	}
+
+	hash := fnv.New64a()
+	for _, contact := range account.Contacts {
+		fmt.Fprintf(hash, "%s:%d;", contact.ID, contact.Version)
+	}
+	return fmt.Sprintf(`"%d-%x"`, account.Version, hash.Sum64())
+}
+
+// contactTag returns a contact's ETag
+func contactTag(contact *models.Contact) string {
+	return versionTag(contact.Version)
+}
+
+// opportunityTag returns an opportunity's ETag
+func opportunityTag(opportunity *models.Opportunity) string {
+	return versionTag(opportunity.Version)
+}
+
+// noteTag returns a note's ETag
+func noteTag(note *models.Note) string {
+	return versionTag(note.Version)
+}
+
+// parseIfMatch reads the record versions a change is conditional on from the If-Match header. Without the
+// header, or with *, the change is unconditional; tags this service did not issue match no version.
+func parseIfMatch(c *gin.Context) db.Versions {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	versions := db.Versions{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil
+		}
+
+		// Proxies may weaken tags, so weak tags are compared like strong ones
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		version, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+		if v, err := strconv.ParseInt(version, 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions
+}
+
+// notModified sets the ETag response header and reports whether the If-None-Match header matches it,
+// in which case it responds with 304 Not Modified
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// respondVersionConflict responds with 412 Precondition Failed and the record's current representation and ETag,
+// read with get as the caller sees it, so the client can reapply its change to it
+func respondVersionConflict[T any](c *gin.Context, id uuid.UUID, get func(uuid.UUID, db.Access) (*T, error), etag func(*T) string) {
+	record, err := get(id, recordAccess(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if record == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	c.Header("ETag", etag(record))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": db.ErrVersionConflict.Error(), "current": record})
+}
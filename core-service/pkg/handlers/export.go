@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/export"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// exportFlushRows is the number of exported rows written between flushes to the client
+const exportFlushRows = 500
+
+// exportColumn is a column of an export and the field of the record it is read from
+type exportColumn struct {
+	name  string
+	index []int
+}
+
+// exportSpec lists the columns records of type T are exported with, in order
+type exportSpec[T any] struct {
+	columns []exportColumn
+}
+
+// newExportSpec creates an export spec for T from the JSON names of its fields.
+// It panics if T has no field with one of the names.
+func newExportSpec[T any](names ...string) exportSpec[T] {
+	fields := map[string][]int{}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = t.Field(i).Index
+	}
+
+	var spec exportSpec[T]
+	for _, name := range names {
+		index, ok := fields[name]
+		if !ok {
+			panic(fmt.Sprintf("%s has no field %q to export", t.Name(), name))
+		}
+		spec.columns = append(spec.columns, exportColumn{name: name, index: index})
+	}
+	return spec
+}
+
+// selectColumns returns the columns named in a comma-separated list, in the order given, or every column when
+// the list is empty
+func (s exportSpec[T]) selectColumns(list string) ([]exportColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return s.columns, nil
+	}
+
+	byName := map[string]exportColumn{}
+	var names []string
+	for _, column := range s.columns {
+		byName[column.name] = column
+		names = append(names, column.name)
+	}
+
+	var selected []exportColumn
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		column, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q; columns are %s", name, strings.Join(names, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is selected more than once", name)
+		}
+		seen[name] = true
+		selected = append(selected, column)
+	}
+	return selected, nil
+}
+
+// streamExport writes the records matching the list filters and sort as a file in the requested format while
+// they are read from the database. Bad options and queries that fail before the first row are reported as
+// JSON errors; failures after the file has started cut the connection so the download is seen to be incomplete.
+func streamExport[T any](c *gin.Context, entity string, spec exportSpec[T],
+	stream func(models.ListOptions, db.Access, func(*T) error) error) {
+	format := c.DefaultQuery("format", "csv")
+	if export.ContentType(format) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format must be one of %s", strings.Join(export.Formats, ", "))})
+		return
+	}
+
+	columns, err := spec.selectColumns(c.Query("columns"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Exports are never paged and their own parameters are not filters
+	delete(opts.Filters, "format")
+	delete(opts.Filters, "columns")
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+
+	var writer export.Writer
+	start := func() error {
+		filename := fmt.Sprintf("%s-%s.%s", entity, time.Now().UTC().Format("2006-01-02"), format)
+		c.Header("Content-Type", export.ContentType(format))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Status(http.StatusOK)
+
+		var err error
+		writer, err = export.NewWriter(format, c.Writer, names)
+		return err
+	}
+
+	rows := 0
+	values := make([]interface{}, len(columns))
+	err = stream(opts, recordAccess(c), func(record *T) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		v := reflect.ValueOf(record).Elem()
+		for i, column := range columns {
+			values[i] = v.FieldByIndex(column.index).Interface()
+		}
+		if err := writer.WriteRow(values); err != nil {
+			return err
+		}
+
+		if rows++; rows%exportFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+
+	if err != nil && writer == nil {
+		respondListError(c, err)
+		return
+	}
+	if err == nil && writer == nil {
+		err = start()
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Printf("Export of %s failed after %d rows: %v", entity, rows, err)
+		abortExport(c)
+	}
+}
+
+// abortExport closes the connection of an export that failed after its response started, so the client sees
+// a broken download rather than a file that looks complete
+func abortExport(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		return // HTTP/2 connections cannot be taken over; the download just ends early
+	}
+	conn.Close()
+}
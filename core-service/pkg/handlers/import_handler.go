@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/imports"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// recordWritePermissions maps each record type that can be imported to the permission needed to create it
+var recordWritePermissions = map[string]string{
+	"account":     auth.PermAccountsWrite,
+	"contact":     auth.PermContactsWrite,
+	"opportunity": auth.PermOpportunitiesWrite,
+}
+
+// ImportHandler handles HTTP requests for importing records from CSV files
+type ImportHandler struct {
+	importer    *imports.Importer
+	repo        *db.ImportRepository
+	maxFileSize int64
+}
+
+// NewImportHandler creates a new import handler
+func NewImportHandler(importer *imports.Importer, repo *db.ImportRepository, config *imports.Config) *ImportHandler {
+	return &ImportHandler{importer: importer, repo: repo, maxFileSize: config.MaxFileSize}
+}
+
+// RegisterRoutes registers the import routes to the given router group. Importing requires write access to the
+// type of record imported; users see their own import jobs, and users with records:admin see every job.
+func (h *ImportHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	jobs := rg.Group("/imports")
+	{
+		jobs.GET("", h.GetImportJobs)
+		jobs.POST("", h.CreateImportJob)
+		jobs.POST("/dry-run", h.DryRunImport)
+		jobs.GET("/:id", h.GetImportJob)
+		jobs.GET("/:id/errors", h.GetImportErrors)
+	}
+}
+
+// readUpload reads a multipart CSV upload: the file, the record type, an optional JSON mapping of columns
+// to fields and whether rows may match existing records. It responds with an error and returns false when the
+// upload is invalid or not permitted.
+func (h *ImportHandler) readUpload(c *gin.Context) (imports.Upload, bool) {
+	var upload imports.Upload
+
+	// Leave room for the other form fields
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxFileSize+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File must not be larger than %d MB", h.maxFileSize>>20)})
+			return upload, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A CSV file is required in the file form field"})
+		return upload, false
+	}
+	if header.Size > h.maxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File must not be larger than %d MB", h.maxFileSize>>20)})
+		return upload, false
+	}
+
+	upload.RecordType = strings.TrimSpace(c.PostForm("record_type"))
+	permission, ok := recordWritePermissions[upload.RecordType]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record type. Must be 'account', 'contact', or 'opportunity'"})
+		return upload, false
+	}
+
+	permissions := c.GetStringSlice("permissions")
+	if !auth.HasPermission(permissions, permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required_permission": permission})
+		return upload, false
+	}
+	upload.LinkAccounts = auth.HasPermission(permissions, auth.PermAccountsRead)
+
+	if value := c.PostForm("allow_duplicate"); value != "" {
+		if upload.AllowDuplicate, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "allow_duplicate must be true or false"})
+			return upload, false
+		}
+	}
+
+	if mapping := c.PostForm("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &upload.Mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be a JSON object of CSV columns to fields"})
+			return upload, false
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the uploaded file"})
+		return upload, false
+	}
+	defer file.Close()
+
+	if upload.Content, err = io.ReadAll(file); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the uploaded file"})
+		return upload, false
+	}
+	upload.FileName = header.Filename
+
+	return upload, true
+}
+
+// respondImportError writes the error response for an upload that could not be checked or queued
+func respondImportError(c *gin.Context, err error) {
+	if errors.Is(err, imports.ErrInvalidImport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// DryRunImport checks every row of a CSV file as it would be imported and reports the errors, without importing it
+func (h *ImportHandler) DryRunImport(c *gin.Context) {
+	upload, ok := h.readUpload(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.importer.DryRun(upload, recordAccess(c))
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateImportJob queues a CSV file to be imported in the background and returns the job to follow its progress
+func (h *ImportHandler) CreateImportJob(c *gin.Context) {
+	upload, ok := h.readUpload(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.importer.Queue(upload, recordAccess(c))
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetImportJobs returns a page of the user's import jobs, newest first
+func (h *ImportHandler) GetImportJobs(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	jobs, page, err := h.repo.GetImportJobs(opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+
+	// Initialize jobs to empty slice if nil to avoid returning null
+	if jobs == nil {
+		jobs = []models.ImportJob{}
+	}
+
+	respondWithList(c, jobs, opts, page)
+}
+
+// GetImportJob returns an import job with its progress
+func (h *ImportHandler) GetImportJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import job ID format"})
+		return
+	}
+
+	job, err := h.repo.GetImportJobByID(id, recordAccess(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetImportErrors downloads the rows of an import job that failed so far as a CSV file, with why each one failed
+func (h *ImportHandler) GetImportErrors(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import job ID format"})
+		return
+	}
+
+	content, rowErrors, err := h.repo.GetImportErrors(id, recordAccess(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if content == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, id))
+	c.Status(http.StatusOK)
+	if err := imports.WriteErrorReport(c.Writer, content, rowErrors); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+)
+
+// JWKSHandler publishes the public keys used to verify access tokens
+type JWKSHandler struct {
+	tokens *auth.TokenService
+}
+
+// NewJWKSHandler creates a new JWKS handler
+func NewJWKSHandler(tokens *auth.TokenService) *JWKSHandler {
+	return &JWKSHandler{tokens: tokens}
+}
+
+// RegisterRoutes registers the well-known key set route to the given router group
+func (h *JWKSHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/.well-known/jwks.json", h.GetJWKS)
+}
+
+// GetJWKS returns the token verification keys as a JSON Web Key Set
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	// Allow verifiers to cache the key set, but pick up rotations within a few minutes
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.tokens.JWKS())
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// reservedListParams are query parameters that control paging and sorting rather than filtering
+var reservedListParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+	"sort":   true,
+	"cursor": true,
+}
+
+// parseListOptions reads limit, offset, sort and field filters from the query string.
+// Every query parameter that is not reserved is treated as a field filter.
+func parseListOptions(c *gin.Context) (models.ListOptions, error) {
+	opts := models.ListOptions{
+		Limit:   defaultListLimit,
+		Sort:    c.Query("sort"),
+		Cursor:  c.Query("cursor"),
+		Filters: map[string]string{},
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return opts, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+		opts.Limit = limit
+	}
+
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return opts, fmt.Errorf("offset must be a non-negative integer")
+		}
+		opts.Offset = offset
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		if reservedListParams[key] || len(values) == 0 || values[0] == "" {
+			continue
+		}
+		opts.Filters[key] = values[0]
+	}
+
+	return opts, nil
+}
+
+// respondWithList writes a page of results in the paginated envelope from the OpenAPI spec.
+// Offset-mode responses include total and offset; cursor-mode responses omit them.
+func respondWithList(c *gin.Context, data interface{}, opts models.ListOptions, page *models.PageInfo) {
+	response := gin.H{
+		"data":        data,
+		"limit":       opts.Limit,
+		"next_cursor": page.NextCursor,
+	}
+	if opts.Cursor == "" {
+		response["total"] = page.Total
+		response["offset"] = opts.Offset
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// respondListError writes the error response for a failed list query
+func respondListError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrInvalidListOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// Failure reasons recorded in the login audit trail
+const (
+	loginFailureInvalidCredentials = "invalid_credentials"
+	loginFailureLocked             = "locked"
+	loginFailureThrottled          = "throttled"
+	loginFailureDeactivated        = "deactivated"
+	loginFailureInvalidMFACode     = "invalid_mfa_code"
+	loginFailureSSODenied          = "sso_denied"
+)
+
+// normalizeLoginEmail returns the key under which failed logins for an email address are counted
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginRetryAfter returns how long the email address and client IP must wait before the next attempt,
+// and the reason to record when that wait is not over yet
+func (h *UserHandler) loginRetryAfter(email, ip string) (time.Duration, string, error) {
+	now := time.Now()
+	var wait time.Duration
+	reason := ""
+
+	checks := []struct {
+		scope string
+		key   string
+	}{
+		{db.ThrottleScopeEmail, email},
+		{db.ThrottleScopeIP, ip},
+	}
+	for _, check := range checks {
+		throttle, err := h.attempts.GetThrottle(check.scope, check.key)
+		if err != nil {
+			return 0, "", err
+		}
+		if throttle == nil {
+			continue
+		}
+
+		retryAfter := h.lockout.RetryAfter(throttle.Failures, throttle.LastFailureAt, throttle.LockedUntil, now)
+		if retryAfter <= wait {
+			continue
+		}
+		wait = retryAfter
+		reason = loginFailureThrottled
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			reason = loginFailureLocked
+		}
+	}
+
+	return wait, reason, nil
+}
+
+// recordLoginFailure counts a failed password check against both the email address and the client IP
+func (h *UserHandler) recordLoginFailure(email, ip string) error {
+	if _, err := h.attempts.RecordFailure(db.ThrottleScopeEmail, email, h.lockout.MaxFailures, h.lockout.FailureWindow, h.lockout.LockoutDuration); err != nil {
+		return err
+	}
+	if _, err := h.attempts.RecordFailure(db.ThrottleScopeIP, ip, h.lockout.IPMaxFailures, h.lockout.FailureWindow, h.lockout.LockoutDuration); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recordLoginAttempt adds the attempt to the audit trail; a failure to record does not fail the login
+func (h *UserHandler) recordLoginAttempt(attempt models.LoginAttempt) {
+	if err := h.attempts.RecordAttempt(attempt); err != nil {
+		log.Printf("Failed to record login attempt for %s: %v", attempt.Email, err)
+	}
+}
+
+// respondLoginThrottled writes the 429 response telling the client when it may try again.
+// The same response is used for known and unknown email addresses.
+func respondLoginThrottled(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, try again later",
+		"retry_after": seconds,
+	})
+}
+
+// UnlockUser lifts a login lockout on the user's email address; admins only
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	user, err := h.repo.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := h.attempts.ClearThrottle(db.ThrottleScopeEmail, normalizeLoginEmail(user.Email)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// GetLoginAttempts returns a page of the login audit trail matching the query-string filters; admins only
+func (h *UserHandler) GetLoginAttempts(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempts, page, err := h.attempts.GetAllLoginAttempts(opts)
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+
+	// Initialize attempts to empty slice if nil to avoid returning null
+	if attempts == nil {
+		attempts = []models.LoginAttempt{}
+	}
+
+	respondWithList(c, attempts, opts, page)
+}
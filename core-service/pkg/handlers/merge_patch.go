@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// bindMergePatch reads a JSON Merge Patch document from the request body, sent as application/merge-patch+json
+// or application/json. It writes the error response and returns false when the body is not a JSON object.
+func bindMergePatch(c *gin.Context) (models.MergePatch, bool) {
+	switch c.ContentType() {
+	case "application/merge-patch+json", "application/json":
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Patches must be sent as application/merge-patch+json"})
+		return nil, false
+	}
+
+	var patch models.MergePatch
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil || patch == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Patch must be a JSON object"})
+		return nil, false
+	}
+
+	return patch, true
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// Limits of the second step of an MFA login
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+)
+
+// startMFAChallenge responds to a correct password of a user with MFA enabled with a challenge token
+// that has to be presented together with a code to complete the login
+func (h *UserHandler) startMFAChallenge(c *gin.Context, user *models.User) {
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	if err := h.mfa.CreateChallenge(user.ID, tokenHash, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_at":   expiresAt,
+	})
+}
+
+// checkMFACode checks a TOTP code or, failing the TOTP format, a recovery code. Accepted codes cannot be used again.
+func (h *UserHandler) checkMFACode(userID uuid.UUID, code string) (bool, error) {
+	if !auth.IsTOTPCode(code) {
+		return h.mfa.UseRecoveryCode(userID, auth.HashRecoveryCode(code))
+	}
+
+	sealed, lastStep, err := h.mfa.GetSecret(userID)
+	if err != nil || sealed == "" {
+		return false, err
+	}
+
+	secret, err := h.mfaConfig.OpenSecret(sealed)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := auth.ValidateTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return false, nil
+	}
+
+	return h.mfa.UseTOTPStep(userID, step)
+}
+
+// VerifyMFA completes a login with the challenge token from Login and a TOTP or recovery code.
+// Wrong codes count as failed logins for the email address and client IP.
+func (h *UserHandler) VerifyMFA(c *gin.Context) {
+	var request models.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, err := h.mfa.GetChallenge(auth.HashToken(request.MFAToken), mfaChallengeMaxAttempts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if challenge == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := h.repo.GetUserByID(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil || !user.IsActive || !user.MFAEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	email := normalizeLoginEmail(user.Email)
+	ip := c.ClientIP()
+	attempt := models.LoginAttempt{Email: email, UserID: user.ID, IPAddress: ip, UserAgent: c.Request.UserAgent()}
+
+	wait, reason, err := h.loginRetryAfter(email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if wait > 0 {
+		attempt.FailureReason = reason
+		h.recordLoginAttempt(attempt)
+		respondLoginThrottled(c, wait)
+		return
+	}
+
+	ok, err := h.checkMFACode(user.ID, request.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !ok {
+		attempt.FailureReason = loginFailureInvalidMFACode
+		h.recordLoginAttempt(attempt)
+		if err := h.mfa.FailChallenge(challenge.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := h.recordLoginFailure(email, ip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	// A challenge completes a single login
+	consumed, err := h.mfa.ConsumeChallenge(challenge.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !consumed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	attempt.Succeeded = true
+	h.recordLoginAttempt(attempt)
+	h.completeLogin(c, user, email, true)
+}
+
+// GetMFAStatus returns whether the authenticated user has MFA enabled, whether their role requires it,
+// and how many recovery codes they have left
+func (h *UserHandler) GetMFAStatus(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	status := models.MFAStatus{
+		Enabled:  user.MFAEnabled,
+		Required: h.mfaConfig.RoleRequiresMFA(user.Role),
+	}
+	if user.MFAEnabled {
+		status.RecoveryCodesRemaining, err = h.mfa.CountRecoveryCodes(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollMFA generates a new TOTP secret for the authenticated user and returns it with an otpauth:// URI
+// for authenticator apps. MFA is only enabled once a code has been confirmed with ActivateMFA.
+func (h *UserHandler) EnrollMFA(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": db.ErrMFAAlreadyEnabled.Error()})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sealed, err := h.mfaConfig.SealSecret(secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfa.SetPendingSecret(userID, sealed); err != nil {
+		if errors.Is(err, db.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(h.mfaConfig.Issuer, user.Email, secret),
+	})
+}
+
+// ActivateMFA enables MFA once the user confirms the enrolled secret with a code from their authenticator app.
+// It returns the recovery codes, which are shown only once, and an access token for the current session,
+// which now counts as having completed MFA.
+func (h *UserHandler) ActivateMFA(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var request models.MFACodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": db.ErrMFAAlreadyEnabled.Error()})
+		return
+	}
+
+	sealed, _, err := h.mfa.GetSecret(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if sealed == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment at /users/me/mfa/enroll first"})
+		return
+	}
+
+	secret, err := h.mfaConfig.OpenSecret(sealed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret, request.Code, time.Now(), 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfa.ActivateMFA(userID, step, hashes); err != nil {
+		if errors.Is(err, db.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"recovery_codes": codes}
+
+	// The user just proved possession of the second factor, so the current session no longer needs to log in again
+	if sessionID, exists := c.Get("session_id"); exists {
+		session, err := h.sessions.MarkSessionMFAVerified(sessionID.(uuid.UUID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if session != nil {
+			tokenString, expiresAt, err := h.tokens.IssueAccessToken(user, session)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+				return
+			}
+			response["token"] = tokenString
+			response["expires_at"] = expiresAt
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes after confirming a current code
+func (h *UserHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := h.confirmMFACode(c)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfa.RegenerateRecoveryCodes(userID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableMFA turns off MFA for the authenticated user after confirming a current code.
+// Users whose role requires MFA cannot turn it off.
+func (h *UserHandler) DisableMFA(c *gin.Context) {
+	if h.mfaConfig.RoleRequiresMFA(c.GetString("role")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Multi-factor authentication is required for your role"})
+		return
+	}
+
+	userID, ok := h.confirmMFACode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.mfa.DisableMFA(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication disabled successfully"})
+}
+
+// confirmMFACode checks the code in the request body of a user with MFA enabled, writing the error response if it fails
+func (h *UserHandler) confirmMFACode(c *gin.Context) (uuid.UUID, bool) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var request models.MFACodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return uuid.Nil, false
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multi-factor authentication is not enabled"})
+		return uuid.Nil, false
+	}
+
+	ok, err := h.checkMFACode(userID, request.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// ResetUserMFA turns off MFA for a user who lost their authenticator and recovery codes, and ends their sessions;
+// admins only. Users whose role requires MFA have to enroll again on their next login.
+func (h *UserHandler) ResetUserMFA(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	if id == c.MustGet("user_id").(uuid.UUID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot reset your own multi-factor authentication"})
+		return
+	}
+
+	err = h.mfa.DisableMFA(id)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.sessions.RevokeAllSessions(id, "mfa_reset"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication reset successfully"})
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
@@ -19,26 +21,39 @@ func NewNoteHandler(repo *db.NoteRepository) *NoteHandler {
 	return &NoteHandler{repo: repo}
 }
 
-// RegisterRoutes registers the note routes to the given router group
+// RegisterRoutes registers the note routes to the given router group; reads and writes require separate permissions
 func (h *NoteHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermNotesRead)
+	write := RequirePermission(auth.PermNotesWrite)
+
 	notes := rg.Group("/notes")
 	{
-		notes.GET("", h.GetAllNotes)
-		notes.POST("", h.CreateNote)
-		notes.GET("/:id", h.GetNoteByID)
-		notes.PUT("/:id", h.UpdateNote)
-		notes.DELETE("/:id", h.DeleteNote)
-		notes.GET("/record/:type/:id", h.GetNotesByRecordID)
-		notes.POST("/associations", h.AddNoteAssociation)
-		notes.DELETE("/associations", h.RemoveNoteAssociation)
+		notes.GET("", read, h.GetAllNotes)
+		notes.GET("/export", read, h.ExportNotes)
+		notes.POST("", write, h.CreateNote)
+		notes.GET("/:id", read, h.GetNoteByID)
+		notes.PUT("/:id", write, h.UpdateNote)
+		notes.PATCH("/:id", write, h.PatchNote)
+		notes.DELETE("/:id", write, h.DeleteNote)
+		notes.POST("/:id/transfer", write, h.TransferNote)
+		notes.POST("/:id/restore", write, h.RestoreNote)
+		notes.GET("/record/:type/:id", read, h.GetNotesByRecordID)
+		notes.POST("/associations", write, h.AddNoteAssociation)
+		notes.DELETE("/associations", write, h.RemoveNoteAssociation)
 	}
 }
 
-// GetAllNotes returns all notes
+// GetAllNotes returns a page of the notes visible to the caller matching the query-string filters
 func (h *NoteHandler) GetAllNotes(c *gin.Context) {
-	notes, err := h.repo.GetAllNotes()
+	opts, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	notes, page, err := h.repo.GetAllNotes(opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
 		return
 	}
 
@@ -47,13 +62,17 @@ func (h *NoteHandler) GetAllNotes(c *gin.Context) {
 		notes = []models.Note{}
 	}
 
-	// Return in paginated format matching OpenAPI spec
-	c.JSON(http.StatusOK, gin.H{
-		"data":   notes,
-		"total":  len(notes),
-		"limit":  100,
-		"offset": 0,
-	})
+	respondWithList(c, notes, opts, page)
+}
+
+// noteExport lists the columns notes are exported with, in order
+var noteExport = newExportSpec[models.Note]("id", "content", "records", "owner_id", "visibility", "created_by", "created_at",
+	"updated_by", "updated_at", "version")
+
+// ExportNotes streams the notes visible to the caller matching the query-string filters as a CSV, NDJSON or
+// XLSX file, optionally with only the columns listed in the columns parameter; each note's records are a JSON list
+func (h *NoteHandler) ExportNotes(c *gin.Context) {
+	streamExport(c, "notes", noteExport, h.repo.ExportNotes)
 }
 
 // GetNoteByID returns a single note by ID
@@ -65,7 +84,7 @@ func (h *NoteHandler) GetNoteByID(c *gin.Context) {
 		return
 	}
 
-	note, err := h.repo.GetNoteByID(id)
+	note, err := h.repo.GetNoteByID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -76,10 +95,14 @@ func (h *NoteHandler) GetNoteByID(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, noteTag(note)) {
+		return
+	}
+
 	c.JSON(http.StatusOK, note)
 }
 
-// GetNotesByRecordID returns all notes for a specific record (account, contact, opportunity)
+// GetNotesByRecordID returns the notes visible to the caller for a specific record (account, contact, opportunity)
 func (h *NoteHandler) GetNotesByRecordID(c *gin.Context) {
 	recordType := c.Param("type")
 	idStr := c.Param("id")
@@ -95,7 +118,7 @@ func (h *NoteHandler) GetNotesByRecordID(c *gin.Context) {
 		return
 	}
 
-	notes, err := h.repo.GetNotesByRecordID(id, recordType)
+	notes, err := h.repo.GetNotesByRecordID(id, recordType, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -109,7 +132,7 @@ func (h *NoteHandler) GetNotesByRecordID(c *gin.Context) {
 	c.JSON(http.StatusOK, notes)
 }
 
-// CreateNote creates a new note
+// CreateNote creates a new note; the caller is recorded as its creator and owner
 func (h *NoteHandler) CreateNote(c *gin.Context) {
 	var noteData models.NoteCreate
 	if err := c.ShouldBindJSON(&noteData); err != nil {
@@ -131,16 +154,17 @@ func (h *NoteHandler) CreateNote(c *gin.Context) {
 		}
 	}
 
-	note, err := h.repo.CreateNote(noteData)
+	note, err := h.repo.CreateNote(noteData, currentActor(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", noteTag(note))
 	c.JSON(http.StatusCreated, note)
 }
 
-// UpdateNote updates an existing note
+// UpdateNote updates an existing note owned by the caller
 func (h *NoteHandler) UpdateNote(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -155,9 +179,46 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	note, err := h.repo.UpdateNote(id, noteData)
+	note, err := h.repo.UpdateNote(id, noteData, parseIfMatch(c), recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetNoteByID, noteTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if note == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+
+	c.Header("ETag", noteTag(note))
+	c.JSON(http.StatusOK, note)
+}
+
+// PatchNote applies a JSON Merge Patch to a note owned by the caller; null clears a field
+func (h *NoteHandler) PatchNote(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID format"})
+		return
+	}
+
+	patch, ok := bindMergePatch(c)
+	if !ok {
+		return
+	}
+
+	note, err := h.repo.PatchNote(id, patch, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetNoteByID, noteTag)
+			return
+		}
+		respondRecordError(c, err)
 		return
 	}
 
@@ -166,10 +227,11 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", noteTag(note))
 	c.JSON(http.StatusOK, note)
 }
 
-// DeleteNote deletes a note
+// DeleteNote deletes a note owned by the caller
 func (h *NoteHandler) DeleteNote(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -178,21 +240,25 @@ func (h *NoteHandler) DeleteNote(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DeleteNote(id)
+	err = h.repo.DeleteNote(id, parseIfMatch(c), recordAccess(c))
 	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetNoteByID, noteTag)
+			return
+		}
 		// Check if the error is "note not found"
 		if err.Error() == "no note found with ID "+idStr {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
 }
 
-// AddNoteAssociation adds an association between a note and a record
+// AddNoteAssociation adds an association between a note owned by the caller and a record
 func (h *NoteHandler) AddNoteAssociation(c *gin.Context) {
 	var association models.AddNoteAssociation
 	if err := c.ShouldBindJSON(&association); err != nil {
@@ -206,16 +272,16 @@ func (h *NoteHandler) AddNoteAssociation(c *gin.Context) {
 		return
 	}
 
-	err := h.repo.AddNoteAssociation(association)
+	err := h.repo.AddNoteAssociation(association, recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Note association added successfully"})
 }
 
-// RemoveNoteAssociation removes an association between a note and a record
+// RemoveNoteAssociation removes an association between a note owned by the caller and a record
 func (h *NoteHandler) RemoveNoteAssociation(c *gin.Context) {
 	var association models.AddNoteAssociation
 	if err := c.ShouldBindJSON(&association); err != nil {
@@ -229,16 +295,53 @@ func (h *NoteHandler) RemoveNoteAssociation(c *gin.Context) {
 		return
 	}
 
-	err := h.repo.RemoveNoteAssociation(association)
+	err := h.repo.RemoveNoteAssociation(association, recordAccess(c))
 	if err != nil {
-		// Check if the error is "association not found"
-		if err.Error() == "no association found for note ID "+association.NoteID.String()+" and record ID "+association.RecordID.String() {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Note association removed successfully"})
 }
+
+// TransferNote gives a note owned by the caller to another active user
+func (h *NoteHandler) TransferNote(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID format"})
+		return
+	}
+
+	var transfer models.OwnershipTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.repo.TransferNote(id, transfer.OwnerID, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Note transferred successfully", "owner_id": transfer.OwnerID})
+}
+
+// RestoreNote takes a note owned by the caller out of the trash
+func (h *NoteHandler) RestoreNote(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID format"})
+		return
+	}
+
+	err = h.repo.RestoreNote(id, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Note restored successfully"})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// oidcLoginTTL is how long a user has to sign in at the provider after starting a single sign-on login
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCHandler handles single sign-on logins through an OpenID Connect provider.
+// Sessions are started through the user handler, so SSO logins get the same tokens and MFA checks as password logins.
+type OIDCHandler struct {
+	users    *UserHandler
+	repo     *db.OIDCRepository
+	provider *auth.OIDCProvider
+}
+
+// NewOIDCHandler creates a new OIDC handler
+func NewOIDCHandler(users *UserHandler, repo *db.OIDCRepository, provider *auth.OIDCProvider) *OIDCHandler {
+	return &OIDCHandler{
+		users:    users,
+		repo:     repo,
+		provider: provider,
+	}
+}
+
+// RegisterRoutes registers the single sign-on routes (no auth required)
+func (h *OIDCHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	oidc := rg.Group("/auth/oidc")
+	{
+		oidc.GET("/login", h.StartLogin)
+		oidc.POST("/callback", h.Callback)
+	}
+}
+
+// StartLogin begins an authorization code login with PKCE. It returns the provider URL to send the browser to
+// and the state the client must compare with the state on the redirect back before calling Callback.
+func (h *OIDCHandler) StartLogin(c *gin.Context) {
+	state, stateHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	nonce, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	codeVerifier, codeChallenge, err := auth.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	authorizationURL, err := h.provider.AuthCodeURL(c.Request.Context(), state, nonce, codeChallenge)
+	if err != nil {
+		log.Printf("Failed to start single sign-on: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The identity provider is unavailable"})
+		return
+	}
+
+	expiresAt := time.Now().Add(oidcLoginTTL)
+	if err := h.repo.CreateLoginState(stateHash, codeVerifier, nonce, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": authorizationURL,
+		"state":             state,
+		"expires_at":        expiresAt,
+	})
+}
+
+// Callback completes a single sign-on login with the authorization code and state from the provider's redirect.
+// Users are provisioned on their first login, or linked to an existing user with the same verified email address,
+// and their role is taken from the provider's claims when a role mapping is configured.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	var request models.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codeVerifier, nonce, err := h.repo.ConsumeLoginState(auth.HashToken(request.State))
+	if err != nil {
+		if errors.Is(err, db.ErrOIDCStateInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	identity, err := h.provider.Exchange(c.Request.Context(), request.Code, codeVerifier, nonce)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed: " + err.Error()})
+		return
+	}
+
+	// The email address identifies the user in the CRM, so the email scope is required
+	if identity.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The identity provider did not supply an email address"})
+		return
+	}
+
+	email := normalizeLoginEmail(identity.Email)
+	attempt := models.LoginAttempt{Email: email, IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+
+	cfg := h.provider.Config()
+	role, allowed := cfg.MapRole(identity.RoleValues)
+	if !allowed {
+		attempt.FailureReason = loginFailureSSODenied
+		h.users.recordLoginAttempt(attempt)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is not permitted to use this application"})
+		return
+	}
+
+	user, err := h.findOrProvisionUser(identity, role, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user with this email address already exists; sign in with your password"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempt.UserID = user.ID
+	if !user.IsActive {
+		attempt.FailureReason = loginFailureDeactivated
+		h.users.recordLoginAttempt(attempt)
+		c.JSON(http.StatusForbidden, gin.H{"error": db.ErrUserDeactivated.Error()})
+		return
+	}
+
+	// Keep the role in line with the provider; like any role change it ends the user's other sessions
+	if len(cfg.RoleMapping) > 0 && user.Role != role {
+		updated, err := h.users.repo.UpdateUser(user.ID, models.UserUpdate{Role: role}, currentActor(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := h.users.sessions.RevokeAllSessions(user.ID, "role_changed"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		user = updated
+	}
+
+	if err := h.repo.RecordIdentityLogin(identity.Issuer, identity.Subject, identity.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A second factor done at the provider counts; otherwise users with MFA enabled still enter their code
+	if user.MFAEnabled && !identity.MFA {
+		h.users.startMFAChallenge(c, user)
+		return
+	}
+
+	attempt.Succeeded = true
+	h.users.recordLoginAttempt(attempt)
+	h.users.completeLogin(c, user, normalizeLoginEmail(user.Email), identity.MFA)
+}
+
+// findOrProvisionUser returns the user linked to the identity, linking or creating one on the first login.
+// An existing user is only linked when the provider has verified that the email address belongs to the person.
+func (h *OIDCHandler) findOrProvisionUser(identity *auth.OIDCIdentity, role string, actor db.Actor) (*models.User, error) {
+	user, err := h.repo.GetUserByIdentity(identity.Issuer, identity.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	existing, err := h.users.repo.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		if !identity.EmailVerified {
+			return nil, db.ErrDuplicateUser
+		}
+		if err := h.repo.LinkIdentity(existing.ID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	// Usernames are unique, so fall back to a random suffix when the preferred one is taken
+	base := oidcUsername(identity)
+	username := base
+	for i := 0; ; i++ {
+		user, err = h.repo.ProvisionUser(username, identity.Email, role, identity.EmailVerified, identity.Issuer, identity.Subject, actor)
+		if !errors.Is(err, db.ErrDuplicateUser) || i == 2 {
+			return user, err
+		}
+
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		username = base + "-" + hex.EncodeToString(suffix)
+	}
+}
+
+// oidcUsername picks a username for a provisioned user from the preferred_username claim or the email address
+func oidcUsername(identity *auth.OIDCIdentity) string {
+	username := identity.Username
+	if username == "" {
+		username, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if len(username) > 90 {
+		username = username[:90]
+	}
+	return username
+}
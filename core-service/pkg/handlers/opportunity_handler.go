@@ -1,42 +1,59 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
 
 // OpportunityHandler handles HTTP requests for opportunities
 type OpportunityHandler struct {
-	repo *db.OpportunityRepository
+	repo   *db.OpportunityRepository
+	fields customFields
 }
 
 // NewOpportunityHandler creates a new opportunity handler
-func NewOpportunityHandler(repo *db.OpportunityRepository) *OpportunityHandler {
-	return &OpportunityHandler{repo: repo}
+func NewOpportunityHandler(repo *db.OpportunityRepository, customFieldRepo *db.CustomFieldRepository) *OpportunityHandler {
+	return &OpportunityHandler{repo: repo, fields: customFields{repo: customFieldRepo, entityType: "opportunity"}}
 }
 
-// RegisterRoutes registers the opportunity routes to the given router group
+// RegisterRoutes registers the opportunity routes to the given router group; reads and writes require separate permissions
 func (h *OpportunityHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermOpportunitiesRead)
+	write := RequirePermission(auth.PermOpportunitiesWrite)
+
 	opportunities := rg.Group("/opportunities")
 	{
-		opportunities.GET("", h.GetAllOpportunities)
-		opportunities.POST("", h.CreateOpportunity)
-		opportunities.GET("/:id", h.GetOpportunityByID)
-		opportunities.PUT("/:id", h.UpdateOpportunity)
-		opportunities.DELETE("/:id", h.DeleteOpportunity)
-		opportunities.GET("/account/:id", h.GetOpportunitiesByAccountID)
+		opportunities.GET("", read, h.GetAllOpportunities)
+		opportunities.GET("/export", read, h.ExportOpportunities)
+		opportunities.POST("", write, h.CreateOpportunity)
+		opportunities.POST("/bulk", write, h.BulkOpportunities)
+		opportunities.GET("/:id", read, h.GetOpportunityByID)
+		opportunities.PUT("/:id", write, h.UpdateOpportunity)
+		opportunities.PATCH("/:id", write, h.PatchOpportunity)
+		opportunities.DELETE("/:id", write, h.DeleteOpportunity)
+		opportunities.POST("/:id/transfer", write, h.TransferOpportunity)
+		opportunities.POST("/:id/restore", write, h.RestoreOpportunity)
+		opportunities.GET("/account/:id", read, h.GetOpportunitiesByAccountID)
 	}
 }
 
-// GetAllOpportunities returns all opportunities
+// GetAllOpportunities returns a page of the opportunities visible to the caller matching the query-string filters
 func (h *OpportunityHandler) GetAllOpportunities(c *gin.Context) {
-	opportunities, err := h.repo.GetAllOpportunities()
+	opts, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opportunities, page, err := h.repo.GetAllOpportunities(opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
 		return
 	}
 
@@ -45,13 +62,17 @@ func (h *OpportunityHandler) GetAllOpportunities(c *gin.Context) {
 		opportunities = []models.Opportunity{}
 	}
 
-	// Return in paginated format matching OpenAPI spec
-	c.JSON(http.StatusOK, gin.H{
-		"data":   opportunities,
-		"total":  len(opportunities),
-		"limit":  100,
-		"offset": 0,
-	})
+	respondWithList(c, opportunities, opts, page)
+}
+
+// opportunityExport lists the columns opportunities are exported with, in order
+var opportunityExport = newExportSpec[models.Opportunity]("id", "opportunity_name", "account_id", "primary_contact_id", "stage", "amount",
+	"close_date", "probability", "owner_id", "visibility", "created_by", "created_at", "updated_by", "updated_at", "version", "custom_fields")
+
+// ExportOpportunities streams the opportunities visible to the caller matching the query-string filters as a CSV, NDJSON or
+// XLSX file, optionally with only the columns listed in the columns parameter; each opportunity's custom fields are a JSON object
+func (h *OpportunityHandler) ExportOpportunities(c *gin.Context) {
+	streamExport(c, "opportunities", opportunityExport, h.repo.ExportOpportunities)
 }
 
 // GetOpportunityByID returns a single opportunity by ID
@@ -63,7 +84,7 @@ func (h *OpportunityHandler) GetOpportunityByID(c *gin.Context) {
 		return
 	}
 
-	opportunity, err := h.repo.GetOpportunityByID(id)
+	opportunity, err := h.repo.GetOpportunityByID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -74,10 +95,14 @@ func (h *OpportunityHandler) GetOpportunityByID(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, opportunityTag(opportunity)) {
+		return
+	}
+
 	c.JSON(http.StatusOK, opportunity)
 }
 
-// GetOpportunitiesByAccountID returns all opportunities for a specific account
+// GetOpportunitiesByAccountID returns the opportunities visible to the caller for a specific account
 func (h *OpportunityHandler) GetOpportunitiesByAccountID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -86,7 +111,7 @@ func (h *OpportunityHandler) GetOpportunitiesByAccountID(c *gin.Context) {
 		return
 	}
 
-	opportunities, err := h.repo.GetOpportunitiesByAccountID(id)
+	opportunities, err := h.repo.GetOpportunitiesByAccountID(id, recordAccess(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -100,7 +125,7 @@ func (h *OpportunityHandler) GetOpportunitiesByAccountID(c *gin.Context) {
 	c.JSON(http.StatusOK, opportunities)
 }
 
-// CreateOpportunity creates a new opportunity
+// CreateOpportunity creates a new opportunity; the caller is recorded as its creator and owner
 func (h *OpportunityHandler) CreateOpportunity(c *gin.Context) {
 	var opportunityData models.OpportunityCreate
 	if err := c.ShouldBindJSON(&opportunityData); err != nil {
@@ -108,16 +133,31 @@ func (h *OpportunityHandler) CreateOpportunity(c *gin.Context) {
 		return
 	}
 
-	opportunity, err := h.repo.CreateOpportunity(opportunityData)
+	if !h.fields.validateCreate(c, &opportunityData.CustomFields) {
+		return
+	}
+
+	opportunity, err := h.repo.CreateOpportunity(opportunityData, recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
+	c.Header("ETag", opportunityTag(opportunity))
 	c.JSON(http.StatusCreated, opportunity)
 }
 
-// UpdateOpportunity updates an existing opportunity
+// BulkOpportunities creates, updates and deletes up to 1000 opportunities in one request, all or nothing unless the mode is partial
+func (h *OpportunityHandler) BulkOpportunities(c *gin.Context) {
+	check, ok := bulkCustomFieldCheck(c, h.fields, func(data *models.OpportunityCreate) *map[string]interface{} { return &data.CustomFields })
+	if !ok {
+		return
+	}
+
+	respondBulk(c, h.repo.BulkOpportunities, check)
+}
+
+// UpdateOpportunity updates an existing opportunity owned by the caller
 func (h *OpportunityHandler) UpdateOpportunity(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -132,9 +172,54 @@ func (h *OpportunityHandler) UpdateOpportunity(c *gin.Context) {
 		return
 	}
 
-	opportunity, err := h.repo.UpdateOpportunity(id, opportunityData)
+	if !h.fields.validateChanges(c, &opportunityData.CustomFields) {
+		return
+	}
+
+	opportunity, err := h.repo.UpdateOpportunity(id, opportunityData, parseIfMatch(c), recordAccess(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetOpportunityByID, opportunityTag)
+			return
+		}
+		respondRecordError(c, err)
+		return
+	}
+
+	if opportunity == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Opportunity not found"})
+		return
+	}
+
+	c.Header("ETag", opportunityTag(opportunity))
+	c.JSON(http.StatusOK, opportunity)
+}
+
+// PatchOpportunity applies a JSON Merge Patch to an opportunity owned by the caller; null clears a field
+func (h *OpportunityHandler) PatchOpportunity(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid opportunity ID format"})
+		return
+	}
+
+	patch, ok := bindMergePatch(c)
+	if !ok {
+		return
+	}
+
+	if !h.fields.validatePatch(c, patch) {
+		return
+	}
+
+	opportunity, err := h.repo.PatchOpportunity(id, patch, parseIfMatch(c), recordAccess(c))
+	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetOpportunityByID, opportunityTag)
+			return
+		}
+		respondRecordError(c, err)
 		return
 	}
 
@@ -143,10 +228,11 @@ func (h *OpportunityHandler) UpdateOpportunity(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", opportunityTag(opportunity))
 	c.JSON(http.StatusOK, opportunity)
 }
 
-// DeleteOpportunity deletes an opportunity
+// DeleteOpportunity deletes an opportunity owned by the caller
 func (h *OpportunityHandler) DeleteOpportunity(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
@@ -155,16 +241,62 @@ func (h *OpportunityHandler) DeleteOpportunity(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DeleteOpportunity(id)
+	err = h.repo.DeleteOpportunity(id, parseIfMatch(c), recordAccess(c))
 	if err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			respondVersionConflict(c, id, h.repo.GetOpportunityByID, opportunityTag)
+			return
+		}
 		// Check if the error is "opportunity not found"
 		if err.Error() == "no opportunity found with ID "+idStr {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondRecordError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Opportunity deleted successfully"})
 }
+
+// TransferOpportunity gives an opportunity owned by the caller to another active user
+func (h *OpportunityHandler) TransferOpportunity(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid opportunity ID format"})
+		return
+	}
+
+	var transfer models.OwnershipTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.repo.TransferOpportunity(id, transfer.OwnerID, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Opportunity transferred successfully", "owner_id": transfer.OwnerID})
+}
+
+// RestoreOpportunity takes an opportunity owned by the caller out of the trash
+func (h *OpportunityHandler) RestoreOpportunity(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid opportunity ID format"})
+		return
+	}
+
+	err = h.repo.RestoreOpportunity(id, recordAccess(c))
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Opportunity restored successfully"})
+}
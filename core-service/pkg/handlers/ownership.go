@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+)
+
+// recordAccess returns the record access rules for the authenticated caller; records:admin sees and changes every record
+func recordAccess(c *gin.Context) db.Access {
+	return db.Access{
+		Actor:      currentActor(c),
+		AllRecords: auth.HasPermission(c.GetStringSlice("permissions"), auth.PermRecordsAdmin),
+	}
+}
+
+// respondRecordError writes the error response for a failed record change
+func respondRecordError(c *gin.Context, err error) {
+	c.JSON(recordErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// recordErrorStatus returns the HTTP status for a failed record change
+func recordErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotRecordOwner):
+		return http.StatusForbidden
+	case errors.Is(err, db.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrInvalidOwner), errors.Is(err, db.ErrInvalidPatch), errors.Is(err, db.ErrInvalidRecord),
+		errors.Is(err, db.ErrInvalidMerge):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrParentInTrash), errors.Is(err, db.ErrDuplicateRecord):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, db.ErrBulkAborted):
+		return http.StatusFailedDependency
+	default:
+		return http.StatusInternalServerError
+	}
+}
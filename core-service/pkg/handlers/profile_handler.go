@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// GetCurrentUser returns the profile of the authenticated user
+func (h *UserHandler) GetCurrentUser(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateCurrentUser updates the username and email of the authenticated user.
+// A new email address has to be verified again.
+func (h *UserHandler) UpdateCurrentUser(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var profileData models.UserProfileUpdate
+	if err := c.ShouldBindJSON(&profileData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	user, err := h.repo.UpdateUser(userID, models.UserUpdate{
+		Username: profileData.Username,
+		Email:    profileData.Email,
+	}, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Email != existing.Email {
+		if err := h.sendEmailVerification(user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword changes the authenticated user's password and signs out their other sessions
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var passwordData models.PasswordChange
+	if err := c.ShouldBindJSON(&passwordData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.repo.ChangePassword(userID, passwordData.CurrentPassword, passwordData.NewPassword, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep the session making the request; every other device has to log in again
+	if sessionID, exists := c.Get("session_id"); exists {
+		_, err = h.sessions.RevokeOtherSessions(userID, sessionID.(uuid.UUID), "password_changed")
+	} else {
+		_, err = h.sessions.RevokeAllSessions(userID, "password_changed")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// ForgotPassword emails a password reset link to the user with the given address.
+// The response is the same whether or not the address belongs to a user, so it cannot be used to discover accounts.
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var request models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.repo.GetUserByEmail(request.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Send in the background so response time does not reveal whether the account exists
+	if user != nil && user.IsActive {
+		go func() {
+			if err := h.sendPasswordReset(user); err != nil {
+				log.Printf("Failed to send password reset email to user %s: %v", user.ID, err)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an account, a password reset email has been sent"})
+}
+
+// ResetPassword sets a new password using the token from a reset email, signs out every session and lifts any login lockout
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var request models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, email, err := h.userTokens.ConsumeToken(db.TokenPurposePasswordReset, auth.HashToken(request.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrUserTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.repo.SetPassword(userID, request.NewPassword, db.Actor{UserID: userID, RequestID: c.GetString("request_id")}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.sessions.RevokeAllSessions(userID, "password_reset"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Proving ownership of the email address also lifts a lockout caused by failed logins
+	if err := h.attempts.ClearThrottle(db.ThrottleScopeEmail, normalizeLoginEmail(email)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// VerifyEmail confirms the user's email address using the token from a verification email
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	var request models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, email, err := h.userTokens.ConsumeToken(db.TokenPurposeEmailVerification, auth.HashToken(request.Token))
+	if err != nil {
+		if errors.Is(err, db.ErrUserTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	verified, err := h.repo.MarkEmailVerified(userID, email, db.Actor{UserID: userID, RequestID: c.GetString("request_id")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address has changed since the verification email was sent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+// ResendVerification sends a new verification email to the current user
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already verified"})
+		return
+	}
+
+	if err := h.sendEmailVerification(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// sendPasswordReset issues a password reset token and emails it to the user
+func (h *UserHandler) sendPasswordReset(user *models.User) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := h.userTokens.CreateToken(user.ID, db.TokenPurposePasswordReset, user.Email, hash, time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+
+	return h.notifier.SendPasswordReset(user.Email, user.Username, token, passwordResetTTL)
+}
+
+// sendEmailVerification issues an email verification token and emails it to the user's current address
+func (h *UserHandler) sendEmailVerification(user *models.User) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := h.userTokens.CreateToken(user.ID, db.TokenPurposeEmailVerification, user.Email, hash, time.Now().Add(emailVerificationTTL)); err != nil {
+		return err
+	}
+
+	return h.notifier.SendEmailVerification(user.Email, user.Username, token, emailVerificationTTL)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+)
+
+// requestIDPattern limits client-supplied request IDs to short printable tokens
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID tags every request with an ID, taken from the X-Request-ID header when the client sends a valid one.
+// The ID is returned in the X-Request-ID response header and recorded with the audit events the request causes.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+// currentActor returns who is making the request for the audit log; requests without a signed-in user are
+// recorded as made by the system
+func currentActor(c *gin.Context) db.Actor {
+	actor := db.Actor{RequestID: c.GetString("request_id")}
+	if userID, ok := c.Get("user_id"); ok {
+		actor.UserID = userID.(uuid.UUID)
+	}
+	return actor
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// roleNamePattern restricts role names to lowercase identifiers such as sales_manager
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// RoleHandler handles HTTP requests for roles and the permissions they grant
+type RoleHandler struct {
+	repo     *db.RoleRepository
+	sessions *db.SessionRepository
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(repo *db.RoleRepository, sessions *db.SessionRepository) *RoleHandler {
+	return &RoleHandler{
+		repo:     repo,
+		sessions: sessions,
+	}
+}
+
+// RegisterRoutes registers the role management routes to the given router group, which must require users:admin
+func (h *RoleHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/permissions", h.GetPermissions)
+
+	roles := rg.Group("/roles")
+	{
+		roles.GET("", h.GetAllRoles)
+		roles.POST("", h.CreateRole)
+		roles.GET("/:name", h.GetRole)
+		roles.PUT("/:name", h.UpdateRole)
+		roles.DELETE("/:name", h.DeleteRole)
+	}
+}
+
+// GetPermissions returns the catalog of permissions roles can grant
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, auth.Permissions)
+}
+
+// GetAllRoles returns every role with its permissions and number of users
+func (h *RoleHandler) GetAllRoles(c *gin.Context) {
+	roles, err := h.repo.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize roles to empty slice if nil to avoid returning null
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetRole returns a role by name
+func (h *RoleHandler) GetRole(c *gin.Context) {
+	role, err := h.repo.GetRole(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if role == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole creates a custom role
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var roleData models.RoleCreate
+	if err := c.ShouldBindJSON(&roleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !roleNamePattern.MatchString(roleData.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role names must start with a lowercase letter and contain only lowercase letters, digits, '_' and '-'"})
+		return
+	}
+
+	if err := validatePermissions(roleData.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.repo.CreateRole(roleData)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateRole) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole updates a custom role. Changing its permissions ends the sessions of its users,
+// because access tokens carry the permissions they were issued with.
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	name := c.Param("name")
+
+	var roleData models.RoleUpdate
+	if err := c.ShouldBindJSON(&roleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if roleData.Permissions != nil {
+		if err := validatePermissions(roleData.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	role, err := h.repo.UpdateRole(name, roleData)
+	if err != nil {
+		if errors.Is(err, db.ErrBuiltinRole) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if role == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if roleData.Permissions != nil {
+		if _, err := h.sessions.RevokeSessionsByRole(name, "role_changed"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes a custom role that no user has
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+
+	err := h.repo.DeleteRole(name)
+	if err != nil {
+		if errors.Is(err, db.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, db.ErrBuiltinRole) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, db.ErrRoleInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Role is assigned to users; give them another role first"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// validatePermissions checks that every permission is in the catalog
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !auth.ValidPermission(permission) {
+			return fmt.Errorf("unknown permission %q; see /v1/api/permissions", permission)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// recordReadPermissions maps each record type to the permission needed to see it in search results and the trash
+var recordReadPermissions = map[string]string{
+	"account":     auth.PermAccountsRead,
+	"contact":     auth.PermContactsRead,
+	"opportunity": auth.PermOpportunitiesRead,
+	"note":        auth.PermNotesRead,
+}
+
+// SearchHandler handles HTTP requests for full-text search
+type SearchHandler struct {
+	repo *db.SearchRepository
+}
+
+// NewSearchHandler creates a new search handler
+func NewSearchHandler(repo *db.SearchRepository) *SearchHandler {
+	return &SearchHandler{repo: repo}
+}
+
+// RegisterRoutes registers the search routes to the given router group
+func (h *SearchHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/search", h.Search)
+}
+
+// Search returns a page of ranked records visible to the user, of every type they may read, matching the q parameter.
+// The optional type parameter restricts results to a comma-separated list of record types.
+func (h *SearchHandler) Search(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The search text and record types are not filters
+	delete(opts.Filters, "q")
+	delete(opts.Filters, "type")
+
+	permissions := c.GetStringSlice("permissions")
+
+	var recordTypes []string
+	for _, recordType := range db.SearchRecordTypes {
+		if auth.HasPermission(permissions, recordReadPermissions[recordType]) {
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+
+	if typeStr := c.Query("type"); typeStr != "" {
+		recordTypes = nil
+		for _, recordType := range strings.Split(typeStr, ",") {
+			recordType = strings.TrimSpace(recordType)
+			if recordType != "account" && recordType != "contact" && recordType != "opportunity" && recordType != "note" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record type. Must be 'account', 'contact', 'opportunity', or 'note'"})
+				return
+			}
+			if !auth.HasPermission(permissions, recordReadPermissions[recordType]) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required_permission": recordReadPermissions[recordType]})
+				return
+			}
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+
+	if len(recordTypes) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	results, page, err := h.repo.Search(text, recordTypes, opts, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+
+	// Initialize results to empty slice if nil to avoid returning null
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+
+	respondWithList(c, results, opts, page)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// respondWithTokens issues an access token for the session and writes it with the refresh token
+func (h *UserHandler) respondWithTokens(c *gin.Context, user *models.User, session *models.Session, refreshToken string) {
+	tokenString, expiresAt, err := h.tokens.IssueAccessToken(user, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":              tokenString,
+		"expires_at":         expiresAt,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": session.ExpiresAt,
+		"user":               user,
+	})
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair.
+// Every refresh token can be used once; presenting it again revokes the whole session.
+func (h *UserHandler) Refresh(c *gin.Context) {
+	var request models.RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	session, err := h.sessions.RotateRefreshToken(auth.HashToken(request.RefreshToken), refreshHash, time.Now().Add(h.tokens.RefreshTokenTTL()))
+	if err != nil {
+		if errors.Is(err, db.ErrRefreshTokenInvalid) || errors.Is(err, db.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Reload the user so the new access token carries the current role
+	user, err := h.repo.GetUserByID(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists or has been deactivated"})
+		return
+	}
+
+	h.respondWithTokens(c, user, session, refreshToken)
+}
+
+// GetSessions returns the current user's active sessions
+func (h *UserHandler) GetSessions(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	sessions, err := h.sessions.GetActiveSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize sessions to empty slice if nil to avoid returning null
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// Logout revokes the session of the access token used for the request
+func (h *UserHandler) Logout(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	sessionID, exists := c.Get("session_id")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is not bound to a session"})
+		return
+	}
+
+	if err := h.sessions.RevokeSession(sessionID.(uuid.UUID), userID, "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every session of the current user, signing out all devices
+func (h *UserHandler) LogoutAll(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	revoked, err := h.sessions.RevokeAllSessions(userID, "logout_all")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions", "revoked": revoked})
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// TeamHandler handles HTTP requests for teams, whose members share records with team visibility
+type TeamHandler struct {
+	repo *db.TeamRepository
+}
+
+// NewTeamHandler creates a new team handler
+func NewTeamHandler(repo *db.TeamRepository) *TeamHandler {
+	return &TeamHandler{repo: repo}
+}
+
+// RegisterRoutes registers the team routes to the given router group; managing teams requires users:admin
+func (h *TeamHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermUsersRead)
+	admin := RequirePermission(auth.PermUsersAdmin)
+
+	teams := rg.Group("/teams")
+	{
+		teams.GET("", read, h.GetAllTeams)
+		teams.POST("", admin, h.CreateTeam)
+		teams.GET("/:id", read, h.GetTeamByID)
+		teams.PUT("/:id", admin, h.UpdateTeam)
+		teams.DELETE("/:id", admin, h.DeleteTeam)
+		teams.GET("/:id/members", read, h.GetTeamMembers)
+		teams.PUT("/:id/members/:user_id", admin, h.AddTeamMember)
+		teams.DELETE("/:id/members/:user_id", admin, h.RemoveTeamMember)
+	}
+}
+
+// GetAllTeams returns every team
+func (h *TeamHandler) GetAllTeams(c *gin.Context) {
+	teams, err := h.repo.GetAllTeams()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize teams to empty slice if nil to avoid returning null
+	if teams == nil {
+		teams = []models.Team{}
+	}
+
+	c.JSON(http.StatusOK, teams)
+}
+
+// GetTeamByID returns a single team by ID
+func (h *TeamHandler) GetTeamByID(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID format"})
+		return
+	}
+
+	team, err := h.repo.GetTeamByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if team == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, team)
+}
+
+// CreateTeam creates a new team
+func (h *TeamHandler) CreateTeam(c *gin.Context) {
+	var teamData models.TeamInput
+	if err := c.ShouldBindJSON(&teamData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team, err := h.repo.CreateTeam(teamData)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateTeam) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, team)
+}
+
+// UpdateTeam renames a team and replaces its description
+func (h *TeamHandler) UpdateTeam(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID format"})
+		return
+	}
+
+	var teamData models.TeamInput
+	if err := c.ShouldBindJSON(&teamData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team, err := h.repo.UpdateTeam(id, teamData)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateTeam) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if team == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, team)
+}
+
+// DeleteTeam deletes a team and its memberships
+func (h *TeamHandler) DeleteTeam(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID format"})
+		return
+	}
+
+	err = h.repo.DeleteTeam(id)
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team deleted successfully"})
+}
+
+// GetTeamMembers returns the users in a team
+func (h *TeamHandler) GetTeamMembers(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID format"})
+		return
+	}
+
+	team, err := h.repo.GetTeamByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if team == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+		return
+	}
+
+	users, err := h.repo.GetTeamMembers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Initialize users to empty slice if nil to avoid returning null
+	if users == nil {
+		users = []models.User{}
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// AddTeamMember adds a user to a team, letting them see the team's records
+func (h *TeamHandler) AddTeamMember(c *gin.Context) {
+	teamID, userID, ok := parseTeamMemberIDs(c)
+	if !ok {
+		return
+	}
+
+	err := h.repo.AddTeamMember(teamID, userID)
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team member added successfully"})
+}
+
+// RemoveTeamMember removes a user from a team
+func (h *TeamHandler) RemoveTeamMember(c *gin.Context) {
+	teamID, userID, ok := parseTeamMemberIDs(c)
+	if !ok {
+		return
+	}
+
+	err := h.repo.RemoveTeamMember(teamID, userID)
+	if err != nil {
+		respondRecordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team member removed successfully"})
+}
+
+// parseTeamMemberIDs parses the team and user IDs from the path, writing a 400 response when either is invalid
+func parseTeamMemberIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	teamID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid team ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return teamID, userID, true
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// TrashHandler handles HTTP requests for deleted records waiting to be restored or purged
+type TrashHandler struct {
+	repo *db.TrashRepository
+}
+
+// NewTrashHandler creates a new trash handler
+func NewTrashHandler(repo *db.TrashRepository) *TrashHandler {
+	return &TrashHandler{repo: repo}
+}
+
+// RegisterRoutes registers the trash routes to the given router group; records are restored through their own routes
+func (h *TrashHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/trash", h.GetTrash)
+}
+
+// GetTrash returns a page of the deleted records visible to the user, of every type they may read
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var recordTypes []string
+	permissions := c.GetStringSlice("permissions")
+	for _, recordType := range db.SearchRecordTypes {
+		if auth.HasPermission(permissions, recordReadPermissions[recordType]) {
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+
+	items, page, err := h.repo.GetTrash(opts, recordTypes, recordAccess(c))
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+
+	// Initialize items to empty slice if nil to avoid returning null
+	if items == nil {
+		items = []models.TrashItem{}
+	}
+
+	respondWithList(c, items, opts, page)
+}
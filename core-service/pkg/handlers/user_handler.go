@@ -1,36 +1,45 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/auth"
 	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/mail"
 	"github.com/kenahrens/crm-demo/core-service/pkg/models"
 )
 
-// JWT secret key - in production, this should be loaded from environment variables
-const jwtSecret = "your-secret-key-here" // TODO: Move to environment variable
-
-// JWTClaims represents the claims in the JWT token
-type JWTClaims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
-	jwt.RegisteredClaims
-}
-
 // UserHandler handles HTTP requests for users
 type UserHandler struct {
-	repo *db.UserRepository
+	repo       *db.UserRepository
+	sessions   *db.SessionRepository
+	userTokens *db.UserTokenRepository
+	attempts   *db.LoginAttemptRepository
+	mfa        *db.MFARepository
+	tokens     *auth.TokenService
+	lockout    *auth.LockoutPolicy
+	mfaConfig  *auth.MFAConfig
+	notifier   *mail.Notifier
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(repo *db.UserRepository) *UserHandler {
-	return &UserHandler{repo: repo}
+func NewUserHandler(repo *db.UserRepository, sessions *db.SessionRepository, userTokens *db.UserTokenRepository, attempts *db.LoginAttemptRepository, mfa *db.MFARepository, tokens *auth.TokenService, lockout *auth.LockoutPolicy, mfaConfig *auth.MFAConfig, notifier *mail.Notifier) *UserHandler {
+	return &UserHandler{
+		repo:       repo,
+		sessions:   sessions,
+		userTokens: userTokens,
+		attempts:   attempts,
+		mfa:        mfa,
+		tokens:     tokens,
+		lockout:    lockout,
+		mfaConfig:  mfaConfig,
+		notifier:   notifier,
+	}
 }
 
 // RegisterRoutes registers all user routes to the given router group
@@ -47,22 +56,75 @@ func (h *UserHandler) RegisterAuthRoutes(rg *gin.RouterGroup) {
 	auth := rg.Group("/auth")
 	{
 		auth.POST("/login", h.Login)
-		// Could add registration, password reset, etc.
+		auth.POST("/mfa/verify", h.VerifyMFA)
+		auth.POST("/refresh", h.Refresh)
+		auth.POST("/forgot-password", h.ForgotPassword)
+		auth.POST("/reset-password", h.ResetPassword)
+		auth.POST("/verify-email", h.VerifyEmail)
 	}
 }
 
-// RegisterSecureRoutes registers routes that require authentication
+// RegisterSecureRoutes registers routes that require authentication.
+// Listing users requires users:read; creating users and changing another user's account, role or status requires users:admin.
 func (h *UserHandler) RegisterSecureRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(auth.PermUsersRead)
+	admin := RequirePermission(auth.PermUsersAdmin)
+
+	auth := rg.Group("/auth")
+	{
+		auth.GET("/sessions", h.GetSessions)
+		auth.POST("/logout", h.Logout)
+		auth.POST("/logout-all", h.LogoutAll)
+		auth.POST("/resend-verification", h.ResendVerification)
+	}
+
 	users := rg.Group("/users")
 	{
-		users.GET("", h.GetAllUsers)
-		users.POST("", h.CreateUser)
-		users.GET("/:id", h.GetUserByID)
-		// Additional endpoints could be added here (update, delete, etc.)
+		users.GET("", read, h.GetAllUsers)
+		users.GET("/me", h.GetCurrentUser)
+		users.PUT("/me", h.UpdateCurrentUser)
+		users.PATCH("/me", h.UpdateCurrentUser)
+		users.PUT("/me/password", RequireSessionAuth(), h.ChangePassword)
+		users.GET("/:id", read, h.GetUserByID)
+	}
+
+	manage := users.Group("")
+	manage.Use(admin)
+	{
+		manage.POST("", h.CreateUser)
+		manage.PUT("/:id", h.UpdateUser)
+		manage.PATCH("/:id", h.UpdateUser)
+		manage.DELETE("/:id", h.DeleteUser)
+		manage.POST("/:id/deactivate", h.DeactivateUser)
+		manage.POST("/:id/activate", h.ActivateUser)
+		manage.POST("/:id/unlock", h.UnlockUser)
+		manage.POST("/:id/mfa/reset", h.ResetUserMFA)
+	}
+
+	loginAttempts := rg.Group("/login-attempts")
+	loginAttempts.Use(admin)
+	{
+		loginAttempts.GET("", h.GetLoginAttempts)
+	}
+}
+
+// RegisterMFARoutes registers the routes for managing the user's own second factor.
+// They require authentication but not MFA, so users whose role requires MFA can enroll.
+func (h *UserHandler) RegisterMFARoutes(rg *gin.RouterGroup) {
+	mfa := rg.Group("/users/me/mfa")
+	mfa.Use(RequireSessionAuth())
+	{
+		mfa.GET("", h.GetMFAStatus)
+		mfa.POST("/enroll", h.EnrollMFA)
+		mfa.POST("/activate", h.ActivateMFA)
+		mfa.POST("/recovery-codes", h.RegenerateRecoveryCodes)
+		mfa.DELETE("", h.DisableMFA)
 	}
 }
 
-// Login authenticates a user and returns a JWT token
+// Login authenticates a user, starts a session and returns an access and refresh token pair.
+// Users with MFA enabled instead receive a short-lived challenge token to complete the login at /auth/mfa/verify.
+// Repeated failures for an email address or from a client IP are delayed and then locked out.
 func (h *UserHandler) Login(c *gin.Context) {
 	var loginData models.UserLogin
 	if err := c.ShouldBindJSON(&loginData); err != nil {
@@ -70,50 +132,94 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
+	email := normalizeLoginEmail(loginData.Email)
+	ip := c.ClientIP()
+	attempt := models.LoginAttempt{Email: email, IPAddress: ip, UserAgent: c.Request.UserAgent()}
+
+	// Refuse locked or throttled clients before checking the password
+	wait, reason, err := h.loginRetryAfter(email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if wait > 0 {
+		attempt.FailureReason = reason
+		h.recordLoginAttempt(attempt)
+		respondLoginThrottled(c, wait)
+		return
+	}
+
 	// Check if credentials are valid
 	user, err := h.repo.CheckUserPassword(loginData.Email, loginData.Password)
 	if err != nil {
+		if errors.Is(err, db.ErrUserDeactivated) {
+			attempt.FailureReason = loginFailureDeactivated
+			h.recordLoginAttempt(attempt)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	if user == nil {
+		attempt.FailureReason = loginFailureInvalidCredentials
+		h.recordLoginAttempt(attempt)
+		if err := h.recordLoginFailure(email, ip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
-	// Create JWT token
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
-	claims := &JWTClaims{
-		UserID:   user.ID.String(),
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	// The throttle is only cleared once the second factor has been checked as well
+	if user.MFAEnabled {
+		h.startMFAChallenge(c, user)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	attempt.UserID = user.ID
+	attempt.Succeeded = true
+	h.recordLoginAttempt(attempt)
+	h.completeLogin(c, user, email, false)
+}
+
+// completeLogin clears the failed-attempt throttle of the email address, starts a session tied to a new refresh token
+// and responds with the token pair
+func (h *UserHandler) completeLogin(c *gin.Context, user *models.User, email string, mfaVerified bool) {
+	if err := h.attempts.ClearThrottle(db.ThrottleScopeEmail, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewOpaqueToken()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
 
-	// Return the token and user info
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-		"user":  user,
-	})
+	session, err := h.sessions.CreateSession(user.ID, c.Request.UserAgent(), c.ClientIP(), refreshHash, time.Now().Add(h.tokens.RefreshTokenTTL()), mfaVerified)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.respondWithTokens(c, user, session, refreshToken)
 }
 
-// GetAllUsers returns all users
+// GetAllUsers returns a page of users matching the query-string filters
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	users, err := h.repo.GetAllUsers()
+	opts, err := parseListOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	users, page, err := h.repo.GetAllUsers(opts)
+	if err != nil {
+		respondListError(c, err)
 		return
 	}
 
@@ -122,13 +228,7 @@ func (h *UserHandler) GetAllUsers(c *gin.Context) {
 		users = []models.User{}
 	}
 
-	// Return in paginated format matching OpenAPI spec
-	c.JSON(http.StatusOK, gin.H{
-		"data":   users,
-		"total":  len(users),
-		"limit":  100,
-		"offset": 0,
-	})
+	respondWithList(c, users, opts, page)
 }
 
 // GetUserByID returns a single user by ID
@@ -162,11 +262,192 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
-	user, err := h.repo.CreateUser(userData)
+	user, err := h.repo.CreateUser(userData, currentActor(c))
 	if err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, db.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// The user is created even if the email cannot be sent; it can be resent later
+	if err := h.sendEmailVerification(user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, user)
 }
+
+// UpdateUser updates another user's account, including their role; admins only
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var userData models.UserUpdate
+	if err := c.ShouldBindJSON(&userData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Admins cannot demote themselves, so at least one admin always remains
+	currentUserID := c.MustGet("user_id").(uuid.UUID)
+	if id == currentUserID && userData.Role != "" && userData.Role != c.GetString("role") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	existing, err := h.repo.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	user, err := h.repo.UpdateUser(id, userData, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, db.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Email != existing.Email {
+		if err := h.sendEmailVerification(user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+
+	// Role and password changes take effect immediately: the user has to log in again
+	if user.Role != existing.Role || userData.Password != "" {
+		if _, err := h.sessions.RevokeAllSessions(id, "account_updated"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser deletes a user, transferring the records they created to the user given by reassign_to.
+// Without reassign_to the records are transferred to the admin performing the deletion.
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	currentUserID := c.MustGet("user_id").(uuid.UUID)
+	if id == currentUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+
+	reassignTo := currentUserID
+	if reassignStr := c.Query("reassign_to"); reassignStr != "" {
+		reassignTo, err = uuid.Parse(reassignStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reassign_to user ID format"})
+			return
+		}
+	}
+
+	if reassignTo == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Records cannot be reassigned to the user being deleted"})
+		return
+	}
+
+	newOwner, err := h.repo.GetUserByID(reassignTo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if newOwner == nil || !newOwner.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reassign_to must be an active user"})
+		return
+	}
+
+	err = h.repo.DeleteUser(id, reassignTo, currentActor(c))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "reassigned_to": reassignTo})
+}
+
+// DeactivateUser blocks a user from logging in and ends their sessions, keeping their records and history
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	h.setUserActive(c, false)
+}
+
+// ActivateUser allows a deactivated user to log in again
+func (h *UserHandler) ActivateUser(c *gin.Context) {
+	h.setUserActive(c, true)
+}
+
+// setUserActive implements DeactivateUser and ActivateUser
+func (h *UserHandler) setUserActive(c *gin.Context, active bool) {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	if !active && id == c.MustGet("user_id").(uuid.UUID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot deactivate your own account"})
+		return
+	}
+
+	user, err := h.repo.SetUserActive(id, active, currentActor(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !active {
+		if _, err := h.sessions.RevokeAllSessions(id, "deactivated"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
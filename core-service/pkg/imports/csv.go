@@ -0,0 +1,240 @@
+package imports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// ErrInvalidImport is returned when an uploaded file or its mapping cannot be imported
+var ErrInvalidImport = errors.New("invalid import")
+
+// csvRow is a data row of a CSV file
+type csvRow struct {
+	line  int // Line of the file the row starts on; the header is line 1
+	cells []string
+}
+
+// csvFile is a parsed CSV file
+type csvFile struct {
+	headers []string
+	rows    []csvRow
+}
+
+// parseCSV parses a UTF-8 CSV file with a header row, separated by commas or, as some spreadsheets export it,
+// semicolons. Blank rows are skipped and short rows are padded with empty cells.
+func parseCSV(content []byte) (*csvFile, error) {
+	// Spreadsheets often start UTF-8 files with a byte order mark
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(content) {
+		return nil, fmt.Errorf("%w: the file must be UTF-8 encoded", ErrInvalidImport)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	seen := map[string]bool{}
+	for i, header := range headers {
+		headers[i] = strings.TrimSpace(header)
+		if headers[i] == "" {
+			continue
+		}
+		if seen[headers[i]] {
+			return nil, fmt.Errorf("%w: the column %q appears more than once", ErrInvalidImport, headers[i])
+		}
+		seen[headers[i]] = true
+	}
+
+	file := &csvFile{headers: headers}
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+		for len(cells) < len(headers) {
+			cells = append(cells, "")
+		}
+
+		line, _ := reader.FieldPos(0)
+		file.rows = append(file.rows, csvRow{line: line, cells: cells})
+	}
+
+	if len(file.rows) == 0 {
+		return nil, fmt.Errorf("%w: the file has no rows to import", ErrInvalidImport)
+	}
+
+	return file, nil
+}
+
+// columnMapping is a mapping of CSV columns to record fields, resolved against a file's headers
+type columnMapping struct {
+	headers []string
+	columns map[string]int // Field -> index of the column mapped to it
+}
+
+// cell returns the value of the column mapped to a field in a row, or "" when no column is mapped to it
+func (m *columnMapping) cell(row csvRow, name string) string {
+	column, ok := m.columns[name]
+	if !ok {
+		return ""
+	}
+	return row.cells[column]
+}
+
+// resolveMapping checks that a mapping names columns of the file and fields of the record type. Without a mapping,
+// columns are mapped to the fields they are named after, ignoring case and separators ("First Name" -> first_name),
+// and columns named custom_fields.<name> to those custom fields. Every required field must have a column.
+// It returns the mapping used.
+func resolveMapping(headers []string, mapping map[string]string, fields []field) (*columnMapping, map[string]string, error) {
+	known := map[string]bool{}
+	var names []string
+	for _, f := range fields {
+		known[f.name] = true
+		names = append(names, f.name)
+	}
+
+	resolved := &columnMapping{headers: headers, columns: map[string]int{}}
+	used := map[string]string{}
+
+	if len(mapping) == 0 {
+		for i, header := range headers {
+			name := strings.Join(strings.FieldsFunc(strings.ToLower(header), func(r rune) bool {
+				return r == ' ' || r == '_' || r == '-' || r == '.'
+			}), "_")
+			if strings.HasPrefix(strings.ToLower(header), customFieldPrefix) {
+				name = strings.ToLower(header)
+			}
+			if _, taken := resolved.columns[name]; known[name] && !taken {
+				resolved.columns[name] = i
+				used[header] = name
+			}
+		}
+
+		if len(used) == 0 {
+			return nil, nil, fmt.Errorf("%w: no column is named after a field; map columns to the fields %s",
+				ErrInvalidImport, strings.Join(names, ", "))
+		}
+		return resolved, used, resolved.checkRequired(fields)
+	}
+
+	index := map[string]int{}
+	for i, header := range headers {
+		index[header] = i
+	}
+
+	// Check columns in order so errors do not depend on map iteration
+	columns := make([]string, 0, len(mapping))
+	for column := range mapping {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	for _, column := range columns {
+		name := mapping[column]
+		if name == "" {
+			continue // Explicitly ignored
+		}
+
+		i, ok := index[column]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: the file has no column %q", ErrInvalidImport, column)
+		}
+		if !known[name] {
+			return nil, nil, fmt.Errorf("%w: unknown field %q for column %q; fields are %s",
+				ErrInvalidImport, name, column, strings.Join(names, ", "))
+		}
+		if other, taken := resolved.columns[name]; taken {
+			return nil, nil, fmt.Errorf("%w: columns %q and %q are both mapped to %s", ErrInvalidImport, headers[other], column, name)
+		}
+
+		resolved.columns[name] = i
+		used[column] = name
+	}
+
+	if len(used) == 0 {
+		return nil, nil, fmt.Errorf("%w: the mapping maps no column to a field", ErrInvalidImport)
+	}
+
+	return resolved, used, resolved.checkRequired(fields)
+}
+
+// checkRequired checks that a column is mapped to every required field. An account can be given by name instead of ID.
+func (m *columnMapping) checkRequired(fields []field) error {
+	for _, f := range fields {
+		if _, ok := m.columns[f.name]; !ok && f.required {
+			if _, byName := m.columns[accountNameField]; f.name == "account_id" && byName {
+				continue
+			}
+			return fmt.Errorf("%w: no column is mapped to the required field %s", ErrInvalidImport, f.name)
+		}
+	}
+	return nil
+}
+
+// WriteErrorReport writes the rows of a file that could not be imported as CSV, with their original columns
+// followed by the line they were on and why they failed, so they can be fixed and imported again
+func WriteErrorReport(w io.Writer, content []byte, rowErrors []models.ImportRowError) error {
+	file, err := parseCSV(content)
+	if err != nil {
+		return err
+	}
+
+	messages := map[int][]string{}
+	for _, rowError := range rowErrors {
+		message := rowError.Error
+		if rowError.Column != "" {
+			message = rowError.Column + ": " + message
+		}
+		messages[rowError.Line] = append(messages[rowError.Line], message)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append(append([]string{}, file.headers...), "import_line", "import_errors")); err != nil {
+		return fmt.Errorf("error writing error report: %w", err)
+	}
+
+	for _, row := range file.rows {
+		if len(messages[row.line]) == 0 {
+			continue
+		}
+
+		cells := append(append([]string{}, row.cells...), strconv.Itoa(row.line), strings.Join(messages[row.line], "; "))
+		if err := writer.Write(cells); err != nil {
+			return fmt.Errorf("error writing error report: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing error report: %w", err)
+	}
+
+	return nil
+}
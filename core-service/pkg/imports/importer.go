@@ -0,0 +1,246 @@
+package imports
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+const (
+	// batchSize is the number of rows imported per transaction
+	batchSize = 100
+	// staleAfter is how long a running job may go without progress before a worker resumes it
+	staleAfter = 5 * time.Minute
+	// maxDryRunErrors is the number of row errors a dry run lists
+	maxDryRunErrors = 1000
+)
+
+// Config controls CSV imports
+type Config struct {
+	MaxFileSize  int64         // Largest file accepted, in bytes
+	PollInterval time.Duration // Time between checks for queued jobs
+}
+
+// LoadConfig reads the import settings from environment variables:
+//
+//	IMPORT_MAX_FILE_MB    largest CSV file accepted, in megabytes (default 10)
+//	IMPORT_POLL_INTERVAL  time between checks for queued import jobs as a Go duration (default 5s)
+func LoadConfig() (*Config, error) {
+	size, err := strconv.Atoi(getEnv("IMPORT_MAX_FILE_MB", "10"))
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid IMPORT_MAX_FILE_MB: must be a positive integer")
+	}
+
+	interval, err := time.ParseDuration(getEnv("IMPORT_POLL_INTERVAL", "5s"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid IMPORT_POLL_INTERVAL: must be a positive duration such as 5s")
+	}
+
+	return &Config{
+		MaxFileSize:  int64(size) << 20,
+		PollInterval: interval,
+	}, nil
+}
+
+// Upload is a CSV file to import and how its columns map to record fields
+type Upload struct {
+	RecordType     string // "account", "contact" or "opportunity"
+	FileName       string
+	Content        []byte
+	Mapping        map[string]string // CSV column -> field; without one, columns are matched to fields by name
+	LinkAccounts   bool              // Whether rows may name their account, which takes permission to read accounts
+	AllowDuplicate bool              // Whether rows may match existing accounts or contacts under the duplicate rules
+}
+
+// Importer checks CSV files and imports them as records in the background
+type Importer struct {
+	repo         *db.ImportRepository
+	accounts     *db.AccountRepository
+	customFields *db.CustomFieldRepository
+}
+
+// New creates a new importer
+func New(repo *db.ImportRepository, accounts *db.AccountRepository, customFields *db.CustomFieldRepository) *Importer {
+	return &Importer{repo: repo, accounts: accounts, customFields: customFields}
+}
+
+// prepare parses an upload, adds the custom fields of its record type to the fields columns can be mapped to and
+// resolves its mapping
+func (i *Importer) prepare(upload Upload) (recordType, *csvFile, *columnMapping, map[string]string, error) {
+	recordType, ok := recordTypes[upload.RecordType]
+	if !ok {
+		return recordType, nil, nil, nil, fmt.Errorf("%w: record type must be account, contact or opportunity", ErrInvalidImport)
+	}
+
+	fields := recordType.fields
+	if !upload.LinkAccounts {
+		fields = nil
+		for _, f := range recordType.fields {
+			if f.name != accountNameField {
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	definitions, err := i.customFields.GetCustomFieldDefinitions(upload.RecordType)
+	if err != nil {
+		return recordType, nil, nil, nil, err
+	}
+	fields = append(fields, customFieldColumns(definitions)...)
+
+	file, err := parseCSV(upload.Content)
+	if err != nil {
+		return recordType, nil, nil, nil, err
+	}
+
+	mapping, used, err := resolveMapping(file.headers, upload.Mapping, fields)
+	if err != nil {
+		return recordType, nil, nil, nil, err
+	}
+
+	recordType.fields = fields
+	recordType.definitions = definitions
+	return recordType, file, mapping, used, nil
+}
+
+// DryRun checks every row of an upload as the caller would import it, without importing anything.
+// It finds the same errors as the import except references to records that do not exist and rows matching
+// existing records under the duplicate rules.
+func (i *Importer) DryRun(upload Upload, access db.Access) (*models.ImportDryRun, error) {
+	recordType, file, mapping, used, err := i.prepare(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ImportDryRun{
+		RecordType: upload.RecordType,
+		FileName:   upload.FileName,
+		Headers:    file.headers,
+		Mapping:    used,
+		TotalRows:  len(file.rows),
+		Errors:     []models.ImportRowError{},
+	}
+	for _, f := range recordType.fields {
+		result.Fields = append(result.Fields, f.name)
+	}
+
+	for start := 0; start < len(file.rows); start += batchSize {
+		rows := file.rows[start:min(start+batchSize, len(file.rows))]
+		valid, rowErrors, err := recordType.check(i, recordType, rows, mapping, access)
+		if err != nil {
+			return nil, err
+		}
+
+		result.ValidRows += valid
+		for _, rowError := range rowErrors {
+			if len(result.Errors) == maxDryRunErrors {
+				result.ErrorsTruncated = true
+				break
+			}
+			result.Errors = append(result.Errors, rowError)
+		}
+	}
+	result.InvalidRows = result.TotalRows - result.ValidRows
+
+	return result, nil
+}
+
+// Queue checks an upload's file and mapping and queues it to be imported as the caller in the background
+func (i *Importer) Queue(upload Upload, access db.Access) (*models.ImportJob, error) {
+	_, file, _, used, err := i.prepare(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.repo.CreateImportJob(db.ImportJobCreate{
+		RecordType:     upload.RecordType,
+		FileName:       upload.FileName,
+		Content:        upload.Content,
+		Mapping:        used,
+		TotalRows:      len(file.rows),
+		AllowDuplicate: upload.AllowDuplicate,
+	}, access)
+}
+
+// RunEvery imports queued jobs one after the other, checking for new ones once per interval. It never returns.
+// Several instances can run at once; each job is imported by one of them.
+func (i *Importer) RunEvery(interval time.Duration) {
+	for {
+		for i.runNext() {
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// runNext claims the next queued job and imports it, logging the outcome. It reports whether there was a job.
+func (i *Importer) runNext() bool {
+	claim, err := i.repo.ClaimImportJob(staleAfter)
+	if err != nil {
+		log.Printf("ERROR: Claiming import job failed: %v", err)
+		return false
+	}
+	if claim == nil {
+		return false
+	}
+
+	job := claim.Job
+	err = i.run(claim)
+	switch {
+	case errors.Is(err, db.ErrImportTaken):
+		log.Printf("Import job %s was resumed by another worker", job.ID)
+		return true
+	case err != nil:
+		log.Printf("ERROR: Import job %s failed: %v", job.ID, err)
+	default:
+		log.Printf("Import job %s finished", job.ID)
+	}
+
+	failure := ""
+	if err != nil {
+		failure = err.Error()
+	}
+	if err := i.repo.FinishImportJob(job.ID, failure); err != nil {
+		log.Printf("ERROR: Finishing import job %s failed: %v", job.ID, err)
+	}
+
+	return true
+}
+
+// run imports the rows of a claimed job that have not been processed yet, one batch per transaction
+func (i *Importer) run(claim *db.ImportClaim) error {
+	// Rows naming their account were only accepted from users who could read accounts
+	recordType, file, mapping, _, err := i.prepare(Upload{
+		RecordType:   claim.Job.RecordType,
+		Content:      claim.Content,
+		Mapping:      claim.Job.Mapping,
+		LinkAccounts: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	for start := claim.Job.ProcessedRows; start < len(file.rows); start += batchSize {
+		end := min(start+batchSize, len(file.rows))
+		if err := recordType.run(i, recordType, claim, file.rows[start:end], mapping, start, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
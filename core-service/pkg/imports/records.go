@@ -0,0 +1,305 @@
+package imports
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/kenahrens/crm-demo/core-service/pkg/customfields"
+	"github.com/kenahrens/crm-demo/core-service/pkg/db"
+	"github.com/kenahrens/crm-demo/core-service/pkg/models"
+)
+
+// fieldKind is how a CSV value is converted into a field value
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	uuidField
+	numberField
+	dateField
+	visibilityField
+	booleanField
+)
+
+// accountNameField links a contact or opportunity to the account with the name in its column
+const accountNameField = "account_name"
+
+// customFieldPrefix starts the fields that set the value of a custom field, such as custom_fields.tier
+const customFieldPrefix = "custom_fields."
+
+// field is a record field a CSV column can be mapped to
+type field struct {
+	name     string
+	kind     fieldKind
+	required bool
+}
+
+// recordType describes how CSV rows become records of one type
+type recordType struct {
+	fields []field
+	// definitions are the custom fields of the type, set when an upload is prepared
+	definitions []models.CustomFieldDefinition
+	// check converts and validates rows as the caller and returns how many are valid and why the others are not
+	check func(i *Importer, t recordType, rows []csvRow, mapping *columnMapping, access db.Access) (int, []models.ImportRowError, error)
+	// run imports rows as the batch of a job from start to end rows processed
+	run func(i *Importer, t recordType, claim *db.ImportClaim, rows []csvRow, mapping *columnMapping, start, end int) error
+}
+
+// recordTypes maps each record type that can be imported to its description
+var recordTypes = map[string]recordType{
+	"account": newRecordType([]field{
+		{"name", textField, true},
+		{"industry", textField, false},
+		{"website", textField, false},
+		{"phone", textField, false},
+		{"address", textField, false},
+		{"city", textField, false},
+		{"state", textField, false},
+		{"zip", textField, false},
+		{"country", textField, false},
+		{"visibility", visibilityField, false},
+	}, (*db.ImportRepository).ImportAccounts),
+	"contact": newRecordType([]field{
+		{"first_name", textField, true},
+		{"last_name", textField, true},
+		{"email", textField, false},
+		{"phone", textField, false},
+		{"title", textField, false},
+		{"account_id", uuidField, false},
+		{accountNameField, textField, false},
+		{"address", textField, false},
+		{"city", textField, false},
+		{"state", textField, false},
+		{"zip", textField, false},
+		{"country", textField, false},
+		{"visibility", visibilityField, false},
+	}, (*db.ImportRepository).ImportContacts),
+	"opportunity": newRecordType([]field{
+		{"opportunity_name", textField, true},
+		{"account_id", uuidField, true}, // Or account_name
+		{accountNameField, textField, false},
+		{"primary_contact_id", uuidField, false},
+		{"stage", textField, true},
+		{"amount", numberField, false},
+		{"close_date", dateField, false},
+		{"probability", numberField, false},
+		{"visibility", visibilityField, false},
+	}, (*db.ImportRepository).ImportOpportunities),
+}
+
+// newRecordType describes a record type created from C, imported in batches with importBatch
+func newRecordType[C any](fields []field, importBatch func(*db.ImportRepository, *db.ImportClaim, db.ImportBatch[C]) error) recordType {
+	return recordType{
+		fields: fields,
+		check: func(i *Importer, t recordType, rows []csvRow, mapping *columnMapping, access db.Access) (int, []models.ImportRowError, error) {
+			ops, rowErrors, err := prepareRows[C](i, t, rows, mapping, access)
+			return len(ops), rowErrors, err
+		},
+		run: func(i *Importer, t recordType, claim *db.ImportClaim, rows []csvRow, mapping *columnMapping, start, end int) error {
+			ops, rowErrors, err := prepareRows[C](i, t, rows, mapping, claim.Access)
+			if err != nil {
+				return err
+			}
+			for n := range ops {
+				ops[n].AllowDuplicate = claim.Job.AllowDuplicate
+			}
+			return importBatch(i.repo, claim, db.ImportBatch[C]{Start: start, End: end, Rows: ops, Errors: rowErrors})
+		},
+	}
+}
+
+// prepareRows converts rows into records to create, validated like the create endpoint validates them with their
+// custom fields, and links them to the accounts named in them. It returns the creates of the valid rows, with their
+// line as Index, and why the other rows are invalid.
+func prepareRows[C any](i *Importer, t recordType, rows []csvRow, mapping *columnMapping, access db.Access) ([]db.BulkOperation[C], []models.ImportRowError, error) {
+	var rowErrors []models.ImportRowError
+	converted := make([]map[string]interface{}, len(rows))
+	var names []string
+	for n, row := range rows {
+		values, errs := convertRow(row, t.fields, mapping)
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+
+		converted[n] = values
+		if name, ok := values[accountNameField].(string); ok && values["account_id"] == nil {
+			names = append(names, name)
+		}
+	}
+
+	var accounts map[string][]uuid.UUID
+	if len(names) > 0 {
+		var err error
+		if accounts, err = i.accounts.GetAccountIDsByName(names, access); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var ops []db.BulkOperation[C]
+	for n, row := range rows {
+		values := converted[n]
+		if values == nil {
+			continue
+		}
+
+		if name, ok := values[accountNameField].(string); ok && values["account_id"] == nil {
+			column := mapping.headers[mapping.columns[accountNameField]]
+			switch ids := accounts[strings.ToLower(name)]; len(ids) {
+			case 0:
+				rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Column: column,
+					Error: fmt.Sprintf("no account is named %q", name)})
+				continue
+			case 1:
+				values["account_id"] = ids[0]
+			default:
+				rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Column: column,
+					Error: fmt.Sprintf("%d accounts are named %q; map a column of account IDs to account_id instead", len(ids), name)})
+				continue
+			}
+		}
+		delete(values, accountNameField)
+
+		custom := map[string]interface{}{}
+		for _, definition := range t.definitions {
+			if value, ok := values[customFieldPrefix+definition.Name]; ok {
+				custom[definition.Name] = value
+				delete(values, customFieldPrefix+definition.Name)
+			}
+		}
+		valid, err := customfields.Validate(t.definitions, custom)
+		if err != nil {
+			rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Error: err.Error()})
+			continue
+		}
+		values["custom_fields"] = valid
+
+		record, err := decodeRecord[C](values)
+		if err != nil {
+			rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Error: err.Error()})
+			continue
+		}
+		ops = append(ops, db.BulkOperation[C]{Index: row.line, Op: "create", Create: record})
+	}
+
+	return ops, rowErrors, nil
+}
+
+// convertRow converts the non-empty cells of the mapped columns of a row into field values
+func convertRow(row csvRow, fields []field, mapping *columnMapping) (map[string]interface{}, []models.ImportRowError) {
+	values := map[string]interface{}{}
+	var rowErrors []models.ImportRowError
+	for _, f := range fields {
+		column, ok := mapping.columns[f.name]
+		if !ok {
+			continue
+		}
+
+		cell := strings.TrimSpace(row.cells[column])
+		if cell == "" {
+			// A required account can be given by ID or by name; when neither is, it is reported on the name column
+			required := f.required
+			switch f.name {
+			case "account_id":
+				_, byName := mapping.columns[accountNameField]
+				required = required && !byName
+			case accountNameField:
+				required = accountRequired(fields) && strings.TrimSpace(mapping.cell(row, "account_id")) == ""
+			}
+			if required {
+				rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Column: mapping.headers[column], Error: "is required"})
+			}
+			continue
+		}
+
+		var problem string
+		switch f.kind {
+		case textField:
+			values[f.name] = cell
+		case uuidField:
+			if id, err := uuid.Parse(cell); err == nil {
+				values[f.name] = id
+			} else {
+				problem = "must be an ID"
+			}
+		case numberField:
+			if number, err := strconv.ParseFloat(cell, 64); err == nil {
+				values[f.name] = number
+			} else {
+				problem = "must be a number"
+			}
+		case dateField:
+			if _, err := time.Parse("2006-01-02", cell); err == nil {
+				values[f.name] = cell
+			} else {
+				problem = "must be a date in YYYY-MM-DD format"
+			}
+		case booleanField:
+			if flag, err := strconv.ParseBool(cell); err == nil {
+				values[f.name] = flag
+			} else {
+				problem = "must be true or false"
+			}
+		case visibilityField:
+			if visibility := strings.ToLower(cell); visibility == "private" || visibility == "team" || visibility == "public" {
+				values[f.name] = visibility
+			} else {
+				problem = "must be private, team or public"
+			}
+		}
+
+		if problem != "" {
+			rowErrors = append(rowErrors, models.ImportRowError{Line: row.line, Column: mapping.headers[column], Error: problem})
+		}
+	}
+
+	return values, rowErrors
+}
+
+// customFieldColumns returns the fields columns can be mapped to to set custom fields, as custom_fields.<name>.
+// A column must be mapped to a required field unless it has a default.
+func customFieldColumns(definitions []models.CustomFieldDefinition) []field {
+	kinds := map[string]fieldKind{
+		customfields.TypeNumber:  numberField,
+		customfields.TypeBoolean: booleanField,
+		customfields.TypeDate:    dateField,
+	}
+
+	fields := make([]field, len(definitions))
+	for n, definition := range definitions {
+		fields[n] = field{customFieldPrefix + definition.Name, kinds[definition.Type], definition.Required && definition.Default == nil}
+	}
+	return fields
+}
+
+// accountRequired reports whether records of a type must belong to an account
+func accountRequired(fields []field) bool {
+	for _, f := range fields {
+		if f.name == "account_id" {
+			return f.required
+		}
+	}
+	return false
+}
+
+// decodeRecord builds the record to create from field values and validates it
+func decodeRecord[C any](values map[string]interface{}) (C, error) {
+	var record C
+	data, err := json.Marshal(values)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, err
+	}
+	if err := binding.Validator.ValidateStruct(&record); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
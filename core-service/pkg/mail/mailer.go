@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config holds the mail delivery settings
+type Config struct {
+	Driver       string // smtp, file or memory
+	From         string // Sender address
+	AppURL       string // Base URL of the frontend, used to build links in messages
+	Dir          string // Output directory of the file driver
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// LoadConfig reads the mail configuration from environment variables:
+//
+//	MAIL_DRIVER    smtp, file or memory (defaults to smtp when SMTP_HOST is set, otherwise file)
+//	MAIL_FROM      sender address (default no-reply@crm.local)
+//	MAIL_DIR       directory the file driver writes .eml files to (default ./tmp/mail)
+//	APP_URL        base URL of the frontend used in reset and verification links (default http://localhost:3000)
+//	SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD
+func LoadConfig() *Config {
+	cfg := &Config{
+		From:         getEnv("MAIL_FROM", "no-reply@crm.local"),
+		AppURL:       strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/"),
+		Dir:          getEnv("MAIL_DIR", "./tmp/mail"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+	}
+
+	cfg.Driver = os.Getenv("MAIL_DRIVER")
+	if cfg.Driver == "" {
+		cfg.Driver = "file"
+		if cfg.SMTPHost != "" {
+			cfg.Driver = "smtp"
+		}
+	}
+
+	return cfg
+}
+
+// New creates the mailer selected by the configuration
+func New(cfg *Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+		}
+		return NewSMTPMailer(cfg), nil
+	case "file":
+		log.Printf("WARNING: Email is not delivered, messages are written to %s", cfg.Dir)
+		return NewFileMailer(cfg.Dir, cfg.From), nil
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.Driver)
+	}
+}
+
+// formatMessage renders a message in RFC 5322 format
+func formatMessage(from string, msg Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
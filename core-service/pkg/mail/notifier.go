@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Notifier composes and sends the account emails the CRM sends to its users
+type Notifier struct {
+	mailer Mailer
+	appURL string
+}
+
+// NewNotifier creates a new notifier; links in messages point at appURL
+func NewNotifier(mailer Mailer, appURL string) *Notifier {
+	return &Notifier{mailer: mailer, appURL: appURL}
+}
+
+// SendPasswordReset sends a link for choosing a new password
+func (n *Notifier) SendPasswordReset(to, username, token string, ttl time.Duration) error {
+	link := n.appURL + "/reset-password?token=" + url.QueryEscape(token)
+	return n.mailer.Send(Message{
+		To:      to,
+		Subject: "Reset your CRM password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password for your CRM account. To choose a new password, open:\n\n"+
+			"%s\n\n"+
+			"The link can be used once and expires in %s. If you did not ask for this, you can ignore this email.\n",
+			username, link, formatDuration(ttl)),
+	})
+}
+
+// SendEmailVerification sends a link confirming that the user owns the email address
+func (n *Notifier) SendEmailVerification(to, username, token string, ttl time.Duration) error {
+	link := n.appURL + "/verify-email?token=" + url.QueryEscape(token)
+	return n.mailer.Send(Message{
+		To:      to,
+		Subject: "Verify your CRM email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm this email address for your CRM account by opening:\n\n"+
+			"%s\n\n"+
+			"The link expires in %s.\n",
+			username, link, formatDuration(ttl)),
+	})
+}
+
+// formatDuration renders a link lifetime for humans, such as "1 hour" or "48 hours"
+func formatDuration(d time.Duration) string {
+	value, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		value, unit = int(d/time.Hour), "hour"
+	}
+	if value == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", value, unit)
+}
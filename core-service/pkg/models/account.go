@@ -8,46 +8,53 @@ import (
 
 // Account represents a company or organization in the CRM
 type Account struct {
-	ID        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
-	Industry  string    `json:"industry,omitempty"`
-	Website   string    `json:"website,omitempty"`
-	Phone     string    `json:"phone,omitempty"`
-	Address   string    `json:"address,omitempty"`
-	City      string    `json:"city,omitempty"`
-	State     string    `json:"state,omitempty"`
-	Zip       string    `json:"zip,omitempty"`
-	Country   string    `json:"country,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	CreatedBy uuid.UUID `json:"created_by"`
-	Contacts  []Contact `json:"contacts,omitempty"`
+	ID           uuid.UUID              `json:"id"`
+	Name         string                 `json:"name"`
+	Industry     string                 `json:"industry,omitempty"`
+	Website      string                 `json:"website,omitempty"`
+	Phone        string                 `json:"phone,omitempty"`
+	Address      string                 `json:"address,omitempty"`
+	City         string                 `json:"city,omitempty"`
+	State        string                 `json:"state,omitempty"`
+	Zip          string                 `json:"zip,omitempty"`
+	Country      string                 `json:"country,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	CreatedBy    uuid.UUID              `json:"created_by"`
+	UpdatedBy    uuid.UUID              `json:"updated_by"`
+	OwnerID      uuid.UUID              `json:"owner_id"`
+	Visibility   string                 `json:"visibility"`
+	Version      int64                  `json:"version"`
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty"`
+	Contacts     []Contact              `json:"contacts,omitempty"`
 }
 
 // AccountCreate is used for creating a new account
 type AccountCreate struct {
-	Name      string    `json:"name" binding:"required"`
-	Industry  string    `json:"industry"`
-	Website   string    `json:"website"`
-	Phone     string    `json:"phone"`
-	Address   string    `json:"address"`
-	City      string    `json:"city"`
-	State     string    `json:"state"`
-	Zip       string    `json:"zip"`
-	Country   string    `json:"country"`
-	CreatedBy uuid.UUID `json:"created_by" binding:"required"`
+	Name         string                 `json:"name" binding:"required"`
+	Industry     string                 `json:"industry"`
+	Website      string                 `json:"website"`
+	Phone        string                 `json:"phone"`
+	Address      string                 `json:"address"`
+	City         string                 `json:"city"`
+	State        string                 `json:"state"`
+	Zip          string                 `json:"zip"`
+	Country      string                 `json:"country"`
+	Visibility   string                 `json:"visibility" binding:"omitempty,oneof=private team public"`
+	CustomFields map[string]interface{} `json:"custom_fields"` // Custom field name -> value
 }
 
 // AccountUpdate is used for updating an existing account
 type AccountUpdate struct {
-	Name      string    `json:"name"`
-	Industry  string    `json:"industry"`
-	Website   string    `json:"website"`
-	Phone     string    `json:"phone"`
-	Address   string    `json:"address"`
-	City      string    `json:"city"`
-	State     string    `json:"state"`
-	Zip       string    `json:"zip"`
-	Country   string    `json:"country"`
-	UpdatedBy uuid.UUID `json:"updated_by" binding:"required"`
+	Name         string                 `json:"name"`
+	Industry     string                 `json:"industry"`
+	Website      string                 `json:"website"`
+	Phone        string                 `json:"phone"`
+	Address      string                 `json:"address"`
+	City         string                 `json:"city"`
+	State        string                 `json:"state"`
+	Zip          string                 `json:"zip"`
+	Country      string                 `json:"country"`
+	Visibility   string                 `json:"visibility" binding:"omitempty,oneof=private team public"`
+	CustomFields map[string]interface{} `json:"custom_fields"` // Custom field values to set; null removes a value
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a single create, update, delete, restore, purge or merge of a record or user
+type AuditEvent struct {
+	ID            uuid.UUID              `json:"id"`
+	OccurredAt    time.Time              `json:"occurred_at"`
+	ActorID       *uuid.UUID             `json:"actor_id"` // nil for changes made by the system
+	ActorUsername string                 `json:"actor_username,omitempty"`
+	RequestID     string                 `json:"request_id,omitempty"`
+	EntityType    string                 `json:"entity_type"` // "account", "contact", "opportunity", "note", "user"
+	EntityID      uuid.UUID              `json:"entity_id"`
+	Action        string                 `json:"action"` // "create", "update", "delete", "restore", "purge", "merge"
+	Changes       map[string]AuditChange `json:"changes"`
+}
+
+// AuditChange is the value of a field before and after a change; Old is nil for creates and New for permanent deletes
+type AuditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// BulkRequest is a list of creates, updates and deletes of one type of record, applied in one request
+type BulkRequest struct {
+	Mode       string          `json:"mode" binding:"omitempty,oneof=atomic partial"` // atomic (the default) applies every operation or none
+	Operations []BulkOperation `json:"operations" binding:"required,min=1,max=1000"`
+}
+
+// BulkOperation is one operation of a bulk request
+type BulkOperation struct {
+	Op             string          `json:"op"`                        // "create", "update" or "delete"
+	ID             uuid.UUID       `json:"id,omitempty"`              // The record to update or delete
+	Version        *int64          `json:"version,omitempty"`         // Only update or delete this version of the record, like If-Match
+	Data           json.RawMessage `json:"data,omitempty"`            // The record to create, or a JSON Merge Patch for updates
+	AllowDuplicate bool            `json:"allow_duplicate,omitempty"` // Create the account or contact even if it matches existing ones
+}
+
+// BulkItemResult is the outcome of one operation of a bulk request
+type BulkItemResult struct {
+	Index   int         `json:"index"`
+	Status  int         `json:"status"` // The HTTP status the operation would have had on its own
+	ID      *uuid.UUID  `json:"id,omitempty"`
+	Data    interface{} `json:"data,omitempty"` // The created or updated record
+	Error   string      `json:"error,omitempty"`
+	Matches interface{} `json:"matches,omitempty"` // The existing records a create that failed with 409 matches
+}
+
+// BulkResponse reports the outcome of every operation of a bulk request, in request order
+type BulkResponse struct {
+	Mode      string           `json:"mode"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
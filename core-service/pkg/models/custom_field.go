@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CustomFieldDefinition is an admin-defined field of accounts, contacts or opportunities, whose values the
+// records carry in custom_fields under its name
+type CustomFieldDefinition struct {
+	ID         uuid.UUID   `json:"id"`
+	EntityType string      `json:"entity_type"` // "account", "contact" or "opportunity"
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"` // "text", "number", "boolean", "date" (YYYY-MM-DD) or "enum"
+	Required   bool        `json:"required"`
+	Options    []string    `json:"options,omitempty"` // Values an enum field may take
+	Default    interface{} `json:"default,omitempty"` // Value given to new records that leave the field out
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	CreatedBy  uuid.UUID   `json:"created_by"`
+	UpdatedBy  uuid.UUID   `json:"updated_by"`
+}
+
+// CustomFieldDefinitionCreate is used for defining a new custom field
+type CustomFieldDefinitionCreate struct {
+	EntityType string      `json:"entity_type" binding:"required,oneof=account contact opportunity"`
+	Name       string      `json:"name" binding:"required,max=63"`
+	Label      string      `json:"label" binding:"required,max=255"`
+	Type       string      `json:"type" binding:"required,oneof=text number boolean date enum"`
+	Required   bool        `json:"required"`
+	Options    []string    `json:"options"`
+	Default    interface{} `json:"default"`
+}
+
+// CustomFieldDefinitionUpdate is used for changing a custom field; its entity type, name and type cannot change
+type CustomFieldDefinitionUpdate struct {
+	Label    string      `json:"label" binding:"required,max=255"`
+	Required bool        `json:"required"`
+	Options  []string    `json:"options"`
+	Default  interface{} `json:"default"`
+}
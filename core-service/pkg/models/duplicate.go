@@ -0,0 +1,38 @@
+package models
+
+import "github.com/google/uuid"
+
+// ContactDuplicate is a pair of contacts that are likely the same person
+type ContactDuplicate struct {
+	Score   int       `json:"score"`   // 0 to 100
+	Reasons []string  `json:"reasons"` // What the contacts have in common, such as "same email"
+	Records []Contact `json:"records"`
+}
+
+// AccountDuplicate is a pair of accounts that are likely the same company
+type AccountDuplicate struct {
+	Score   int       `json:"score"`   // 0 to 100
+	Reasons []string  `json:"reasons"` // What the accounts have in common, such as "same website domain"
+	Records []Account `json:"records"`
+}
+
+// ContactMatch is an existing contact that a contact to create matches under the duplicate rules
+type ContactMatch struct {
+	Rules  []string `json:"rules"` // The rules it matches under: "email", "domain_name" or "phone"
+	Record Contact  `json:"record"`
+}
+
+// AccountMatch is an existing account that an account to create matches under the duplicate rules
+type AccountMatch struct {
+	Rules  []string `json:"rules"` // The rules it matches under: "domain_name" or "phone"
+	Record Account  `json:"record"`
+}
+
+// MergeRequest is used to merge duplicates into the record that is kept
+type MergeRequest struct {
+	SurvivorID   uuid.UUID   `json:"survivor_id" binding:"required"`
+	DuplicateIDs []uuid.UUID `json:"duplicate_ids" binding:"required,min=1,max=20"`
+	// Field -> the record, survivor or duplicate, to keep the value of. Fields not listed keep the survivor's
+	// value, or take the first duplicate's where the survivor has none.
+	Fields map[string]uuid.UUID `json:"fields,omitempty"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportJob is a CSV file being imported as records in the background
+type ImportJob struct {
+	ID             uuid.UUID         `json:"id"`
+	RecordType     string            `json:"record_type"` // "account", "contact", "opportunity"
+	Status         string            `json:"status"`      // "pending", "running", "completed", "failed"
+	FileName       string            `json:"file_name"`
+	Mapping        map[string]string `json:"mapping"`         // CSV column -> record field
+	AllowDuplicate bool              `json:"allow_duplicate"` // Whether rows may match existing accounts or contacts
+	TotalRows      int               `json:"total_rows"`
+	ProcessedRows  int               `json:"processed_rows"`
+	ImportedRows   int               `json:"imported_rows"`
+	FailedRows     int               `json:"failed_rows"`
+	Progress       int               `json:"progress"`        // Percentage of the rows processed
+	Error          string            `json:"error,omitempty"` // Why a failed job stopped
+	CreatedBy      uuid.UUID         `json:"created_by"`
+	CreatedAt      time.Time         `json:"created_at"`
+	StartedAt      *time.Time        `json:"started_at,omitempty"`
+	FinishedAt     *time.Time        `json:"finished_at,omitempty"`
+}
+
+// ImportRowError is why a row of a CSV file cannot be imported
+type ImportRowError struct {
+	Line   int    `json:"line"`             // Line of the file the row starts on; the header is line 1
+	Column string `json:"column,omitempty"` // The CSV column at fault, when the error is about one value
+	Error  string `json:"error"`
+}
+
+// ImportDryRun is the outcome of checking a CSV file without importing it
+type ImportDryRun struct {
+	RecordType      string            `json:"record_type"`
+	FileName        string            `json:"file_name"`
+	Headers         []string          `json:"headers"`
+	Mapping         map[string]string `json:"mapping"` // The mapping used, given or matched from the headers
+	Fields          []string          `json:"fields"`  // The fields columns can be mapped to
+	TotalRows       int               `json:"total_rows"`
+	ValidRows       int               `json:"valid_rows"`
+	InvalidRows     int               `json:"invalid_rows"`
+	Errors          []ImportRowError  `json:"errors"`
+	ErrorsTruncated bool              `json:"errors_truncated,omitempty"` // Whether only the first errors are listed
+}
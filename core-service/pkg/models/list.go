@@ -0,0 +1,16 @@
+package models
+
+// ListOptions holds the paging, sorting and filtering parameters for list queries
+type ListOptions struct {
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+	Sort    string            `json:"sort,omitempty"`    // Comma-separated fields, prefix with "-" for descending
+	Cursor  string            `json:"cursor,omitempty"`  // Opaque keyset cursor; when set, Offset is ignored
+	Filters map[string]string `json:"filters,omitempty"` // Field filters such as industry=Technology
+}
+
+// PageInfo describes the page returned by a list query
+type PageInfo struct {
+	Total      int    `json:"total"`                 // Number of matching rows (offset mode only)
+	NextCursor string `json:"next_cursor,omitempty"` // Cursor for the following page, empty on the last page
+}
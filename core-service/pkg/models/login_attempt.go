@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginAttempt is an entry in the audit trail of login attempts
+type LoginAttempt struct {
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	UserID        uuid.UUID `json:"user_id,omitempty"` // Nil when the email does not belong to a user
+	IPAddress     string    `json:"ip_address,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Succeeded     bool      `json:"succeeded"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// LoginThrottle holds the failed-attempt counter for an email address or client IP
+type LoginThrottle struct {
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
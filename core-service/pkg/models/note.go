@@ -8,12 +8,16 @@ import (
 
 // Note represents a note entry in the CRM
 type Note struct {
-	ID        uuid.UUID           `json:"id"`
-	Content   string              `json:"content"`
-	CreatedBy uuid.UUID           `json:"created_by"`
-	CreatedAt time.Time           `json:"created_at"`
-	UpdatedAt time.Time           `json:"updated_at"`
-	Records   []RecordAssociation `json:"records,omitempty"`
+	ID         uuid.UUID           `json:"id"`
+	Content    string              `json:"content"`
+	CreatedBy  uuid.UUID           `json:"created_by"`
+	UpdatedBy  uuid.UUID           `json:"updated_by"`
+	OwnerID    uuid.UUID           `json:"owner_id"`
+	Visibility string              `json:"visibility"`
+	Version    int64               `json:"version"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	Records    []RecordAssociation `json:"records,omitempty"`
 }
 
 // RecordAssociation represents a link between a note and a CRM record (Account, Contact, or Opportunity)
@@ -24,15 +28,15 @@ type RecordAssociation struct {
 
 // NoteCreate is used for creating a new note
 type NoteCreate struct {
-	Content   string              `json:"content" binding:"required"`
-	CreatedBy uuid.UUID           `json:"created_by" binding:"required"`
-	Records   []RecordAssociation `json:"records" binding:"required"`
+	Content    string              `json:"content" binding:"required"`
+	Records    []RecordAssociation `json:"records" binding:"required"`
+	Visibility string              `json:"visibility" binding:"omitempty,oneof=private team public"`
 }
 
 // NoteUpdate is used for updating an existing note
 type NoteUpdate struct {
-	Content   string    `json:"content"`
-	UpdatedBy uuid.UUID `json:"updated_by" binding:"required"`
+	Content    string `json:"content"`
+	Visibility string `json:"visibility" binding:"omitempty,oneof=private team public"`
 }
 
 // AddNoteAssociation is used to associate a note with a record
@@ -40,5 +44,4 @@ type AddNoteAssociation struct {
 	NoteID     uuid.UUID `json:"note_id" binding:"required"`
 	RecordID   uuid.UUID `json:"record_id" binding:"required"`
 	RecordType string    `json:"record_type" binding:"required"`
-	CreatedBy  uuid.UUID `json:"created_by" binding:"required"`
 }
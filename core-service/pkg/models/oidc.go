@@ -0,0 +1,8 @@
+package models
+
+// OIDCCallbackRequest is used to complete a single sign-on login with the parameters the provider
+// appended to the redirect URL
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
@@ -8,39 +8,46 @@ import (
 
 // Opportunity represents a sales opportunity in the CRM
 type Opportunity struct {
-	ID               uuid.UUID  `json:"id"`
-	OpportunityName  string     `json:"opportunity_name"`
-	AccountID        uuid.UUID  `json:"account_id,omitempty"`
-	PrimaryContactID uuid.UUID  `json:"primary_contact_id,omitempty"`
-	Stage            string     `json:"stage"`
-	Amount           float64    `json:"amount,omitempty"`
-	CloseDate        *time.Time `json:"close_date,omitempty"`
-	Probability      float64    `json:"probability,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
-	CreatedBy        uuid.UUID  `json:"created_by"`
+	ID               uuid.UUID              `json:"id"`
+	OpportunityName  string                 `json:"opportunity_name"`
+	AccountID        uuid.UUID              `json:"account_id,omitempty"`
+	PrimaryContactID uuid.UUID              `json:"primary_contact_id,omitempty"`
+	Stage            string                 `json:"stage"`
+	Amount           float64                `json:"amount,omitempty"`
+	CloseDate        *time.Time             `json:"close_date,omitempty"`
+	Probability      float64                `json:"probability,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+	CreatedBy        uuid.UUID              `json:"created_by"`
+	UpdatedBy        uuid.UUID              `json:"updated_by"`
+	OwnerID          uuid.UUID              `json:"owner_id"`
+	Visibility       string                 `json:"visibility"`
+	Version          int64                  `json:"version"`
+	CustomFields     map[string]interface{} `json:"custom_fields,omitempty"`
 }
 
 // OpportunityCreate is used for creating a new opportunity
 type OpportunityCreate struct {
-	OpportunityName  string    `json:"opportunity_name" binding:"required"`
-	AccountID        uuid.UUID `json:"account_id" binding:"required"`
-	PrimaryContactID uuid.UUID `json:"primary_contact_id"`
-	Stage            string    `json:"stage" binding:"required"`
-	Amount           float64   `json:"amount"`
-	CloseDate        string    `json:"close_date"`
-	Probability      float64   `json:"probability"`
-	CreatedBy        uuid.UUID `json:"created_by" binding:"required"`
+	OpportunityName  string                 `json:"opportunity_name" binding:"required"`
+	AccountID        uuid.UUID              `json:"account_id" binding:"required"`
+	PrimaryContactID uuid.UUID              `json:"primary_contact_id"`
+	Stage            string                 `json:"stage" binding:"required"`
+	Amount           float64                `json:"amount"`
+	CloseDate        string                 `json:"close_date"`
+	Probability      float64                `json:"probability"`
+	Visibility       string                 `json:"visibility" binding:"omitempty,oneof=private team public"`
+	CustomFields     map[string]interface{} `json:"custom_fields"` // Custom field name -> value
 }
 
 // OpportunityUpdate is used for updating an existing opportunity
 type OpportunityUpdate struct {
-	OpportunityName  string    `json:"opportunity_name"`
-	AccountID        uuid.UUID `json:"account_id"`
-	PrimaryContactID uuid.UUID `json:"primary_contact_id"`
-	Stage            string    `json:"stage"`
-	Amount           float64   `json:"amount"`
-	CloseDate        string    `json:"close_date"`
-	Probability      float64   `json:"probability"`
-	UpdatedBy        uuid.UUID `json:"updated_by" binding:"required"`
+	OpportunityName  string                 `json:"opportunity_name"`
+	AccountID        uuid.UUID              `json:"account_id"`
+	PrimaryContactID uuid.UUID              `json:"primary_contact_id"`
+	Stage            string                 `json:"stage"`
+	Amount           float64                `json:"amount"`
+	CloseDate        string                 `json:"close_date"`
+	Probability      float64                `json:"probability"`
+	Visibility       string                 `json:"visibility" binding:"omitempty,oneof=private team public"`
+	CustomFields     map[string]interface{} `json:"custom_fields"` // Custom field values to set; null removes a value
 }
@@ -0,0 +1,15 @@
+package models
+
+import "github.com/google/uuid"
+
+// Record visibility modes: who besides the owner can see an account, contact, opportunity or note
+const (
+	VisibilityPrivate = "private" // Only the owner
+	VisibilityTeam    = "team"    // The owner and the members of the owner's teams
+	VisibilityPublic  = "public"  // Every user
+)
+
+// OwnershipTransfer is used to give a record to another user
+type OwnershipTransfer struct {
+	OwnerID uuid.UUID `json:"owner_id" binding:"required"`
+}
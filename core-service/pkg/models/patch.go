@@ -0,0 +1,7 @@
+package models
+
+import "encoding/json"
+
+// MergePatch is a JSON Merge Patch (RFC 7396) document: fields it leaves out are unchanged and fields set to
+// null are cleared
+type MergePatch map[string]json.RawMessage
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions assigned to users
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	IsBuiltin   bool      `json:"is_builtin"`
+	UserCount   int       `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleCreate is used for creating a new role
+type RoleCreate struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// RoleUpdate is used for updating a role; the permissions replace the role's current permissions when given
+type RoleUpdate struct {
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
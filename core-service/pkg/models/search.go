@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// SearchResult is a single ranked match from a full-text search across CRM records
+type SearchResult struct {
+	RecordID   uuid.UUID `json:"record_id"`
+	RecordType string    `json:"record_type"` // "account", "contact", "opportunity", "note"
+	Title      string    `json:"title"`
+	Snippet    string    `json:"snippet"` // Matching text as escaped HTML, with terms wrapped in <mark></mark>
+	Rank       float64   `json:"rank"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashItem is a deleted record waiting in the trash to be restored or purged
+type TrashItem struct {
+	RecordID   uuid.UUID  `json:"record_id"`
+	RecordType string     `json:"record_type"` // "account", "contact", "opportunity", "note"
+	Title      string     `json:"title"`
+	OwnerID    uuid.UUID  `json:"owner_id"`
+	DeletedAt  time.Time  `json:"deleted_at"`
+	DeletedBy  uuid.UUID  `json:"deleted_by"`         // uuid.Nil when the user who deleted it has been deleted
+	PurgeAt    *time.Time `json:"purge_at,omitempty"` // When the record is deleted for good; omitted when the trash is kept
+}